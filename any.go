@@ -0,0 +1,47 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"reflect"
+)
+
+// LockAny campaigns on all the locks at once, and returns the index of the
+// first one acquired, cancelling (and releasing, if it won meanwhile) the others.
+// It returns -1 and the error of the last failing campaign if none succeeded.
+// Locks without LockContext or TryLock may be released in the background.
+func LockAny(ctx context.Context, locks ...Locker) (int, error) {
+	if len(locks) == 0 {
+		return -1, ErrNotAcquired
+	}
+	futures := make([]*Future, len(locks))
+	cases := make([]reflect.SelectCase, len(locks))
+	for i, lock := range locks {
+		futures[i] = AcquireAsync(ctx, lock)
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(futures[i].Done())}
+	}
+	won, err := -1, error(nil)
+	for remaining := len(cases); remaining > 0 && won < 0; remaining-- {
+		i, _, _ := reflect.Select(cases)
+		cases[i].Chan = reflect.Value{} // never selected again
+		if err = futures[i].Err(); err == nil {
+			won = i
+		}
+	}
+	for i, f := range futures {
+		if i == won {
+			continue
+		}
+		f.Cancel()
+		if f.Err() == nil {
+			f.Lock().Unlock()
+		}
+	}
+	if won < 0 {
+		return -1, err
+	}
+	return won, nil
+}
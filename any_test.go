@@ -0,0 +1,41 @@
+package locking_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLockAny(t *testing.T) {
+	a, b, c := make(chanLock, 1), make(chanLock, 1), make(chanLock, 1)
+	a.Lock()
+	c.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if i, err := locking.LockAny(ctx, a, b, c); err != nil || i != 1 {
+		t.Fatalf("got %d, %v; wanted 1", i, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if i, err := locking.LockAny(ctx, a, b, c); err == nil || i != -1 {
+		t.Fatalf("got %d, %v; wanted failure", i, err)
+	}
+
+	// of several free locks, only the winner stays held
+	a, b, c = make(chanLock, 1), make(chanLock, 1), make(chanLock, 1)
+	i, err := locking.LockAny(context.Background(), a, b, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// plain Lockers are released in the background
+	deadline := time.Now().Add(time.Second)
+	for len(a)+len(b)+len(c) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(a) + len(b) + len(c); n != 1 {
+		t.Errorf("%d locks held after winning %d", n, i)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// LockArena is a directory of pre-created, numbered lock files
+// (lock.0 ... lock.<n-1>), so sharded and counting locks do not create files
+// at acquisition time.
+type LockArena struct {
+	dir string
+	n   int
+	fh  *os.File // the directory
+}
+
+// CreateLockArena creates dir (if needed) and the n lock files in it, in one
+// pass with one directory sync. Existing files are kept. The directory
+// stays open until Close.
+func CreateLockArena(dir string, n int) (*LockArena, error) {
+	if n < 1 {
+		return nil, errors.New("arena size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	dfh, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	a := &LockArena{dir: dir, n: n, fh: dfh}
+	for i := 0; i < n; i++ {
+		fh, err := os.OpenFile(a.Path(i), os.O_RDONLY|os.O_CREATE, 0644)
+		if err != nil {
+			dfh.Close()
+			return nil, err
+		}
+		fh.Close()
+	}
+	if err = dfh.Sync(); err != nil {
+		dfh.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Len returns the number of lock files
+func (a *LockArena) Len() int { return a.n }
+
+// Path returns the path of the i-th lock file
+func (a *LockArena) Path(i int) string {
+	return filepath.Join(a.dir, "lock."+strconv.Itoa(i))
+}
+
+// FLock returns an FLock on the i-th lock file
+func (a *LockArena) FLock(i int) (*FLock, error) {
+	if i < 0 || i >= a.n {
+		return nil, errors.New("arena index out of range")
+	}
+	return NewFLock(a.Path(i))
+}
+
+// Semaphore returns a SemaphoreLock whose slots are the lock files of the arena
+func (a *LockArena) Semaphore() *SemaphoreLock {
+	return &SemaphoreLock{path: filepath.Join(a.dir, "lock"), capacity: a.n}
+}
+
+// Close closes the directory
+func (a *LockArena) Close() error { return a.fh.Close() }
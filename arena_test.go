@@ -0,0 +1,52 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLockArena(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, err := locking.CreateLockArena(filepath.Join(dir, "arena"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	for i := 0; i < a.Len(); i++ {
+		if _, err := os.Stat(a.Path(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lock, err := a.FLock(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.FLock(4); err == nil {
+		t.Error("index out of range accepted")
+	}
+
+	sem := a.Semaphore()
+	for i := 0; i < 4; i++ {
+		if ok, err := sem.TryAcquire(); !ok || err != nil {
+			t.Fatalf("%d. slot: %t, %v", i, ok, err)
+		}
+	}
+	if ok, _ := sem.TryAcquire(); ok {
+		t.Error("fifth slot acquired")
+	}
+	if des, _ := ioutil.ReadDir(filepath.Join(dir, "arena")); len(des) != 4 {
+		t.Errorf("%d files in the arena, wanted 4", len(des))
+	}
+}
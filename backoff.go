@@ -0,0 +1,88 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTimeout is returned when the MaxWait of a Backoff is spent
+var ErrTimeout = errors.New("lock wait timed out")
+
+// Backoff configures the sleeps between the attempts of the polling locks
+// (DirLock, PortLock, SocketLock, BootDirLock, LeaseLock).
+type Backoff struct {
+	Initial    time.Duration // first sleep, 1s if zero
+	Multiplier float64       // each sleep is in [previous, Multiplier*previous), 2 if less than 1
+	Max        time.Duration // sleep cap, unbounded if zero
+	MaxWait    time.Duration // give up with ErrTimeout after this much waiting, never if zero
+}
+
+// DefaultBackoff is used by the polling locks when not Wrapped with a Backoff.
+// Change it before using the locks; later use SetDefaultBackoff.
+var DefaultBackoff = Backoff{Initial: time.Second, Multiplier: 2}
+
+// Wrap returns lock, acquired with the backoff of b: Lock and LockContext
+// poll TryLock. Locks without TryLock are waited for with LockContext,
+// bounded by MaxWait only.
+func (b Backoff) Wrap(lock Locker) TryLocker {
+	return backoffLock{Locker: lock, backoff: b}
+}
+
+func (b Backoff) start(name string) *expBackoff {
+	d := b.Initial
+	if d <= 0 {
+		d = time.Second
+	}
+	return &expBackoff{Duration: d, cfg: b, name: name, start: time.Now(), attempts: 1}
+}
+
+type backoffLock struct {
+	Locker
+	backoff Backoff
+}
+
+func (l backoffLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+func (l backoffLock) LockContext(ctx context.Context) error {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		if l.backoff.MaxWait > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, l.backoff.MaxWait)
+			defer cancel()
+		}
+		err := LockContext(ctx, l.Locker)
+		if err == context.DeadlineExceeded && ctx.Err() == err {
+			err = ErrTimeout
+		}
+		return err
+	}
+	eb := l.backoff.start("")
+	for {
+		ok, err := tl.TryLock()
+		if ok && err == nil {
+			return nil
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (l backoffLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	return tl.TryLock()
+}
@@ -0,0 +1,50 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	holder, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := locking.Backoff{Initial: time.Millisecond, Multiplier: 1.5, Max: 5 * time.Millisecond, MaxWait: 50 * time.Millisecond}
+	lock := b.Wrap(holder)
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	if err = holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err = lock.Lock(); err != locking.ErrTimeout {
+		t.Fatalf("got %v, wanted ErrTimeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("timed out after %s", d)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		holder.Unlock()
+	}()
+	start = time.Now()
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	// the sleeps are capped at Max
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("acquired after %s", d)
+	}
+	lock.Unlock()
+}
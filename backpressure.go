@@ -0,0 +1,145 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned by the locks wrapped by Admission instead of queueing
+// behind a hot lock: retry later
+var ErrBusy = errors.New("lock busy, retry later")
+
+// QueueDepth returns the number of Lock calls of this process blocked on the
+// named lock (path or host:port)
+func QueueDepth(name string) int {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+	return len(waiters[name])
+}
+
+// EstimatedWait estimates the wait of a new Lock call of the named lock:
+// the median of its recent contended waits, for each waiter in the queue.
+// It is zero without waiters.
+func EstimatedWait(name string) time.Duration {
+	depth := QueueDepth(name)
+	if depth == 0 {
+		return 0
+	}
+	statsMu.Lock()
+	s := stats[name]
+	var median time.Duration
+	if s != nil {
+		median = s.Percentile(50)
+	}
+	statsMu.Unlock()
+	if oldest := OldestWaiter(name); median < oldest {
+		// the queue is stuck longer than usual
+		median = oldest
+	}
+	return time.Duration(depth) * median
+}
+
+// WatchQueues checks the queues of all locks in every interval, and calls
+// changed with the depth and estimated wait of each lock whose queue depth
+// changed since the previous check (ordered by name) - the zero depth included.
+func WatchQueues(interval time.Duration, changed func(name string, depth int, wait time.Duration)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		last := make(map[string]int)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			now := make(map[string]int, len(last))
+			waitersMu.Lock()
+			for name, ws := range waiters {
+				now[name] = len(ws)
+			}
+			waitersMu.Unlock()
+			var names []string
+			for name, depth := range now {
+				if last[name] != depth {
+					names = append(names, name)
+				}
+			}
+			for name := range last {
+				if _, ok := now[name]; !ok {
+					names = append(names, name)
+				}
+			}
+			last = now
+			sort.Strings(names)
+			for _, name := range names {
+				changed(name, now[name], EstimatedWait(name))
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// Admission sheds load: the locks it wraps return ErrBusy from Lock at once,
+// when the queue of the lock is too deep or the estimated wait is too long.
+// The zero value admits everything.
+//
+// The limits are advisory: the queue is checked before joining it, not
+// atomically with it, so concurrent Locks may all be admitted, and the
+// queue may exceed MaxQueue by their number.
+type Admission struct {
+	MaxQueue int           // maximal number of waiters in this process to join
+	MaxWait  time.Duration // maximal estimated wait to join
+}
+
+// Wrap returns lock, whose name (path or host:port) is watched for its queue
+func (a Admission) Wrap(name string, lock Locker) TryLocker {
+	return admissionLock{Locker: lock, name: name, admission: a}
+}
+
+func (a Admission) admit(name string) error {
+	if a.MaxQueue > 0 && QueueDepth(name) >= a.MaxQueue {
+		return ErrBusy
+	}
+	if a.MaxWait > 0 && EstimatedWait(name) > a.MaxWait {
+		return ErrBusy
+	}
+	return nil
+}
+
+type admissionLock struct {
+	Locker
+	name      string
+	admission Admission
+}
+
+func (l admissionLock) Lock() error {
+	if err := l.admission.admit(l.name); err != nil {
+		return err
+	}
+	return l.Locker.Lock()
+}
+
+func (l admissionLock) LockContext(ctx context.Context) error {
+	if err := l.admission.admit(l.name); err != nil {
+		return err
+	}
+	return LockContext(ctx, l.Locker)
+}
+
+func (l admissionLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	return tl.TryLock()
+}
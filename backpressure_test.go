@@ -0,0 +1,73 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestBackpressure(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+	name := fh.Name()
+	holder, err := locking.NewFLock(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var depths []int
+	stop := locking.WatchQueues(5*time.Millisecond, func(n string, depth int, wait time.Duration) {
+		if n == name {
+			mu.Lock()
+			depths = append(depths, depth)
+			mu.Unlock()
+		}
+	})
+	defer stop()
+
+	adm := locking.Admission{MaxQueue: 1}
+	waiter, err := locking.NewFLock(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- adm.Wrap(name, waiter).Lock() }()
+	time.Sleep(50 * time.Millisecond)
+	if d := locking.QueueDepth(name); d != 1 {
+		t.Errorf("queue depth %d, wanted 1", d)
+	}
+	oldest := locking.OldestWaiter(name)
+	if w := locking.EstimatedWait(name); w < oldest {
+		t.Errorf("estimated wait %s, wanted at least the oldest wait %s", w, oldest)
+	}
+	shed, _ := locking.NewFLock(name)
+	if err = adm.Wrap(name, shed).Lock(); err != locking.ErrBusy {
+		t.Errorf("got %v, wanted ErrBusy", err)
+	}
+	holder.Unlock()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	waiter.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	if d, w := locking.QueueDepth(name), locking.EstimatedWait(name); d != 0 || w != 0 {
+		t.Errorf("depth %d, wait %s after acquisition", d, w)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(depths) != 2 || depths[0] != 1 || depths[1] != 0 {
+		t.Errorf("queue changes %v, wanted [1 0]", depths)
+	}
+}
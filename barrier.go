@@ -0,0 +1,117 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// InvalidateCache makes the following reads of path see the writes of the
+// other clients of a shared (NFS-like) filesystem, finished before: by the
+// close-to-open consistency, opening the file revalidates the cached
+// attributes, and drops the cached data if the file has changed.
+// The directory of path is revalidated too, for the created and removed files.
+//
+// A missing path is not an error.
+func InvalidateCache(path string) error {
+	for _, p := range []string{filepath.Dir(path), path} {
+		fh, err := os.Open(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		_, err = fh.Stat()
+		if cerr := fh.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlushCache writes the cached writes of path back to the server, so the next
+// opener on another client sees them. A missing path is not an error.
+func FlushCache(path string) error {
+	fh, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	err = fh.Sync()
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Barrier puts cache barriers around the critical section of a lock,
+// guarding files on a shared filesystem: the Paths are invalidated after
+// acquisition, and flushed before release, so the holder reads what the
+// previous holder has written, despite the client side caches.
+type Barrier struct {
+	Paths []string
+}
+
+// Wrap returns lock with the cache barriers
+func (b Barrier) Wrap(lock Locker) TryLocker {
+	return &barrierLock{Locker: lock, paths: b.Paths}
+}
+
+type barrierLock struct {
+	Locker
+	paths []string
+}
+
+func (l *barrierLock) Lock() error {
+	if err := l.Locker.Lock(); err != nil {
+		return err
+	}
+	return l.acquired()
+}
+
+func (l *barrierLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	if ok, err := tl.TryLock(); !ok || err != nil {
+		return ok, err
+	}
+	if err := l.acquired(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// acquired invalidates the caches, releasing the lock on error
+func (l *barrierLock) acquired() error {
+	for _, p := range l.paths {
+		if err := InvalidateCache(p); err != nil {
+			l.Locker.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *barrierLock) Unlock() error {
+	var err error
+	for _, p := range l.paths {
+		if ferr := FlushCache(p); err == nil {
+			err = ferr
+		}
+	}
+	if uerr := l.Locker.Unlock(); err == nil {
+		err = uerr
+	}
+	return err
+}
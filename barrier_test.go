@@ -0,0 +1,55 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestBarrier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := filepath.Join(dir, "data")
+	newLock := func() locking.TryLocker {
+		fl, err := locking.NewFLockCreate(filepath.Join(dir, "lock"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return locking.Barrier{Paths: []string{data}}.Wrap(fl)
+	}
+	a, b := newLock(), newLock()
+	// a missing file is fine
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(data, []byte("a was here"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("b: %t, %v", ok, err)
+	}
+	if got, err := ioutil.ReadFile(data); err != nil || string(got) != "a was here" {
+		t.Errorf("b read %q, %v", got, err)
+	}
+	b.Unlock()
+
+	if err = locking.InvalidateCache(filepath.Join(dir, "missing", "file")); err != nil {
+		t.Errorf("InvalidateCache of missing: %v", err)
+	}
+	if err = locking.FlushCache(data); err != nil {
+		t.Errorf("FlushCache: %v", err)
+	}
+}
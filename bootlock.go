@@ -0,0 +1,127 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// BootDirLock is a directory lock which records the boot ID of its holder,
+// and is considered stale after a reboot - so the locks left behind by a
+// power loss do not need manual cleanup before the services start.
+//
+// Do not mix it with a plain DirLock on the same path.
+type BootDirLock struct {
+	DirLock
+}
+
+// NewBootDirLock creates a new boot-scoped directory lock (unlocked first)
+func NewBootDirLock(path string) (BootDirLock, error) {
+	lock, err := NewDirLock(path)
+	return BootDirLock{lock}, err
+}
+
+// Lock acquires the lock, blocking
+func (lock BootDirLock) Lock() error {
+	return lock.LockContext(context.Background())
+}
+
+// LockContext acquires the lock, until ctx is done
+func (lock BootDirLock) LockContext(ctx context.Context) error {
+	eb := newExpBackoff(string(lock.DirLock))
+	defer beginWait(string(lock.DirLock))()
+	for {
+		ok, err := lock.TryLock()
+		if ok && err == nil {
+			eb.Done()
+			return nil
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// TryLock acquires the lock, non-blocking.
+// A lock held in a previous boot is broken.
+func (lock BootDirLock) TryLock() (bool, error) {
+	boot, err := bootID()
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < 2; i++ {
+		ok, err := lock.create(boot)
+		if ok || err != nil {
+			return ok, err
+		}
+		if ok, err = lock.breakStale(boot); !ok || err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Unlock releases the lock, if it is held by this process (see DirLock.Unlock)
+func (lock BootDirLock) Unlock() error {
+	return lock.DirLock.Unlock()
+}
+
+// create prepares the lock directory with the boot ID aside,
+// and renames it into place, so the ID is never missing from a lock.
+func (lock BootDirLock) create(boot []byte) (bool, error) {
+	path := string(lock.DirLock)
+	tmp, err := ioutil.TempDir(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return false, err
+	}
+	if err = ioutil.WriteFile(filepath.Join(tmp, "boot_id"), boot, 0600); err == nil {
+		err = writeOwner(tmp)
+	}
+	if err == nil {
+		if err = os.Rename(tmp, path); err == nil {
+			return true, nil
+		}
+	}
+	os.RemoveAll(tmp)
+	if os.IsExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// breakStale removes the lock if it has been created in another boot.
+// The check and the removal run under the flock of the parent directory,
+// so a fresh lock cannot be removed by a racing breaker.
+func (lock BootDirLock) breakStale(boot []byte) (bool, error) {
+	path := string(lock.DirLock)
+	dh, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return false, err
+	}
+	defer dh.Close()
+	if err = syscall.Flock(int(dh.Fd()), syscall.LOCK_EX); err != nil {
+		return false, err
+	}
+	defer syscall.Flock(int(dh.Fd()), syscall.LOCK_UN)
+	held, err := ioutil.ReadFile(filepath.Join(path, "boot_id"))
+	if err != nil {
+		if os.IsNotExist(err) { // released meanwhile
+			return true, nil
+		}
+		return false, err
+	}
+	if bytes.Equal(held, boot) {
+		return false, nil
+	}
+	return true, os.RemoveAll(path)
+}
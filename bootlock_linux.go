@@ -0,0 +1,16 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"bytes"
+	"io/ioutil"
+)
+
+// bootID returns the random ID the kernel generates at each boot
+func bootID() ([]byte, error) {
+	b, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	return bytes.TrimSpace(b), err
+}
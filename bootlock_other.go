@@ -0,0 +1,14 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package locking
+
+import "errors"
+
+// bootID is implemented only on Linux.
+func bootID() ([]byte, error) {
+	return nil, errors.New("boot ID is not available on this platform")
+}
@@ -0,0 +1,47 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestBootDirLock(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("boot ID is available on Linux only")
+	}
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewBootDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	// left behind by a previous boot
+	stale := filepath.Join(dir, ".lock")
+	if err = os.Mkdir(stale, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(stale, "boot_id"), []byte("previous"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lock.TryLock(); !ok || err != nil {
+		t.Fatalf("stale lock not broken: %t, %v", ok, err)
+	}
+	if ok, err := lock.TryLock(); ok || err != nil {
+		t.Errorf("current lock broken: %t, %v", ok, err)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned while a Breaker is open
+var ErrCircuitOpen = errors.New("lock backend circuit open")
+
+// Breaker is a circuit breaker for network and database backed locks:
+// after Threshold consecutive backend failures it fails fast with
+// ErrCircuitOpen (or uses Fallback) for Cooldown, then lets one attempt through
+// to probe the backend. Contention (TryLock returning false) is not a failure.
+//
+// Share one Breaker between the locks of the same backend.
+type Breaker struct {
+	Threshold int           // consecutive failures to open, defaults to 5
+	Cooldown  time.Duration // time to stay open, defaults to 30s
+	Fallback  Locker        // local lock used while open, with reduced guarantees
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// Wrap returns lock guarded by the breaker
+func (b *Breaker) Wrap(lock Locker) TryLocker {
+	return &breakerLock{Locker: lock, breaker: b}
+}
+
+// Open reports whether the breaker is open currently
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold() && time.Now().Before(b.openUntil)
+}
+
+func (b *Breaker) threshold() int {
+	if b.Threshold <= 0 {
+		return 5
+	}
+	return b.Threshold
+}
+
+// allow reports whether an attempt may go to the backend
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold() {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true // half-open: one trial
+	return true
+}
+
+func (b *Breaker) result(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold() {
+		cooldown := b.Cooldown
+		if cooldown <= 0 {
+			cooldown = 30 * time.Second
+		}
+		b.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+type breakerLock struct {
+	Locker
+	breaker *Breaker
+
+	mu   sync.Mutex
+	held Locker // the lock actually acquired: the backend or the fallback
+}
+
+func (l *breakerLock) Lock() error {
+	if !l.breaker.allow() {
+		return l.fallback(func(fb Locker) error { return fb.Lock() })
+	}
+	err := l.Locker.Lock()
+	l.breaker.result(err)
+	if err != nil {
+		return err
+	}
+	l.setHeld(l.Locker)
+	return nil
+}
+
+func (l *breakerLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	if !l.breaker.allow() {
+		var got bool
+		err := l.fallback(func(fb Locker) error {
+			ftl, ok := fb.(TryLocker)
+			if !ok {
+				return ErrNoTryLock
+			}
+			var err error
+			got, err = ftl.TryLock()
+			if !got && err == nil {
+				err = errNotAcquired
+			}
+			return err
+		})
+		if err == errNotAcquired {
+			return false, nil
+		}
+		return got, err
+	}
+	ok, err := tl.TryLock()
+	l.breaker.result(err)
+	if ok && err == nil {
+		l.setHeld(l.Locker)
+	}
+	return ok, err
+}
+
+var errNotAcquired = errors.New("not acquired")
+
+func (l *breakerLock) fallback(lock func(Locker) error) error {
+	fb := l.breaker.Fallback
+	if fb == nil {
+		return ErrCircuitOpen
+	}
+	if err := lock(fb); err != nil {
+		return err
+	}
+	l.setHeld(fb)
+	return nil
+}
+
+func (l *breakerLock) setHeld(lock Locker) {
+	l.mu.Lock()
+	l.held = lock
+	l.mu.Unlock()
+}
+
+func (l *breakerLock) Unlock() error {
+	l.mu.Lock()
+	held := l.held
+	l.held = nil
+	l.mu.Unlock()
+	if held == nil {
+		return l.Locker.Unlock()
+	}
+	return held.Unlock()
+}
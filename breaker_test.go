@@ -0,0 +1,46 @@
+package locking_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+// flaky is a lock whose backend is down
+type flaky struct{ calls int }
+
+func (f *flaky) Lock() error            { f.calls++; return errors.New("backend down") }
+func (f *flaky) TryLock() (bool, error) { f.calls++; return false, errors.New("backend down") }
+func (f *flaky) Unlock() error          { return nil }
+
+func TestBreaker(t *testing.T) {
+	backend := &flaky{}
+	b := &locking.Breaker{Threshold: 3, Cooldown: 50 * time.Millisecond}
+	lock := b.Wrap(backend)
+	for i := 0; i < 3; i++ {
+		if err := lock.Lock(); err == nil || err == locking.ErrCircuitOpen {
+			t.Fatalf("%d. wanted backend error, got %v", i, err)
+		}
+	}
+	if err := lock.Lock(); err != locking.ErrCircuitOpen {
+		t.Fatalf("wanted ErrCircuitOpen, got %v", err)
+	}
+	if backend.calls != 3 {
+		t.Errorf("backend called %d times while open", backend.calls)
+	}
+
+	b.Fallback = locking.NewPortLock(freePort(t))
+	if err := testLock(lock); err != nil {
+		t.Fatalf("fallback: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := lock.Lock(); err == locking.ErrCircuitOpen {
+		t.Error("breaker did not let a probe through after the cooldown")
+	}
+	if !b.Open() {
+		t.Error("failed probe should reopen the breaker")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTooManyHeld is returned when Budget.MaxHeld locks are held already
+	ErrTooManyHeld = errors.New("too many locks held")
+	// ErrWaitBudget is returned when Budget.MaxWait is spent in the current minute
+	ErrWaitBudget = errors.New("lock wait budget exhausted")
+	// ErrNotHeld is returned by Unlock when the lock is not held through it
+	ErrNotHeld = errors.New("lock not held")
+)
+
+// Budget limits the locking of a whole process: share one Budget
+// between all code paths, and Wrap every lock with it.
+// The zero value is unlimited.
+type Budget struct {
+	MaxHeld int           // maximal number of locks held (or being acquired) at once
+	MaxWait time.Duration // maximal total time spent blocked in Lock per minute
+
+	mu     sync.Mutex
+	held   int
+	window time.Time
+	waited time.Duration
+}
+
+// Wrap returns lock, with its acquisitions limited by the budget
+func (b *Budget) Wrap(lock Locker) TryLocker {
+	return &budgetLock{Locker: lock, budget: b}
+}
+
+// Held returns the number of locks held (or being acquired) currently
+func (b *Budget) Held() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.held
+}
+
+func (b *Budget) reserve(wait bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.MaxHeld > 0 && b.held >= b.MaxHeld {
+		return ErrTooManyHeld
+	}
+	if wait && b.MaxWait > 0 {
+		if now := time.Now(); now.Sub(b.window) >= time.Minute {
+			b.window, b.waited = now, 0
+		}
+		if b.waited >= b.MaxWait {
+			return ErrWaitBudget
+		}
+	}
+	b.held++
+	return nil
+}
+
+// release ends the reservation of l: the acquired ones are held by l,
+// until its Unlock
+func (b *Budget) release(l *budgetLock, waited time.Duration, acquired bool) {
+	b.mu.Lock()
+	b.waited += waited
+	if acquired {
+		l.held++
+	} else {
+		b.held--
+	}
+	b.mu.Unlock()
+}
+
+type budgetLock struct {
+	Locker
+	budget *Budget
+	held   int // acquisitions not unlocked yet, guarded by budget.mu
+}
+
+func (l *budgetLock) Lock() error {
+	if err := l.budget.reserve(true); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := l.Locker.Lock()
+	l.budget.release(l, time.Since(start), err == nil)
+	return err
+}
+
+func (l *budgetLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	if err := l.budget.reserve(false); err != nil {
+		return false, err
+	}
+	ok, err := tl.TryLock()
+	l.budget.release(l, 0, ok && err == nil)
+	return ok, err
+}
+
+// Unlock returns ErrNotHeld, without unlocking the wrapped lock, when it
+// is not acquired through l
+func (l *budgetLock) Unlock() error {
+	l.budget.mu.Lock()
+	if l.held == 0 {
+		l.budget.mu.Unlock()
+		return ErrNotHeld
+	}
+	l.held--
+	l.budget.held--
+	l.budget.mu.Unlock()
+	return l.Locker.Unlock()
+}
@@ -0,0 +1,32 @@
+package locking_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestBudget(t *testing.T) {
+	b := &locking.Budget{MaxHeld: 1, MaxWait: time.Millisecond}
+	first := b.Wrap(locking.NewPortLock(freePort(t)))
+	if err := first.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	second := b.Wrap(locking.NewPortLock(freePort(t)))
+	if _, err := second.TryLock(); err != locking.ErrTooManyHeld {
+		t.Errorf("wanted ErrTooManyHeld, got %v", err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Held() != 0 {
+		t.Errorf("held=%d after Unlock", b.Held())
+	}
+	if err := first.Unlock(); err != locking.ErrNotHeld || b.Held() != 0 {
+		t.Errorf("second Unlock: %v, held=%d", err, b.Held())
+	}
+	if err := testLock(second); err != nil {
+		t.Fatal(err)
+	}
+}
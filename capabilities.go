@@ -0,0 +1,30 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+// Capabilities describe the guarantees of a lock type.
+//
+// The claims are verified by the crash tests of the stress package.
+type Capabilities struct {
+	// AutoRelease is true if the OS releases the lock when the holder process dies
+	// (even by SIGKILL); false if the stale lock must be broken.
+	AutoRelease bool
+	// WakeOnRelease is true if a blocked Lock wakes up as soon as the lock is
+	// released (it waits in the kernel), not after a backoff sleep.
+	WakeOnRelease bool
+}
+
+// CapabilitiesOf returns the capabilities of lock
+func CapabilitiesOf(lock Locker) Capabilities {
+	switch lock.(type) {
+	case *FLock, *FcntlLock, *RWFLock, *PooledFLock:
+		return Capabilities{AutoRelease: true, WakeOnRelease: true}
+	case *ShmLock:
+		return Capabilities{WakeOnRelease: true}
+	case *PortLock, *SocketLock, *PIDFileLock, *SemaphoreLock:
+		return Capabilities{AutoRelease: true}
+	}
+	return Capabilities{}
+}
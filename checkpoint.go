@@ -0,0 +1,78 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// LeaseState is the state of a held LeaseLock, saved with the checkpoint
+// of the process (CRIU, job pause), to resume holding the lease at the
+// restore with RestoreLeaseLock. It marshals to JSON.
+type LeaseState struct {
+	Backend string    `json:"backend"` // "lease"
+	Path    string    `json:"path"`
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"` // at the checkpoint
+	TTL     Duration  `json:"ttl"`
+}
+
+// Checkpoint returns the state of the held lease. The renewal goes on.
+func (l *LeaseLock) Checkpoint() (LeaseState, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stop == nil {
+		return LeaseState{}, errors.New("lease not held")
+	}
+	token, expires, err := readLease(l.path)
+	if err != nil {
+		return LeaseState{}, err
+	}
+	if token != l.token {
+		return LeaseState{}, ErrLeaseLost
+	}
+	return LeaseState{Backend: "lease", Path: l.path, Token: token, Expires: expires, TTL: Duration(l.ttl.get())}, nil
+}
+
+// RestoreLeaseLock returns the LeaseLock of state, holding the lease again
+// if it is still the one of state, and has not lapsed meanwhile: then it
+// is renewed at once. It returns ErrLeaseLost otherwise (the lease may
+// have been stolen) - and the LeaseLock, unlocked; ErrBadTTL for a state
+// without a TTL.
+func RestoreLeaseLock(state LeaseState) (*LeaseLock, error) {
+	if state.Backend != "lease" {
+		return nil, errors.New("not a lease state: " + state.Backend)
+	}
+	if state.TTL <= 0 {
+		return nil, ErrBadTTL
+	}
+	l := NewLeaseLock(state.Path, time.Duration(state.TTL))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	token, expires, err := readLease(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrLeaseLost
+		}
+		return l, err
+	}
+	// the hold ended at the lapse: another process may be stealing it
+	if token != state.Token || !time.Now().Before(expires) {
+		return l, ErrLeaseLost
+	}
+	ttl := l.ttl.get()
+	if err = replaceLease(l.path, token, time.Now().Add(ttl)); err != nil {
+		return l, err
+	}
+	l.token = token
+	l.lost = make(chan struct{})
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.renew(token, ttl, l.lost, l.stop, l.done)
+	debugf(1, "lease %s: restored %s", l.path, token)
+	return l, nil
+}
@@ -0,0 +1,71 @@
+package locking_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLeaseCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lease")
+	// long enough not to be renewed during the test by the checkpointed
+	// lock, which stands for the process gone at the checkpoint
+	lock := locking.NewLeaseLock(path, time.Minute)
+	if _, err = lock.Checkpoint(); err == nil {
+		t.Error("checkpoint of a lease not held")
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := lock.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the restored process
+	var restoredState locking.LeaseState
+	if err = json.Unmarshal(b, &restoredState); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := locking.RestoreLeaseLock(restoredState)
+	if err != nil {
+		t.Fatalf("restore %s: %v", b, err)
+	}
+	if ok, _ := locking.NewLeaseLock(path, time.Second).TryLock(); ok {
+		t.Fatal("another got the restored lease")
+	}
+	if err = restored.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Unlock(); err != locking.ErrLeaseLost {
+		t.Errorf("the checkpointed lock: got %v, wanted ErrLeaseLost", err)
+	}
+
+	// restored after another took it
+	other := locking.NewLeaseLock(path, time.Second)
+	if err = other.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Unlock()
+	if _, err = locking.RestoreLeaseLock(state); err != locking.ErrLeaseLost {
+		t.Errorf("got %v, wanted ErrLeaseLost", err)
+	}
+	state.TTL = 0
+	if _, err = locking.RestoreLeaseLock(state); err != locking.ErrBadTTL {
+		t.Errorf("without a TTL: got %v, wanted ErrBadTTL", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Do runs fn holding lock: the lock is released when fn returns or panics.
+// The error of fn takes precedence over the error of Unlock.
+func Do(lock Locker, fn func() error) (err error) {
+	if err = lock.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if uerr := lock.Unlock(); err == nil {
+			err = uerr
+		}
+	}()
+	return fn()
+}
+
+// DoContext is Do, acquiring lock until ctx is done, and passing ctx to fn:
+// suited for errgroup tasks, with the context of the group.
+func DoContext(ctx context.Context, lock Locker, fn func(context.Context) error) (err error) {
+	if err = LockContext(ctx, lock); err != nil {
+		return err
+	}
+	defer func() {
+		if uerr := lock.Unlock(); err == nil {
+			err = uerr
+		}
+	}()
+	return fn(ctx)
+}
+
+var (
+	cleanupMu    sync.Mutex
+	cleanupLocks = make(map[*cleanupEntry]struct{})
+)
+
+type cleanupEntry struct{ lock Locker }
+
+// RegisterCleanup makes ReleaseAll (and the signals of ReleaseOnSignal)
+// release lock - for the locks the OS does not release at exit (DirLock,
+// LeaseLock ...).
+//
+// Call unregister when the lock is released (or to opt out).
+func RegisterCleanup(lock Locker) (unregister func()) {
+	e := &cleanupEntry{lock: lock}
+	cleanupMu.Lock()
+	cleanupLocks[e] = struct{}{}
+	cleanupMu.Unlock()
+	return func() {
+		cleanupMu.Lock()
+		delete(cleanupLocks, e)
+		cleanupMu.Unlock()
+	}
+}
+
+// ReleaseOnSignal makes sigs (SIGINT and SIGTERM if none given) release the
+// locks registered with RegisterCleanup: at the signal the locks are
+// released, then the signal is delivered again, so the process exits as
+// without ReleaseOnSignal. Call it once.
+//
+// It is for the programs without an own handler for sigs: that handler
+// gets the signal too, and the locks would be released while it may still
+// be inside the critical section. Such a program should call ReleaseAll at
+// the end of its handler instead.
+func ReleaseOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		sig := <-c
+		ReleaseAll()
+		signal.Stop(c)
+		syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+	}()
+}
+
+// ReleaseAll unlocks and unregisters all the locks registered with
+// RegisterCleanup, returning the first error. Call it before os.Exit.
+func ReleaseAll() error {
+	cleanupMu.Lock()
+	entries := cleanupLocks
+	cleanupLocks = make(map[*cleanupEntry]struct{})
+	cleanupMu.Unlock()
+	var err error
+	for e := range entries {
+		if uerr := e.lock.Unlock(); uerr != nil && err == nil {
+			err = uerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package locking_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestDo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errFn := errors.New("fn failed")
+	if err = locking.Do(lock, func() error {
+		if ok, _ := lock.TryLock(); ok {
+			t.Error("not held in fn")
+		}
+		return errFn
+	}); err != errFn {
+		t.Errorf("got %v, wanted the error of fn", err)
+	}
+	func() {
+		defer func() { recover() }()
+		locking.Do(lock, func() error { panic("boom") })
+	}()
+	if ok, err := lock.TryLock(); !ok || err != nil {
+		t.Fatalf("not released after a panic: %t, %v", ok, err)
+	}
+	lock.Unlock()
+}
+
+func TestDoContext(t *testing.T) {
+	lock := make(chanLock, 1)
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, 1)
+	if err := locking.DoContext(ctx, lock, func(ctx context.Context) error {
+		if len(lock) != 1 {
+			t.Error("not held in fn")
+		}
+		if ctx.Value(key{}) != 1 {
+			t.Error("not the context of DoContext")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(lock) != 0 {
+		t.Fatal("not released")
+	}
+
+	lock.Lock()
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := locking.DoContext(ctx, lock, func(context.Context) error {
+		t.Error("fn called without the lock")
+		return nil
+	}); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted DeadlineExceeded", err)
+	}
+}
+
+func TestRegisterCleanup(t *testing.T) {
+	if os.Getenv("LOCK_TEST_CLEANUP") != "" {
+		lock, _ := locking.NewDirLock(os.Getenv("LOCK_TEST_CLEANUP"))
+		if err := lock.Lock(); err != nil {
+			os.Exit(2)
+		}
+		locking.RegisterCleanup(lock)
+		locking.ReleaseOnSignal(syscall.SIGTERM)
+		os.Stdout.Write([]byte("locked\n"))
+		select {}
+	}
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = os.Mkdir(filepath.Join(dir, "b"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := locking.NewDirLock(dir)
+	b, _ := locking.NewDirLock(filepath.Join(dir, "b"))
+	for _, l := range []locking.DirLock{a, b} {
+		if err = l.Lock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locking.RegisterCleanup(a)
+	locking.RegisterCleanup(b)()
+	if err = locking.ReleaseAll(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := a.TryLock(); !ok {
+		t.Error("registered lock not released")
+	}
+	a.Unlock()
+	if ok, _ := b.TryLock(); ok {
+		t.Error("unregistered lock released")
+	}
+	b.Unlock()
+
+	// released at SIGTERM
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRegisterCleanup$")
+	cmd.Env = append(os.Environ(), "LOCK_TEST_CLEANUP="+dir)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 7)
+	if _, err = out.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+	err = cmd.Wait()
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || !ws.Signaled() || ws.Signal() != syscall.SIGTERM {
+		t.Errorf("child did not die of SIGTERM: %v", err)
+	}
+	if ok, _ := a.TryLock(); !ok {
+		t.Error("not released at SIGTERM")
+	}
+	a.Unlock()
+}
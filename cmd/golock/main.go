@@ -0,0 +1,169 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Command golock runs a command while holding a lock, like flock(1):
+//
+//	golock [-n] [-w timeout] [-E code] lock [command [args...]]
+//	golock -crashes dir
+//
+// The lock is a URI understood by locking.Open (file:/path, dir:/path,
+// port:12345, unix:@name, pidfile:/path ...), or a plain path, which is an
+// flock on that file, created if missing. Without a command golock waits
+// until the lock is available, and releases it at once.
+//
+// With -crashes golock lists the crash records of the broken stale locks
+// archived in dir (see locking.SetForensicsDir).
+//
+// The exit code is the one of the command, or
+//
+//	64 (EX_USAGE)        bad arguments
+//	69 (EX_UNAVAILABLE)  the lock cannot be opened or acquired
+//	75 (EX_TEMPFAIL)     the lock is held (-n), or the wait timed out (-w); see -E
+//	126, 127             the command cannot be run, as in the shell
+//	128+n                the command is killed by signal n
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+// sysexits.h
+const (
+	exUsage       = 64
+	exUnavailable = 69
+	exTempFail    = 75
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("golock", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	flagNB := fs.Bool("n", false, "fail instead of waiting, if the lock is held")
+	flagWait := fs.Duration("w", 0, "fail if the lock is not acquired in this time (0: wait forever)")
+	flagConflict := fs.Int("E", exTempFail, "exit code when the lock is held (-n) or the wait timed out")
+	flagCrashes := fs.String("crashes", "", "list the crash records archived in this dir")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: golock [-n] [-w timeout] [-E code] lock [command [args...]]")
+		fmt.Fprintln(stderr, "       golock -crashes dir")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exUsage
+	}
+	if *flagCrashes != "" {
+		return crashes(*flagCrashes, stdout, stderr)
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exUsage
+	}
+
+	lock, err := open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, "golock:", err)
+		return exUnavailable
+	}
+	if ok, err := acquire(lock, *flagNB, *flagWait); err != nil {
+		fmt.Fprintln(stderr, "golock:", err)
+		return exUnavailable
+	} else if !ok {
+		return *flagConflict
+	}
+	defer lock.Unlock()
+
+	if fs.NArg() == 1 {
+		return 0
+	}
+	cmd := exec.Command(fs.Arg(1), fs.Args()[2:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err = cmd.Start(); err != nil {
+		fmt.Fprintln(stderr, "golock:", err)
+		if errors.Is(err, exec.ErrNotFound) || os.IsNotExist(err) {
+			return 127
+		}
+		return 126
+	}
+	// the lock is released only after the command exits
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	err = cmd.Wait()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return ee.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "golock:", err)
+		return exUnavailable
+	}
+	return 0
+}
+
+// crashes lists the crash records in dir, one per line
+func crashes(dir string, stdout, stderr io.Writer) int {
+	records, err := locking.CrashRecords(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, "golock:", err)
+		return exUnavailable
+	}
+	for _, r := range records {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\tpid %d@%s since %s\n",
+			r.BrokenAt.Format(time.RFC3339), r.Kind, r.Lock, r.Reason,
+			r.Holder.PID, r.Holder.Host, r.Holder.Since.Format(time.RFC3339))
+	}
+	return 0
+}
+
+// open returns the lock of the URI, or an flock on a plain path
+func open(arg string) (locking.Locker, error) {
+	if u, err := url.Parse(arg); err == nil && u.Scheme != "" {
+		return locking.Open(arg)
+	}
+	return locking.NewFLockCreate(arg, 0644)
+}
+
+// acquire reports false if the lock is held (nonblock) or the wait timed out
+func acquire(lock locking.Locker, nonblock bool, wait time.Duration) (bool, error) {
+	if nonblock {
+		tl, ok := lock.(locking.TryLocker)
+		if !ok {
+			return false, locking.ErrNoTryLock
+		}
+		return tl.TryLock()
+	}
+	ctx := context.Background()
+	if wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wait)
+		defer cancel()
+	}
+	err := locking.LockContext(ctx, lock)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, locking.ErrTimeout) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+
+	var out bytes.Buffer
+	if code := run([]string{path, "echo", "held"}, nil, &out, &out); code != 0 || strings.TrimSpace(out.String()) != "held" {
+		t.Fatalf("code=%d output=%q", code, out.String())
+	}
+	if code := run([]string{path, "sh", "-c", "exit 3"}, nil, &out, &out); code != 3 {
+		t.Errorf("got code %d, wanted the exit code of the command", code)
+	}
+	if code := run([]string{path, filepath.Join(dir, "nonexistent")}, nil, &out, &out); code != 127 {
+		t.Errorf("got code %d for a missing command, wanted 127", code)
+	}
+	out.Reset()
+	if err = ioutil.WriteFile(filepath.Join(dir, "1.json"), []byte(`{"Lock":"/var/lock/x","Kind":"dir","Reason":"dead","Holder":{"PID":42}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"-crashes", dir}, nil, &out, &out); code != 0 || !strings.Contains(out.String(), "/var/lock/x\tdead\tpid 42@") {
+		t.Errorf("crash records: code=%d output=%q", code, out.String())
+	}
+	if code := run(nil, nil, &out, &out); code != exUsage {
+		t.Errorf("got code %d without arguments, wanted %d", code, exUsage)
+	}
+
+	lock, err := locking.NewFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"-n", path, "true"}, nil, &out, &out); code != exTempFail {
+		t.Errorf("-n: got code %d, wanted %d", code, exTempFail)
+	}
+	if code := run([]string{"-n", "-E", "9", "file:" + path, "true"}, nil, &out, &out); code != 9 {
+		t.Errorf("-E 9: got code %d", code)
+	}
+	start := time.Now()
+	if code := run([]string{"-w", "100ms", path}, nil, &out, &out); code != exTempFail {
+		t.Errorf("-w: got code %d, wanted %d", code, exTempFail)
+	} else if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("gave up after %s", d)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lock.Unlock()
+	}()
+	if code := run([]string{"-w", "10s", path}, nil, &out, &out); code != 0 {
+		t.Errorf("waiting for the release: got code %d", code)
+	}
+}
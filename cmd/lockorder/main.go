@@ -0,0 +1,216 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Command lockorder checks the lock order statically, like go vet:
+//
+//	lockorder [dir | dir/... ...]
+//
+// It reads the locking.NewLockOrder calls with string literal names in the
+// packages of the given directories (. by default), and reports the
+// Chain.Lock calls which acquire a name while the same chain holds a name
+// declared after it in any of those orders.
+//
+// The calls of each function are followed in source order, ignoring the
+// control flow: an Unlock in one branch releases the name for the rest of
+// the function. The runtime check of Chain.Lock stays the authority.
+//
+// The exit code is 0 if no violation is found, 1 if some are, and 2 on
+// errors.
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	var dirs []string
+	for _, arg := range args {
+		if !strings.HasSuffix(arg, "/...") {
+			dirs = append(dirs, arg)
+			continue
+		}
+		root := strings.TrimSuffix(arg, "/...")
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return err
+			}
+			if name := fi.Name(); path != root && (name == "testdata" || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(stderr, "lockorder:", err)
+			return 2
+		}
+	}
+	found := false
+	for _, dir := range dirs {
+		fset := token.NewFileSet()
+		paths, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+		pkgs := make(map[string][]*ast.File)
+		var names []string
+		for _, path := range paths {
+			f, err := parser.ParseFile(fset, path, nil, 0)
+			if err != nil {
+				fmt.Fprintln(stderr, "lockorder:", err)
+				return 2
+			}
+			if _, ok := pkgs[f.Name.Name]; !ok {
+				names = append(names, f.Name.Name)
+			}
+			pkgs[f.Name.Name] = append(pkgs[f.Name.Name], f)
+		}
+		for _, name := range names {
+			for _, v := range checkPackage(fset, pkgs[name]) {
+				fmt.Fprintln(stdout, v)
+				found = true
+			}
+		}
+	}
+	if found {
+		return 1
+	}
+	return 0
+}
+
+// checkPackage returns the violations in the files of a package, as
+// "file:line:col: message"
+func checkPackage(fset *token.FileSet, files []*ast.File) []string {
+	var orders []*locking.LockOrder
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			if names, ok := lockOrderNames(n); ok {
+				orders = append(orders, locking.NewLockOrder(names...))
+			}
+			return true
+		})
+	}
+	if len(orders) == 0 {
+		return nil
+	}
+	var out []string
+	var checkBody func(body *ast.BlockStmt)
+	checkBody = func(body *ast.BlockStmt) {
+		held := make(map[string][]string) // by the chain expression
+		ast.Inspect(body, func(n ast.Node) bool {
+			if lit, ok := n.(*ast.FuncLit); ok {
+				checkBody(lit.Body)
+				return false
+			}
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			name, ok := stringLit(call.Args[0])
+			if !ok {
+				return true
+			}
+			chain := exprString(sel.X)
+			switch {
+			case sel.Sel.Name == "Lock" && len(call.Args) == 2:
+				for _, o := range orders {
+					if err := o.Check(held[chain], name); err != nil {
+						out = append(out, fmt.Sprintf("%s: %v", fset.Position(call.Pos()), err))
+						break
+					}
+				}
+				held[chain] = append(held[chain], name)
+			case sel.Sel.Name == "Unlock" && len(call.Args) == 1:
+				names := held[chain]
+				for i := len(names) - 1; i >= 0; i-- {
+					if names[i] == name {
+						held[chain] = append(names[:i:i], names[i+1:]...)
+						break
+					}
+				}
+			}
+			return true
+		})
+	}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+				checkBody(fn.Body)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// lockOrderNames returns the names of a NewLockOrder call with string
+// literal arguments only
+func lockOrderNames(n ast.Node) ([]string, bool) {
+	call, ok := n.(*ast.CallExpr)
+	if !ok || len(call.Args) == 0 || call.Ellipsis.IsValid() {
+		return nil, false
+	}
+	switch fun := call.Fun.(type) {
+	case *ast.SelectorExpr:
+		if fun.Sel.Name != "NewLockOrder" {
+			return nil, false
+		}
+	case *ast.Ident:
+		if fun.Name != "NewLockOrder" {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	names := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		if names[i], ok = stringLit(arg); !ok {
+			return nil, false
+		}
+	}
+	return names, true
+}
+
+func stringLit(e ast.Expr) (string, bool) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// exprString returns the source form of the chain expression, such as c or s.chain
+func exprString(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return exprString(e.X) + "." + e.Sel.Name
+	case *ast.ParenExpr:
+		return exprString(e.X)
+	case *ast.StarExpr:
+		return "*" + exprString(e.X)
+	}
+	return fmt.Sprintf("%T@%d", e, e.Pos())
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const src = `package p
+
+import "github.com/tgulacsi/go-locking"
+
+var order = locking.NewLockOrder("db", "cache")
+
+func good(db, cache locking.Locker) {
+	c := order.Chain()
+	c.Lock("db", db)
+	c.Lock("cache", cache)
+	c.Unlock("cache")
+	c.Unlock("db")
+}
+
+func released(db, cache locking.Locker) {
+	c := order.Chain()
+	c.Lock("cache", cache)
+	c.Unlock("cache")
+	c.Lock("db", db)
+}
+
+func bad(db, cache locking.Locker) {
+	c := order.Chain()
+	c.Lock("cache", cache)
+	func() {
+		d := order.Chain()
+		d.Lock("db", db)
+	}()
+	c.Lock("db", db)
+}
+`
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(filepath.Join(dir, "p"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "p", "p.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{dir + "/..."}, &out, &errOut); code != 1 {
+		t.Fatalf("code=%d output=%q errors=%q", code, out.String(), errOut.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "p.go:29:") || !strings.Contains(lines[0], `acquiring "db" while holding "cache"`) {
+		t.Errorf("got %q", out.String())
+	}
+
+	out.Reset()
+	if code := run([]string{dir}, &out, &errOut); code != 0 || out.Len() != 0 {
+		t.Errorf("no package in the dir itself: code=%d output=%q", code, out.String())
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"sync"
+	"time"
+)
+
+// Coalesce merges concurrent TryLock probes of the same lock into one
+// backend probe: the callers arriving while a probe is in flight get its
+// result, and a failed probe (lock held) is the answer of every TryLock
+// for Window after it. A success is not shared: only the prober holds the lock.
+//
+// It saves syscalls and round trips for hot, contended locks, at the price
+// of reporting the lock held up to Window after its release.
+type Coalesce struct {
+	Window time.Duration
+}
+
+// Wrap returns lock with its TryLock probes coalesced: share the returned
+// TryLocker between the goroutines.
+func (c Coalesce) Wrap(lock Locker) TryLocker {
+	return &coalescedLock{Locker: lock, window: c.Window}
+}
+
+type coalescedLock struct {
+	Locker
+	window time.Duration
+
+	mu        sync.Mutex
+	inflight  *probe
+	heldUntil time.Time // negative result cached until
+}
+
+type probe struct {
+	done chan struct{}
+	ok   bool
+	err  error
+}
+
+func (l *coalescedLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	l.mu.Lock()
+	if time.Now().Before(l.heldUntil) {
+		l.mu.Unlock()
+		return false, nil
+	}
+	if p := l.inflight; p != nil {
+		l.mu.Unlock()
+		<-p.done
+		// the prober got the lock, or it is held: both mean held for us
+		return false, p.err
+	}
+	p := &probe{done: make(chan struct{})}
+	l.inflight = p
+	l.mu.Unlock()
+
+	p.ok, p.err = tl.TryLock()
+	l.mu.Lock()
+	l.inflight = nil
+	if !p.ok && p.err == nil {
+		l.heldUntil = time.Now().Add(l.window)
+	}
+	l.mu.Unlock()
+	close(p.done)
+	return p.ok, p.err
+}
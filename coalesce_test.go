@@ -0,0 +1,49 @@
+package locking_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+// countingLock is a held lock, counting the TryLock probes
+type countingLock struct {
+	probes int32
+	delay  time.Duration
+}
+
+func (l *countingLock) Lock() error   { return nil }
+func (l *countingLock) Unlock() error { return nil }
+func (l *countingLock) TryLock() (bool, error) {
+	atomic.AddInt32(&l.probes, 1)
+	time.Sleep(l.delay)
+	return false, nil
+}
+
+func TestCoalesce(t *testing.T) {
+	backend := &countingLock{delay: 20 * time.Millisecond}
+	lock := locking.Coalesce{Window: time.Hour}.Wrap(backend)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := lock.TryLock(); ok || err != nil {
+				t.Errorf("got %t, %v", ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&backend.probes); n != 1 {
+		t.Errorf("%d backend probes, wanted 1", n)
+	}
+
+	// a success is not shared
+	free := locking.Coalesce{Window: time.Hour}.Wrap(locking.NewPortLock(freePort(t)))
+	if err := testLock(free); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// FileCond is a condition variable across processes, paired with an FLock:
+// consumers Wait under the lock for the producers' Signal, instead of polling
+// the shared spool file. Signals are counted in the file at path, and
+// watched with inotify on Linux (polled elsewhere).
+//
+// As with sync.Cond, the waiters must recheck their condition in a loop.
+type FileCond struct {
+	L    *FLock
+	path string
+}
+
+// NewFileCond returns a condition of lock, signalled through the file at path
+func NewFileCond(lock *FLock, path string) *FileCond {
+	return &FileCond{L: lock, path: path}
+}
+
+// Wait unlocks L, waits for a Signal (or the end of ctx), and locks L again
+// before returning. L must be held.
+func (c *FileCond) Wait(ctx context.Context) error {
+	seen, err := c.count()
+	if err != nil {
+		return err
+	}
+	if err = c.L.Unlock(); err != nil {
+		return err
+	}
+	err = waitChange(ctx, c.path, func() (bool, error) {
+		n, err := c.count()
+		return n != seen, err
+	})
+	if lerr := c.L.Lock(); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+// Signal wakes up the waiters. A file cannot tell the waiters apart, so all
+// of them wake, as with Broadcast.
+func (c *FileCond) Signal() error { return c.Broadcast() }
+
+// Broadcast wakes up all the waiters
+func (c *FileCond) Broadcast() error {
+	fh, err := os.OpenFile(c.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	var b [8]byte
+	if _, err = fh.ReadAt(b[:], 0); err != nil && err != io.EOF {
+		return err
+	}
+	binary.BigEndian.PutUint64(b[:], binary.BigEndian.Uint64(b[:])+1)
+	_, err = fh.WriteAt(b[:], 0)
+	return err
+}
+
+// count returns the number of signals so far
+func (c *FileCond) count() (uint64, error) {
+	fh, err := os.OpenFile(c.path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	var b [8]byte
+	if _, err = fh.ReadAt(b[:], 0); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+// waitChangePoll polls changed until it reports true, or ctx is done
+func waitChangePoll(ctx context.Context, changed func() (bool, error)) error {
+	delay := time.Millisecond
+	for {
+		if ok, err := changed(); ok || err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay < 100*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
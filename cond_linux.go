@@ -0,0 +1,40 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// waitChange waits with inotify until changed reports true, or ctx is done
+func waitChange(ctx context.Context, path string, changed func() (bool, error)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return waitChangePoll(ctx, changed)
+	}
+	// nonblocking, so reads take deadlines
+	fh := os.NewFile(uintptr(fd), "inotify")
+	defer fh.Close()
+	if _, err = syscall.InotifyAddWatch(fd, path, syscall.IN_MODIFY|syscall.IN_ATTRIB|syscall.IN_DELETE_SELF); err != nil {
+		return &os.PathError{Op: "inotify", Path: path, Err: err}
+	}
+	buf := make([]byte, 4096)
+	for {
+		// checked after the watch is set, not to miss a signal
+		if ok, err := changed(); ok || err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		fh.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err = fh.Read(buf); err != nil && !os.IsTimeout(err) {
+			return err
+		}
+	}
+}
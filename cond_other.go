@@ -0,0 +1,14 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package locking
+
+import "context"
+
+// inotify is available only on Linux: poll
+func waitChange(ctx context.Context, path string, changed func() (bool, error)) error {
+	return waitChangePoll(ctx, changed)
+}
@@ -0,0 +1,88 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestFileCond(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "spool")
+	newCond := func() *locking.FileCond {
+		lock, err := locking.NewFLockCreate(spool, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return locking.NewFileCond(lock, spool+".cond")
+	}
+	consumer, producer := newCond(), newCond()
+
+	got := make(chan string, 1)
+	go func() {
+		if err := consumer.L.Lock(); err != nil {
+			t.Error(err)
+			return
+		}
+		defer consumer.L.Unlock()
+		for {
+			b, err := ioutil.ReadFile(spool)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(b) != 0 {
+				got <- string(b)
+				return
+			}
+			if err = consumer.Wait(context.Background()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err = producer.L.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(spool, []byte("job"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err = producer.Signal(); err != nil {
+		t.Fatal(err)
+	}
+	producer.L.Unlock()
+	select {
+	case s := <-got:
+		if s != "job" {
+			t.Errorf("got %q", s)
+		}
+		t.Logf("woke up in %s", time.Since(start))
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer not woken up")
+	}
+
+	// Wait returns at the end of ctx, holding the lock
+	if err = consumer.L.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err = consumer.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted DeadlineExceeded", err)
+	}
+	if ok, _ := tryFLock(t, spool); ok {
+		t.Error("lock not held after Wait")
+	}
+	consumer.L.Unlock()
+}
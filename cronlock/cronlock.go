@@ -0,0 +1,103 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package cronlock runs scheduled jobs under a lock, so a job scheduled on
+// several hosts (or processes) runs once at a time: distributed cron.
+//
+// Job is the interface of the jobs of github.com/robfig/cron, and Func
+// wraps the plain functions of the schedulers taking func() (like gocron):
+//
+//	r := cronlock.Runner{Lock: lock, Policy: cronlock.Skip}
+//	c.AddJob("@hourly", r.Wrap(job))
+//	s.Every(1).Hour().Do(r.Func(fn))
+package cronlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+// Job is a scheduled job
+type Job interface {
+	Run()
+}
+
+// Policy tells what to do when the lock is held at the start of a run
+type Policy int
+
+const (
+	// Skip skips the run (another holder runs it)
+	Skip = Policy(iota)
+	// Wait waits for the lock (up to MaxWait), then runs
+	Wait
+)
+
+// Runner runs jobs holding Lock
+type Runner struct {
+	Lock   locking.Locker
+	Policy Policy
+	// MaxWait bounds the wait of the Wait policy, unbounded if zero
+	MaxWait time.Duration
+	// OnSkip, if set, is called when a run is skipped
+	OnSkip func()
+	// OnError, if set, gets the errors of the acquisitions and the releases
+	OnError func(error)
+}
+
+// Wrap returns job, run under the lock
+func (r Runner) Wrap(job Job) Job { return jobFunc(r.Func(job.Run)) }
+
+// Func returns fn, run under the lock
+func (r Runner) Func(fn func()) func() {
+	return func() {
+		ran, err := r.Run(fn)
+		if err != nil {
+			if r.OnError != nil {
+				r.OnError(err)
+			}
+		} else if !ran && r.OnSkip != nil {
+			r.OnSkip()
+		}
+	}
+}
+
+// Run runs fn holding the lock, and reports whether it ran: the Skip
+// policy skips it (without error) if the lock is held.
+func (r Runner) Run(fn func()) (ran bool, err error) {
+	if r.Policy == Skip {
+		tl, ok := r.Lock.(locking.TryLocker)
+		if !ok {
+			return false, locking.ErrNoTryLock
+		}
+		if ok, err := tl.TryLock(); !ok || err != nil {
+			return false, err
+		}
+	} else {
+		ctx := context.Background()
+		if r.MaxWait > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.MaxWait)
+			defer cancel()
+		}
+		if err := locking.LockContext(ctx, r.Lock); err != nil {
+			if err == context.DeadlineExceeded {
+				err = locking.ErrTimeout
+			}
+			return false, err
+		}
+	}
+	defer func() {
+		if uerr := r.Lock.Unlock(); err == nil {
+			err = uerr
+		}
+	}()
+	fn()
+	return true, nil
+}
+
+type jobFunc func()
+
+func (f jobFunc) Run() { f() }
@@ -0,0 +1,54 @@
+package cronlock_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+	"github.com/tgulacsi/go-locking/cronlock"
+)
+
+type countJob struct{ runs *int32 }
+
+func (j countJob) Run() { atomic.AddInt32(j.runs, 1) }
+
+func TestRunner(t *testing.T) {
+	var runs, skips int32
+	lock := locking.NewMemLock("cronlock-test", 0)
+	skip := cronlock.Runner{Lock: lock, OnSkip: func() { atomic.AddInt32(&skips, 1) }}
+	job := skip.Wrap(countJob{&runs})
+	job.Run()
+	if runs != 1 {
+		t.Fatalf("ran %d times", runs)
+	}
+
+	// another host holds it
+	other := locking.NewMemLock("cronlock-test", 0)
+	if err := other.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	job.Run()
+	if runs != 1 || skips != 1 {
+		t.Errorf("ran %d, skipped %d times", runs, skips)
+	}
+
+	var errs []error
+	wait := cronlock.Runner{Lock: lock, Policy: cronlock.Wait, MaxWait: 10 * time.Millisecond,
+		OnError: func(err error) { errs = append(errs, err) }}
+	wait.Func(func() { atomic.AddInt32(&runs, 1) })()
+	if len(errs) != 1 || errs[0] != locking.ErrTimeout {
+		t.Errorf("got %v, wanted ErrTimeout", errs)
+	}
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		other.Unlock()
+	}()
+	wait.MaxWait = time.Second
+	if ran, err := wait.Run(func() { atomic.AddInt32(&runs, 1) }); !ran || err != nil {
+		t.Errorf("Wait: %t, %v", ran, err)
+	}
+	if runs != 2 {
+		t.Errorf("ran %d times", runs)
+	}
+}
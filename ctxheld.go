@@ -0,0 +1,63 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrHeldInContext is returned by WithLock in NonReentrant mode, when the lock
+// is held already in the context
+var ErrHeldInContext = errors.New("lock already held in this context")
+
+// Reentrancy tells WithLock what to do with a lock held already in the context
+type Reentrancy int
+
+const (
+	// Reentrant makes the nested acquisition a no-op
+	Reentrant = Reentrancy(iota)
+	// NonReentrant fails the nested acquisition with ErrHeldInContext
+	NonReentrant
+)
+
+type heldKey struct{}
+
+// heldLocks is an immutable list of the lock names held in a context
+type heldLocks struct {
+	name   string
+	parent *heldLocks
+}
+
+// HeldInContext reports whether the named lock has been acquired with WithLock
+// in ctx (or in one of its parents)
+func HeldInContext(ctx context.Context, name string) bool {
+	for h, _ := ctx.Value(heldKey{}).(*heldLocks); h != nil; h = h.parent {
+		if h.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithLock acquires the logical lock name with lock (until ctx is done), and
+// returns a derived context recording it as held, and the func to release it.
+//
+// Layered code passing the context along can take the same lock again
+// without sharing the Locker: mode tells whether that is a no-op or an error,
+// instead of a self-deadlock.
+func WithLock(ctx context.Context, name string, lock Locker, mode Reentrancy) (context.Context, func() error, error) {
+	if HeldInContext(ctx, name) {
+		if mode == NonReentrant {
+			return ctx, nil, ErrHeldInContext
+		}
+		return ctx, func() error { return nil }, nil
+	}
+	if err := LockContext(ctx, lock); err != nil {
+		return ctx, nil, err
+	}
+	parent, _ := ctx.Value(heldKey{}).(*heldLocks)
+	return context.WithValue(ctx, heldKey{}, &heldLocks{name: name, parent: parent}), lock.Unlock, nil
+}
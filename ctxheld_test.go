@@ -0,0 +1,36 @@
+package locking_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestWithLock(t *testing.T) {
+	port := freePort(t)
+	ctx, release, err := locking.WithLock(context.Background(), "port", locking.NewPortLock(port), locking.Reentrant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locking.HeldInContext(ctx, "port") {
+		t.Fatal("not recorded in the context")
+	}
+	// a separate PortLock would block forever
+	_, inner, err := locking.WithLock(ctx, "port", locking.NewPortLock(port), locking.Reentrant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = inner(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = locking.WithLock(ctx, "port", locking.NewPortLock(port), locking.NonReentrant); err != locking.ErrHeldInContext {
+		t.Errorf("got %v, wanted ErrHeldInContext", err)
+	}
+	if err = release(); err != nil {
+		t.Fatal(err)
+	}
+	if locking.HeldInContext(context.Background(), "port") {
+		t.Error("held in the parent context")
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDeadlock is returned (wrapped in a *DeadlockError) when waiting for a
+// lock would close a cycle of waiting processes
+var ErrDeadlock = errors.New("lock deadlock")
+
+// DeadlockError describes the cycle of waits
+type DeadlockError struct {
+	Cycle []string // "a waits for x, held by b" steps
+}
+
+func (e *DeadlockError) Error() string {
+	return ErrDeadlock.Error() + ": " + strings.Join(e.Cycle, "; ")
+}
+
+// Unwrap returns ErrDeadlock
+func (e *DeadlockError) Unwrap() error { return ErrDeadlock }
+
+// DeadlockDetector finds lock-order deadlocks between processes: each
+// publishes the names of the locks it holds and waits for in the Spool
+// directory, and the waits of the wrapped locks check the wait-for graph.
+// Of a cycle, the waiter with the greatest ID gets ErrDeadlock, so the others
+// can proceed. Use one DeadlockDetector per process, and the same lock
+// names in all of them.
+type DeadlockDetector struct {
+	Spool   string        // shared directory of the process states
+	ID      string        // of this process, "pid@host" if empty
+	MaxWait time.Duration // if set, give up with ErrTimeout, listing the holders
+
+	mu      sync.Mutex
+	self    *waitState
+	once    sync.Once
+	initErr error
+}
+
+type waitState struct {
+	ID      string         `json:"id"`
+	PID     int            `json:"pid"`
+	Host    string         `json:"host"`
+	Held    map[string]int `json:"held,omitempty"`
+	Waiting map[string]int `json:"waiting,omitempty"`
+}
+
+// Wrap returns lock, registered under name. A blocking Lock polls lock
+// (if it is a TryLocker) to check for deadlocks while it waits.
+func (d *DeadlockDetector) Wrap(name string, lock Locker) TryLocker {
+	return &deadlockLock{Locker: lock, name: name, d: d}
+}
+
+func (d *DeadlockDetector) init() error {
+	d.once.Do(func() {
+		o := currentOwner()
+		d.self = &waitState{ID: d.ID, PID: o.PID, Host: o.Host,
+			Held: make(map[string]int), Waiting: make(map[string]int)}
+		if d.self.ID == "" {
+			d.self.ID = strconv.Itoa(o.PID) + "@" + o.Host
+		}
+		d.initErr = os.MkdirAll(d.Spool, 0755)
+	})
+	return d.initErr
+}
+
+// update changes the own state and publishes it
+func (d *DeadlockDetector) update(fn func(*waitState)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(d.self)
+	b, err := json.Marshal(d.self)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.Spool, d.self.ID+".json")
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// states returns the published states of the live processes, with the own
+func (d *DeadlockDetector) states() map[string]*waitState {
+	names, _ := filepath.Glob(filepath.Join(d.Spool, "*.json"))
+	all := make(map[string]*waitState, len(names))
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		var s waitState
+		if json.Unmarshal(b, &s) != nil || !(Owner{PID: s.PID, Host: s.Host}).Alive() {
+			continue
+		}
+		all[s.ID] = &s
+	}
+	d.mu.Lock()
+	self := *d.self
+	d.mu.Unlock()
+	all[self.ID] = &self
+	return all
+}
+
+// cycle returns the cycle of waits through this process, if this is its victim
+func (d *DeadlockDetector) cycle() []string {
+	all := d.states()
+	selfID := d.self.ID
+	var path []string
+	visited := make(map[string]bool)
+	var walk func(id string) bool
+	walk = func(id string) bool {
+		visited[id] = true
+		for _, name := range sortedKeys(all[id].Waiting) {
+			for _, other := range sortedStates(all) {
+				if other.ID == id || other.Held[name] == 0 {
+					continue
+				}
+				path = append(path, fmt.Sprintf("%s waits for %s, held by %s", id, name, other.ID))
+				if other.ID == selfID || (!visited[other.ID] && walk(other.ID)) {
+					return true
+				}
+				path = path[:len(path)-1]
+			}
+		}
+		return false
+	}
+	if !walk(selfID) {
+		return nil
+	}
+	// the victim is the greatest ID of the cycle
+	for _, step := range path {
+		if id := step[:strings.IndexByte(step, ' ')]; id > selfID {
+			return nil
+		}
+	}
+	return path
+}
+
+// holders lists the holders and waiters of name
+func (d *DeadlockDetector) holders(name string) string {
+	var held, waiting []string
+	for _, s := range sortedStates(d.states()) {
+		if s.Held[name] > 0 {
+			held = append(held, s.ID)
+		}
+		if s.Waiting[name] > 0 {
+			waiting = append(waiting, s.ID)
+		}
+	}
+	return "held by [" + strings.Join(held, " ") + "], waited for by [" + strings.Join(waiting, " ") + "]"
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k, n := range m {
+		if n > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedStates(all map[string]*waitState) []*waitState {
+	states := make([]*waitState, 0, len(all))
+	for _, s := range all {
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+	return states
+}
+
+type deadlockLock struct {
+	Locker
+	name string
+	d    *DeadlockDetector
+}
+
+func (l *deadlockLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+func (l *deadlockLock) LockContext(ctx context.Context) error {
+	if err := l.d.init(); err != nil {
+		return err
+	}
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		if err := LockContext(ctx, l.Locker); err != nil {
+			return err
+		}
+		return l.d.update(func(s *waitState) { s.Held[l.name]++ })
+	}
+	if err := l.d.update(func(s *waitState) { s.Waiting[l.name]++ }); err != nil {
+		return err
+	}
+	start, delay := time.Now(), time.Millisecond
+	for {
+		ok, err := tl.TryLock()
+		if ok && err == nil {
+			return l.d.update(func(s *waitState) { s.Waiting[l.name]--; s.Held[l.name]++ })
+		}
+		if err == nil || Retryable(err) {
+			if cycle := l.d.cycle(); cycle != nil {
+				err = &DeadlockError{Cycle: cycle}
+			} else if l.d.MaxWait > 0 && time.Since(start) > l.d.MaxWait {
+				err = fmt.Errorf("%w waiting for %s: %s", ErrTimeout, l.name, l.d.holders(l.name))
+			}
+		}
+		if err == nil || Retryable(err) {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(delay):
+				if delay < 100*time.Millisecond {
+					delay *= 2
+				}
+				continue
+			}
+		}
+		l.d.update(func(s *waitState) { s.Waiting[l.name]-- })
+		return err
+	}
+}
+
+func (l *deadlockLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	if err := l.d.init(); err != nil {
+		return false, err
+	}
+	ok, err := tl.TryLock()
+	if ok && err == nil {
+		err = l.d.update(func(s *waitState) { s.Held[l.name]++ })
+	}
+	return ok, err
+}
+
+func (l *deadlockLock) Unlock() error {
+	err := l.Locker.Unlock()
+	if err == nil && l.d.init() == nil {
+		l.d.update(func(s *waitState) {
+			if s.Held[l.name] > 0 {
+				s.Held[l.name]--
+			}
+		})
+	}
+	return err
+}
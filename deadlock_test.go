@@ -0,0 +1,70 @@
+package locking_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestDeadlockDetector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "spool")
+	for _, name := range []string{"x", "y"} {
+		if err = os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a and b stand for two processes
+	a := &locking.DeadlockDetector{Spool: spool, ID: "a"}
+	b := &locking.DeadlockDetector{Spool: spool, ID: "b"}
+	lock := func(d *locking.DeadlockDetector, name string) locking.TryLocker {
+		l, err := locking.NewDirLock(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Wrap(name, l)
+	}
+	ax, ay, bx, by := lock(a, "x"), lock(a, "y"), lock(b, "x"), lock(b, "y")
+	if err = testLock(ax); err != nil {
+		t.Fatal(err)
+	}
+
+	// a holds x and wants y, b holds y and wants x
+	if err = ax.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = by.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	aDone := make(chan error, 1)
+	go func() { aDone <- ay.Lock() }()
+	time.Sleep(20 * time.Millisecond)
+	// b is the victim, having the greater ID
+	err = bx.Lock()
+	var de *locking.DeadlockError
+	if !errors.As(err, &de) || !errors.Is(err, locking.ErrDeadlock) || len(de.Cycle) != 2 {
+		t.Fatalf("got %v, wanted a deadlock of 2 steps", err)
+	}
+	by.Unlock()
+	if err = <-aDone; err != nil {
+		t.Fatal("the survivor:", err)
+	}
+	ay.Unlock()
+
+	// excessive wait
+	b.MaxWait = 20 * time.Millisecond
+	if err = bx.Lock(); !errors.Is(err, locking.ErrTimeout) {
+		t.Errorf("got %v, wanted ErrTimeout", err)
+	}
+	t.Log(err)
+	ax.Unlock()
+}
@@ -0,0 +1,145 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import "sync"
+
+// DegradeMode tells what to do when the authoritative lock backend is unavailable
+type DegradeMode int
+
+const (
+	// FailClosed returns the error: no lock, no work
+	FailClosed = DegradeMode(iota)
+	// FailOpen proceeds without any lock
+	FailOpen
+	// FallBack acquires DegradePolicy.Fallback instead, with its (reduced) guarantees
+	FallBack
+)
+
+func (m DegradeMode) String() string {
+	switch m {
+	case FailClosed:
+		return "fail-closed"
+	case FailOpen:
+		return "fail-open"
+	case FallBack:
+		return "fallback"
+	}
+	return "unknown"
+}
+
+// DegradeEvent describes a degraded acquisition
+type DegradeEvent struct {
+	Mode DegradeMode
+	Err  error // the backend error
+}
+
+// DegradePolicy declares what a lock does when its backend is unavailable.
+type DegradePolicy struct {
+	Mode     DegradeMode
+	Fallback Locker // for FallBack, typically a local FLock
+	// Unavailable reports whether err means the backend is unavailable
+	// (and not, for example, a permission problem).
+	// Defaults to ErrCircuitOpen (see Breaker) or an IsRetryable error.
+	Unavailable func(err error) bool
+	// OnDegrade is called for each degraded acquisition
+	OnDegrade func(DegradeEvent)
+}
+
+// Wrap returns lock, consulting the policy when its backend is unavailable
+func (p *DegradePolicy) Wrap(lock Locker) TryLocker {
+	return &degradedLock{Locker: lock, policy: p}
+}
+
+func (p *DegradePolicy) unavailable(err error) bool {
+	if p.Unavailable != nil {
+		return p.Unavailable(err)
+	}
+	return err == ErrCircuitOpen || IsRetryable(err)
+}
+
+type degradedLock struct {
+	Locker
+	policy *DegradePolicy
+
+	mu   sync.Mutex
+	held Locker // nil: the backend, noLock: nothing (fail open)
+}
+
+type noLock struct{}
+
+func (noLock) Lock() error            { return nil }
+func (noLock) TryLock() (bool, error) { return true, nil }
+func (noLock) Unlock() error          { return nil }
+
+// degrade handles the backend error err; try tells whether to TryLock the fallback
+func (l *degradedLock) degrade(err error, try bool) (bool, error) {
+	p := l.policy
+	if !p.unavailable(err) {
+		return false, err
+	}
+	if p.OnDegrade != nil {
+		p.OnDegrade(DegradeEvent{Mode: p.Mode, Err: err})
+	}
+	var held Locker
+	switch p.Mode {
+	case FailOpen:
+		held = noLock{}
+	case FallBack:
+		if p.Fallback == nil {
+			return false, err
+		}
+		if try {
+			tl, ok := p.Fallback.(TryLocker)
+			if !ok {
+				return false, ErrNoTryLock
+			}
+			if ok, ferr := tl.TryLock(); !ok || ferr != nil {
+				return false, ferr
+			}
+		} else if ferr := p.Fallback.Lock(); ferr != nil {
+			return false, ferr
+		}
+		held = p.Fallback
+	default:
+		return false, err
+	}
+	l.mu.Lock()
+	l.held = held
+	l.mu.Unlock()
+	return true, nil
+}
+
+func (l *degradedLock) Lock() error {
+	err := l.Locker.Lock()
+	if err == nil {
+		return nil
+	}
+	_, err = l.degrade(err, false)
+	return err
+}
+
+func (l *degradedLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	ok, err := tl.TryLock()
+	if err == nil {
+		return ok, nil
+	}
+	return l.degrade(err, true)
+}
+
+func (l *degradedLock) Unlock() error {
+	l.mu.Lock()
+	held := l.held
+	l.held = nil
+	l.mu.Unlock()
+	if held != nil {
+		return held.Unlock()
+	}
+	return l.Locker.Unlock()
+}
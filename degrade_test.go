@@ -0,0 +1,38 @@
+package locking_test
+
+import (
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestDegradePolicy(t *testing.T) {
+	var events []locking.DegradeEvent
+	unavailable := func(error) bool { return true }
+	onDegrade := func(ev locking.DegradeEvent) { events = append(events, ev) }
+
+	closed := &locking.DegradePolicy{Mode: locking.FailClosed, Unavailable: unavailable, OnDegrade: onDegrade}
+	if err := closed.Wrap(&flaky{}).Lock(); err == nil {
+		t.Error("fail-closed lock succeeded")
+	}
+
+	open := &locking.DegradePolicy{Mode: locking.FailOpen, Unavailable: unavailable, OnDegrade: onDegrade}
+	if err := testLock(open.Wrap(&flaky{})); err != nil {
+		t.Errorf("fail-open: %v", err)
+	}
+
+	fallback := locking.NewPortLock(freePort(t))
+	fb := &locking.DegradePolicy{Mode: locking.FallBack, Fallback: fallback, Unavailable: unavailable, OnDegrade: onDegrade}
+	lock := fb.Wrap(&flaky{})
+	if err := lock.Lock(); err != nil {
+		t.Fatalf("fallback: %v", err)
+	}
+	if ok, _ := fallback.TryLock(); ok {
+		t.Error("fallback lock is not held")
+	}
+	lock.Unlock()
+
+	if len(events) != 4 || events[0].Mode != locking.FailClosed || events[3].Mode != locking.FallBack {
+		t.Errorf("events: %v", events)
+	}
+}
@@ -0,0 +1,34 @@
+package locking_test
+
+import (
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestStdlibOnly keeps the core package free of third-party and subpackage imports
+func TestStdlibOnly(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, fn := range files {
+		if strings.HasSuffix(fn, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, fn, nil, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			if first := strings.SplitN(path, "/", 2)[0]; strings.Contains(first, ".") {
+				t.Errorf("%s imports %q: the core package must use the standard library only", fn, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+// DistLocker is a lock shared by processes on several hosts, through a
+// network service (a database, Redis). Its hold may end without Unlock, when
+// the connection or the lease is lost: Lost is closed then.
+//
+// The backends live in subpackages (sqllock, redislock), LeaseLock
+// implements it over a shared filesystem.
+type DistLocker interface {
+	ContextLocker
+	TryLock() (bool, error)
+	// Lost returns a channel which is closed when the held lock is lost;
+	// nil when not held.
+	Lost() <-chan struct{}
+}
@@ -0,0 +1,10 @@
+package locking
+
+// SetStrictEnv makes Strict see the mount table in the file mounts, and
+// every filesystem of type typ, until restore is called.
+func SetStrictEnv(mounts string, typ int64) (restore func()) {
+	oldMounts, oldType := mountsFile, fsType
+	mountsFile = mounts
+	fsType = func(string) (int64, error) { return typ, nil }
+	return func() { mountsFile, fsType = oldMounts, oldType }
+}
@@ -0,0 +1,8 @@
+package locking
+
+// MemLockNames returns the number of MemLock names held or waited for
+func MemLockNames() int {
+	memMu.Lock()
+	defer memMu.Unlock()
+	return len(memLocks)
+}
@@ -0,0 +1,268 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// FairLock makes the waiters of a lock acquire it in arrival order: each
+// blocked Lock takes a numbered ticket file in the queue directory, and tries
+// the lock only when its ticket is the oldest. The tickets of dead processes
+// (on this host) are dropped. TryLock succeeds only if nobody is queued.
+//
+// Only the users of the lock going through FairLock are ordered.
+type FairLock struct {
+	// Policy orders the waiters; all users of the queue must use the same
+	Policy QueuePolicy
+	// Affinity lets the process which released the lock last take it again
+	// before the others in the queue, for this long after the release
+	// (for the cache locality of workers processing the same shard).
+	// It is granted once per release: the release of a hold taken with
+	// the affinity gives none, so the queue is not starved.
+	Affinity time.Duration
+
+	lock  TryLocker
+	queue string
+	// affine is 1 while held with the affinity, ahead of the queue
+	affine int32
+}
+
+// QueuePolicy is the order of the waiters of a FairLock
+type QueuePolicy int
+
+const (
+	// FIFO orders the waiters by arrival
+	FIFO QueuePolicy = iota
+	// EDF orders the waiters by the deadline of their context, earliest
+	// first; the ones without a deadline come last, by arrival
+	EDF
+)
+
+// NewFairLock returns lock with FIFO waiters, queued in the queue directory
+// (created if missing)
+func NewFairLock(lock TryLocker, queue string) (*FairLock, error) {
+	if err := os.MkdirAll(queue, 0755); err != nil {
+		return nil, err
+	}
+	return &FairLock{lock: lock, queue: queue}, nil
+}
+
+// NewFairDirLock returns the DirLock of path (see NewDirLock), with FIFO
+// waiters queued in the .queue directory beside it
+func NewFairDirLock(path string) (*FairLock, error) {
+	lock, err := NewDirLock(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewFairLock(lock, string(lock)+".queue")
+}
+
+// Lock acquires the lock, blocking, after the ones waiting already
+func (l *FairLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext acquires the lock in turn, until ctx is done
+func (l *FairLock) LockContext(ctx context.Context) error {
+	ticket, err := l.takeTicket(ctx)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(ticket)
+	defer beginWait(l.queue)()
+	start, attempts := time.Now(), 0
+	delay := time.Millisecond
+	for {
+		first, affine, err := l.turn()
+		if err != nil {
+			return err
+		}
+		if first == ticket {
+			attempts++
+			ok, err := l.lock.TryLock()
+			if ok && err == nil {
+				recordAcquisition(l.queue, attempts, 0, time.Since(start))
+				l.setAffine(affine)
+				return nil
+			}
+			if err != nil && !Retryable(err) {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay < 100*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+// TryLock acquires the lock if it is free and nobody waits for it - or this
+// process has the affinity
+func (l *FairLock) TryLock() (bool, error) {
+	first, err := l.first()
+	if err != nil {
+		return false, err
+	}
+	if first != "" {
+		if last, ok := l.affinity(); !ok || !last.sameProcess(currentOwner()) {
+			return false, nil
+		}
+	}
+	ok, err := l.lock.TryLock()
+	if ok && err == nil {
+		l.setAffine(first != "")
+	}
+	return ok, err
+}
+
+// Unlock records this process as the last holder (with Affinity), then
+// releases the lock. The release of a hold taken with the affinity clears
+// the record instead.
+func (l *FairLock) Unlock() error {
+	if l.Affinity > 0 {
+		last := filepath.Join(l.queue, ".last")
+		var err error
+		if atomic.SwapInt32(&l.affine, 0) == 1 {
+			if err = os.Remove(last); os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			err = ioutil.WriteFile(last, []byte(currentOwner().String()), 0644)
+		}
+		if err != nil {
+			l.lock.Unlock()
+			return err
+		}
+	}
+	return l.lock.Unlock()
+}
+
+// LastHolder returns the process which released the lock last, with the
+// time of the release in Since. It is recorded only with Affinity, and not
+// by the releases of the holds taken with the affinity.
+func (l *FairLock) LastHolder() (Owner, error) {
+	return readOwnerFile(filepath.Join(l.queue, ".last"))
+}
+
+func (l *FairLock) setAffine(affine bool) {
+	var v int32
+	if affine {
+		v = 1
+	}
+	atomic.StoreInt32(&l.affine, v)
+}
+
+// affinity returns the last holder, if it still has the affinity
+func (l *FairLock) affinity() (Owner, bool) {
+	if l.Affinity <= 0 {
+		return Owner{}, false
+	}
+	last, err := l.LastHolder()
+	return last, err == nil && time.Since(last.Since) < l.Affinity
+}
+
+// turn returns the ticket which may try the lock: the first ticket of the
+// process with the affinity (then affine is true, if it is not the first
+// one anyway), or the first one
+func (l *FairLock) turn() (ticket string, affine bool, err error) {
+	tickets, err := l.tickets()
+	if err != nil || len(tickets) == 0 {
+		return "", false, err
+	}
+	if last, ok := l.affinity(); ok {
+		for i, ticket := range tickets {
+			if o, err := readOwnerFile(ticket); err == nil && o.sameProcess(last) {
+				return ticket, i > 0, nil
+			}
+		}
+	}
+	return tickets[0], false, nil
+}
+
+// Waiting returns the number of queued waiters
+func (l *FairLock) Waiting() (int, error) {
+	tickets, err := l.tickets()
+	return len(tickets), err
+}
+
+// takeTicket creates the next ticket, numbered from the .seq file, and
+// prefixed by the deadline of ctx with EDF
+func (l *FairLock) takeTicket(ctx context.Context) (string, error) {
+	fh, err := os.OpenFile(filepath.Join(l.queue, ".seq"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	if err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX); err != nil {
+		return "", err
+	}
+	defer syscall.Flock(int(fh.Fd()), syscall.LOCK_UN)
+	b, err := ioutil.ReadAll(fh)
+	if err != nil {
+		return "", err
+	}
+	seq, _ := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	seq++
+	if _, err = fh.WriteAt([]byte(strconv.FormatUint(seq, 10)+"\n"), 0); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%020d", seq)
+	if l.Policy == EDF {
+		deadline := int64(math.MaxInt64)
+		if d, ok := ctx.Deadline(); ok {
+			deadline = d.UnixNano()
+		}
+		name = fmt.Sprintf("%020d.%s", deadline, name)
+	}
+	ticket := filepath.Join(l.queue, name)
+	return ticket, ioutil.WriteFile(ticket, []byte(currentOwner().String()), 0644)
+}
+
+// first returns the oldest live ticket, "" if none
+func (l *FairLock) first() (string, error) {
+	tickets, err := l.tickets()
+	if err != nil || len(tickets) == 0 {
+		return "", err
+	}
+	return tickets[0], nil
+}
+
+// tickets returns the live tickets in order, removing the ones of dead processes
+func (l *FairLock) tickets() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(l.queue, "[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	live := names[:0]
+	for _, name := range names {
+		o, err := readOwnerFile(name)
+		if err == nil && !o.Alive() {
+			os.Remove(name)
+			continue
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		live = append(live, name)
+	}
+	return live, nil
+}
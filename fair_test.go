@@ -0,0 +1,197 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestFairLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := locking.NewFairDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the waiters arrive in order, and must get the lock in the same order
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	const waiters = 4
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := lock.Lock(); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			lock.Unlock()
+		}(i)
+		for {
+			if n, _ := lock.Waiting(); n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// a newcomer cannot barge in
+	if ok, _ := lock.TryLock(); ok {
+		t.Fatal("TryLock jumped the queue")
+	}
+	lock.Unlock()
+	wg.Wait()
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("acquisition order %v", order)
+		}
+	}
+
+	// the ticket of a dead process is dropped
+	cmd := exec.Command("true")
+	if err = cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	host, _ := os.Hostname()
+	dead := strconv.Itoa(cmd.ProcessState.Pid()) + "\n" + host + "\n" + time.Now().UTC().Format(time.RFC3339Nano) + "\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, ".lock.queue", "00000000000000000001"), []byte(dead), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lock.TryLock(); !ok || err != nil {
+		t.Fatalf("dead waiter blocks: %t, %v", ok, err)
+	}
+	lock.Unlock()
+}
+
+func TestFairLockEDF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewFairDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Policy = locking.EDF
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	// no deadline, then the latest deadline first
+	deadlines := []time.Duration{0, 30 * time.Second, 10 * time.Second, 20 * time.Second}
+	for i, d := range deadlines {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if d > 0 {
+			ctx, cancel = context.WithTimeout(ctx, d)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer cancel()
+			if err := lock.LockContext(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			lock.Unlock()
+		}(i)
+		for {
+			if n, _ := lock.Waiting(); n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	lock.Unlock()
+	wg.Wait()
+	if len(order) != 4 || order[0] != 2 || order[1] != 3 || order[2] != 1 || order[3] != 0 {
+		t.Errorf("acquisition order %v, wanted [2 3 1 0]", order)
+	}
+}
+
+func TestFairLockAffinity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewFairDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.LastHolder(); !os.IsNotExist(err) {
+		t.Fatalf("last holder recorded without affinity: %v", err)
+	}
+	lock.Affinity = time.Minute
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := lock.LastHolder(); err != nil || last.PID != os.Getpid() || time.Since(last.Since) > time.Minute {
+		t.Fatalf("last holder %+v, %v", last, err)
+	}
+
+	// another process (the parent, alive) queues first
+	host, _ := os.Hostname()
+	other := strconv.Itoa(os.Getppid()) + "\n" + host + "\n" + time.Now().UTC().Format(time.RFC3339Nano) + "\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, ".lock.queue", "00000000000000000000"), []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock.Affinity = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = lock.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v without affinity, wanted to wait for the first in the queue", err)
+	}
+
+	lock.Affinity = time.Minute
+	if ok, err := lock.TryLock(); !ok || err != nil {
+		t.Fatalf("TryLock with affinity: %t, %v", ok, err)
+	}
+	lock.Unlock()
+
+	// the affinity is granted once per release
+	if ok, err := lock.TryLock(); ok || err != nil {
+		t.Fatalf("TryLock after an affinity grant: %t, %v", ok, err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = lock.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v after an affinity grant, wanted to wait for the first in the queue", err)
+	}
+}
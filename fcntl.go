@@ -0,0 +1,132 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// FcntlLock is a POSIX record lock (fcntl F_SETLK) on a whole file or on
+// byte ranges of it. Unlike flock, it works over NFS (with lockd).
+//
+// Classic POSIX locks belong to the process: they do not exclude each other
+// within one process, and closing any descriptor of the file, anywhere in
+// the process, drops all of them. The OFD variant (NewOFDLock, Linux only)
+// belongs to the open file instead, like flock.
+type FcntlLock struct {
+	path string
+	fh   *os.File
+	cmds fcntlCmds
+}
+
+type fcntlCmds struct {
+	getlk, setlk, setlkw int
+}
+
+// NewFcntlLock creates a new POSIX record lock (unlocked first).
+// The file is opened for writing, as exclusive record locks require it.
+func NewFcntlLock(path string) (*FcntlLock, error) {
+	return newFcntlLock(path, fcntlCmds{getlk: syscall.F_GETLK, setlk: syscall.F_SETLK, setlkw: syscall.F_SETLKW})
+}
+
+// NewOFDLock creates a new open file description record lock (unlocked first)
+func NewOFDLock(path string) (*FcntlLock, error) {
+	if ofdCmds.setlk == 0 {
+		return nil, syscall.ENOTSUP
+	}
+	return newFcntlLock(path, ofdCmds)
+}
+
+func newFcntlLock(path string, cmds fcntlCmds) (*FcntlLock, error) {
+	fh, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &FcntlLock{path: path, fh: fh, cmds: cmds}, nil
+}
+
+// Lock locks the whole file, blocking
+func (lock *FcntlLock) Lock() error { return lock.LockRange(0, 0) }
+
+// LockContext locks the whole file, until ctx is done
+func (lock *FcntlLock) LockContext(ctx context.Context) error {
+	return lock.LockRangeContext(ctx, 0, 0)
+}
+
+// TryLock locks the whole file, non-blocking
+func (lock *FcntlLock) TryLock() (bool, error) { return lock.TryLockRange(0, 0) }
+
+// Unlock unlocks the whole file (all ranges)
+func (lock *FcntlLock) Unlock() error { return lock.UnlockRange(0, 0) }
+
+// LockRange locks length bytes from offset exclusively, blocking.
+// Zero length means up to the end of file, however it grows.
+func (lock *FcntlLock) LockRange(offset, length int64) error {
+	start := time.Now()
+	defer beginWait(lock.path)()
+	err := retryBlocking(func() error { return lock.fcntl(lock.cmds.setlkw, syscall.F_WRLCK, offset, length) })
+	if err == nil {
+		recordAcquisition(lock.path, 1, 0, time.Since(start))
+	}
+	return err
+}
+
+// LockRangeContext locks length bytes from offset, until ctx is done.
+// As the wait in fcntl cannot be interrupted, it polls with a backoff.
+func (lock *FcntlLock) LockRangeContext(ctx context.Context, offset, length int64) error {
+	start := time.Now()
+	defer beginWait(lock.path)()
+	attempts, delay := 1, time.Millisecond
+	for {
+		ok, err := lock.TryLockRange(offset, length)
+		if ok {
+			recordAcquisition(lock.path, attempts, 0, time.Since(start))
+			return nil
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		attempts++
+		if delay < 100*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+// TryLockRange locks length bytes from offset, non-blocking
+func (lock *FcntlLock) TryLockRange(offset, length int64) (bool, error) {
+	err := lock.fcntl(lock.cmds.setlk, syscall.F_WRLCK, offset, length)
+	switch err {
+	case nil:
+		return true, nil
+	case syscall.EAGAIN, syscall.EACCES:
+		return false, nil
+	}
+	return false, err
+}
+
+// UnlockRange unlocks length bytes from offset
+func (lock *FcntlLock) UnlockRange(offset, length int64) error {
+	return lock.fcntl(lock.cmds.setlk, syscall.F_UNLCK, offset, length)
+}
+
+// Close releases all the locks, and closes the file
+func (lock *FcntlLock) Close() error {
+	return lock.fh.Close()
+}
+
+func (lock *FcntlLock) fcntl(cmd int, typ int16, offset, length int64) error {
+	lk := syscall.Flock_t{Type: typ, Whence: io.SeekStart, Start: offset, Len: length}
+	return syscall.FcntlFlock(lock.fh.Fd(), cmd, &lk)
+}
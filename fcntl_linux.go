@@ -0,0 +1,8 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+// F_OFD_GETLK, F_OFD_SETLK and F_OFD_SETLKW, since Linux 3.15
+var ofdCmds = fcntlCmds{getlk: 36, setlk: 37, setlkw: 38}
@@ -0,0 +1,10 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package locking
+
+// OFD locks are used only on Linux.
+var ofdCmds fcntlCmds
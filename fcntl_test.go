@@ -0,0 +1,60 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestOFDLock(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("OFD locks are available on Linux only")
+	}
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+
+	a, err := locking.NewOFDLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	b, err := locking.NewOFDLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err = a.LockRange(0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLockRange(10, 10); !ok || err != nil {
+		t.Fatalf("disjoint range: %t, %v", ok, err)
+	}
+	if ok, _ := b.TryLockRange(5, 10); ok {
+		t.Fatal("overlapping range locked")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = b.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted DeadlineExceeded", err)
+	}
+	if err = a.UnlockRange(0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("whole file: %t, %v", ok, err)
+	}
+	b.Unlock()
+}
@@ -0,0 +1,214 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FDPool keeps the descriptors of released flocks open for reuse, so
+// goroutines locking the same paths in turn do not open and close the file
+// at each cycle. Every hold still flocks its own descriptor, so the
+// exclusion is the same as with separate FLocks.
+// The zero value keeps up to 4 idle descriptors per path for a minute.
+type FDPool struct {
+	TTL     time.Duration // idle descriptors are closed after this
+	MaxIdle int           // idle descriptors kept per path
+
+	mu   sync.Mutex
+	idle map[string][]idleFD
+}
+
+type idleFD struct {
+	fh    *os.File
+	since time.Time
+}
+
+// FLock returns a lock on path using the descriptors of the pool
+func (p *FDPool) FLock(path string) *PooledFLock {
+	return &PooledFLock{pool: p, path: path}
+}
+
+// Idle returns the number of idle descriptors
+func (p *FDPool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, fds := range p.idle {
+		n += len(fds)
+	}
+	return n
+}
+
+// Close closes the idle descriptors
+func (p *FDPool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	var err error
+	for _, fds := range idle {
+		for _, fd := range fds {
+			if cerr := fd.fh.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+func (p *FDPool) get(path string) (*os.File, error) {
+	p.mu.Lock()
+	p.expire(time.Now())
+	if fds := p.idle[path]; len(fds) > 0 {
+		fd := fds[len(fds)-1]
+		p.idle[path] = fds[:len(fds)-1]
+		p.mu.Unlock()
+		return fd.fh, nil
+	}
+	p.mu.Unlock()
+	return os.Open(path)
+}
+
+func (p *FDPool) put(path string, fh *os.File) {
+	max := p.MaxIdle
+	if max <= 0 {
+		max = 4
+	}
+	now := time.Now()
+	p.mu.Lock()
+	p.expire(now)
+	if len(p.idle[path]) >= max {
+		p.mu.Unlock()
+		fh.Close()
+		return
+	}
+	if p.idle == nil {
+		p.idle = make(map[string][]idleFD)
+	}
+	p.idle[path] = append(p.idle[path], idleFD{fh: fh, since: now})
+	p.mu.Unlock()
+}
+
+// expire closes the descriptors idle for longer than TTL; p.mu must be held
+func (p *FDPool) expire(now time.Time) {
+	ttl := p.TTL
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	for path, fds := range p.idle {
+		keep := fds[:0]
+		for _, fd := range fds {
+			if now.Sub(fd.since) > ttl {
+				fd.fh.Close()
+			} else {
+				keep = append(keep, fd)
+			}
+		}
+		if len(keep) == 0 {
+			delete(p.idle, path)
+		} else {
+			p.idle[path] = keep
+		}
+	}
+}
+
+// PooledFLock is an exclusive flock taking its descriptor from an FDPool
+type PooledFLock struct {
+	pool *FDPool
+	path string
+
+	mu sync.Mutex
+	fh *os.File
+}
+
+// Lock acquires the lock, blocking
+func (l *PooledFLock) Lock() error {
+	return l.acquire(nil)
+}
+
+// LockContext acquires the lock, polling until ctx is done
+func (l *PooledFLock) LockContext(ctx context.Context) error {
+	return l.acquire(ctx)
+}
+
+// TryLock acquires the lock, non-blocking
+func (l *PooledFLock) TryLock() (bool, error) {
+	fh, err := l.pool.get(l.path)
+	if err != nil {
+		return false, err
+	}
+	if err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		l.pool.put(l.path, fh)
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+	l.set(fh)
+	return true, nil
+}
+
+// Unlock releases the lock, returning the descriptor to the pool
+func (l *PooledFLock) Unlock() error {
+	l.mu.Lock()
+	fh := l.fh
+	l.fh = nil
+	l.mu.Unlock()
+	if fh == nil {
+		return nil
+	}
+	if err := syscall.Flock(int(fh.Fd()), syscall.LOCK_UN); err != nil {
+		fh.Close()
+		return err
+	}
+	l.pool.put(l.path, fh)
+	return nil
+}
+
+func (l *PooledFLock) acquire(ctx context.Context) error {
+	fh, err := l.pool.get(l.path)
+	if err != nil {
+		return err
+	}
+	defer beginWait(l.path)()
+	how, delay := syscall.LOCK_EX, time.Millisecond
+	if ctx != nil {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err = syscall.Flock(int(fh.Fd()), how)
+		if err == nil {
+			l.set(fh)
+			return nil
+		}
+		if err == syscall.EWOULDBLOCK {
+			select {
+			case <-ctx.Done():
+				l.pool.put(l.path, fh)
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			if delay < 100*time.Millisecond {
+				delay *= 2
+			}
+			continue
+		}
+		if !Retryable(err) {
+			l.pool.put(l.path, fh)
+			return err
+		}
+	}
+}
+
+func (l *PooledFLock) set(fh *os.File) {
+	l.mu.Lock()
+	l.fh = fh
+	l.mu.Unlock()
+}
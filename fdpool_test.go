@@ -0,0 +1,77 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestFDPool(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+
+	var pool locking.FDPool
+	defer pool.Close()
+	if err = testLock(pool.FLock(fh.Name())); err != nil {
+		t.Fatal(err)
+	}
+	if n := pool.Idle(); n != 1 {
+		t.Errorf("%d idle descriptors, wanted 1 reused", n)
+	}
+
+	// rotating goroutines exclude each other
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		inside int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock := pool.FLock(fh.Name())
+			for j := 0; j < 20; j++ {
+				if err := lock.Lock(); err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if inside++; inside > 1 {
+					t.Error("two holders")
+				}
+				mu.Unlock()
+				mu.Lock()
+				inside--
+				mu.Unlock()
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := pool.Idle(); n < 1 || n > 4 {
+		t.Errorf("%d idle descriptors", n)
+	}
+
+	short := locking.FDPool{TTL: time.Millisecond}
+	lock := short.FLock(fh.Name())
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, err := lock.TryLock(); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if n := short.Idle(); n != 0 {
+		t.Errorf("%d descriptors kept after the TTL", n)
+	}
+	lock.Unlock()
+	short.Close()
+}
@@ -0,0 +1,86 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Flight is singleflight across processes: of the concurrent Do calls for a
+// key, one takes the flock Dir/<key>.lock and computes the value, the
+// others wait for it, and read the result it published in Dir/<key>.result.
+type Flight struct {
+	Dir string
+}
+
+type flightResult struct {
+	Seq   uint64 `json:"seq"`
+	Value []byte `json:"value,omitempty"`
+	Err   string `json:"err,omitempty"`
+}
+
+// Do returns the result of fn for key, computed by this call, or by another
+// (shared), which was in flight when this one was called. An error of a
+// shared fn is returned only by its text.
+func (f Flight) Do(ctx context.Context, key string, fn func() ([]byte, error)) (value []byte, shared bool, err error) {
+	lock, err := newScopedFLock(f.Dir, key+".lock", 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	defer lock.Close()
+	path := filepath.Join(f.Dir, key+".result")
+	before, err := readFlightResult(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = lock.LockContext(ctx); err != nil {
+		return nil, false, err
+	}
+	defer lock.Unlock()
+	after, err := readFlightResult(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if after.Seq != before.Seq {
+		// published while this call waited
+		if after.Err != "" {
+			return nil, true, errors.New(after.Err)
+		}
+		return after.Value, true, nil
+	}
+	value, err = fn()
+	r := flightResult{Seq: after.Seq + 1, Value: value}
+	if err != nil {
+		r.Err = err.Error()
+	}
+	b, merr := json.Marshal(r)
+	if merr == nil {
+		merr = writeDurable(path, string(b))
+	}
+	if err == nil {
+		err = merr
+	}
+	return value, false, err
+}
+
+func readFlightResult(path string) (flightResult, error) {
+	var r flightResult
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return r, err
+	}
+	if err = json.Unmarshal(b, &r); err != nil {
+		return r, &os.PathError{Op: "parse", Path: path, Err: err}
+	}
+	return r, nil
+}
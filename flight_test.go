@@ -0,0 +1,70 @@
+package locking_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestFlight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := locking.Flight{Dir: dir}
+	ctx := context.Background()
+
+	if _, _, err = f.Do(ctx, "../x", nil); err != locking.ErrBadName {
+		t.Errorf("got %v, wanted ErrBadName", err)
+	}
+
+	var calls int32
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	results := make([]string, 4)
+	var sharedN int32
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i > 0 {
+				<-started
+			}
+			v, shared, err := f.Do(ctx, "key", func() ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+				close(started)
+				time.Sleep(50 * time.Millisecond)
+				return []byte("value"), nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+			if shared {
+				atomic.AddInt32(&sharedN, 1)
+			}
+			results[i] = string(v)
+		}(i)
+	}
+	wg.Wait()
+	if calls != 1 || sharedN != 3 {
+		t.Errorf("%d calls, %d shared, wanted 1 and 3", calls, sharedN)
+	}
+	for i, r := range results {
+		if r != "value" {
+			t.Errorf("%d. got %q", i, r)
+		}
+	}
+
+	// a later call computes again; errors are shared, too
+	if _, shared, err := f.Do(ctx, "key", func() ([]byte, error) { return nil, errors.New("failed") }); shared || err == nil {
+		t.Errorf("later call: shared=%t, %v", shared, err)
+	}
+}
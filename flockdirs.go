@@ -0,0 +1,133 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"os"
+	"sync"
+)
+
+// FLockDirsParallel is FLockDirs with up to parallel TryLocks at once
+// (1 if less). It is all-or-nothing as well: at the first failure no more
+// locks are tried, and the acquired ones are released. The error is an
+// *os.PathError naming the failed path, wrapping AlreadyLocked if it was held.
+func FLockDirsParallel(parallel int, dirs ...string) (FLocks, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	locks := make([]*FLock, len(dirs))
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	sem := make(chan struct{}, parallel)
+	for i, path := range dirs {
+		sem <- struct{}{}
+		if failed() {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, path string) {
+			defer func() { <-sem; wg.Done() }()
+			lock, err := NewFLock(path)
+			if err == nil {
+				var ok bool
+				if ok, err = lock.TryLock(); err == nil && !ok {
+					err = AlreadyLocked
+				}
+				if err != nil {
+					lock.Close()
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = &os.PathError{Op: "flock", Path: path, Err: err}
+				}
+				mu.Unlock()
+				return
+			}
+			locks[i] = lock
+		}(i, path)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		for _, lock := range locks {
+			if lock != nil {
+				lock.Close()
+			}
+		}
+		return nil, firstErr
+	}
+	return FLocks(locks), nil
+}
+
+// DirProbe is the state of a directory found by ProbeDirs
+type DirProbe struct {
+	Path     string
+	Lockable bool     // was not locked by others at the probe
+	Info     LockInfo // the holder, if not lockable (Linux only)
+	Err      error    // of opening the directory or of probing
+}
+
+// ProbeDirs reports for each directory whether it could be locked by
+// FLockDirs now, and the holder if not. It keeps no locks: the lockable
+// ones are locked and released at once, so the answers may be stale.
+func ProbeDirs(dirs ...string) []DirProbe {
+	probes := make([]DirProbe, len(dirs))
+	for i, path := range dirs {
+		probes[i].Path = path
+		lock, err := NewFLock(path)
+		if err != nil {
+			probes[i].Err = err
+			continue
+		}
+		ok, err := lock.TryLock()
+		if ok && err == nil {
+			probes[i].Lockable = true
+		} else if err == nil {
+			probes[i].Info, _ = lock.Info()
+		}
+		probes[i].Err = err
+		lock.Close()
+	}
+	return probes
+}
+
+// FLockDirsPartial locks all of the directories it can: it returns the
+// acquired locks, and the paths locked by others. Other errors (a missing
+// directory ...) release the acquired locks, as with FLockDirs.
+func FLockDirsPartial(dirs ...string) (locks FLocks, conflicted []string, err error) {
+	locks = make(FLocks, 0, len(dirs))
+	for _, path := range dirs {
+		lock, err := NewFLock(path)
+		if err == nil {
+			var ok bool
+			if ok, err = lock.TryLock(); err == nil && !ok {
+				lock.Close()
+				conflicted = append(conflicted, path)
+				continue
+			}
+			if err != nil {
+				lock.Close()
+			}
+		}
+		if err != nil {
+			for _, lock := range locks {
+				lock.Close()
+			}
+			return nil, nil, &os.PathError{Op: "flock", Path: path, Err: err}
+		}
+		locks = append(locks, lock)
+	}
+	return locks, conflicted, nil
+}
@@ -0,0 +1,118 @@
+package locking_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestFLockDirsParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var dirs []string
+	for i := 0; i < 50; i++ {
+		d := filepath.Join(dir, strconv.Itoa(i))
+		if err = os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, d)
+	}
+
+	locks, err := locking.FLockDirsParallel(8, dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != len(dirs) {
+		t.Fatalf("got %d locks, wanted %d", len(locks), len(dirs))
+	}
+	// all held: another attempt fails on some path
+	_, err = locking.FLockDirsParallel(8, dirs...)
+	var pe *os.PathError
+	if !errors.As(err, &pe) || !errors.Is(err, locking.AlreadyLocked) {
+		t.Fatalf("got %v, wanted a PathError of AlreadyLocked", err)
+	}
+	locks.Unlock()
+
+	// all-or-nothing: one held dir makes it release the rest
+	held, err := locking.NewFLock(dirs[25])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = held.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locking.FLockDirsParallel(4, dirs...); !errors.As(err, &pe) || pe.Path != dirs[25] {
+		t.Fatalf("got %v, wanted the failure of %s", err, dirs[25])
+	}
+	held.Unlock()
+	locks, err = locking.FLockDirsParallel(4, dirs...)
+	if err != nil {
+		t.Fatal("locks were left behind:", err)
+	}
+	locks.Unlock()
+}
+
+func TestFLockDirsPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var dirs []string
+	for i := 0; i < 4; i++ {
+		d := filepath.Join(dir, strconv.Itoa(i))
+		if err = os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, d)
+	}
+	held, err := locking.NewFLock(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = held.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	probes := locking.ProbeDirs(append(dirs, filepath.Join(dir, "missing"))...)
+	for i, p := range probes {
+		if wanted := i != 1 && i < 4; p.Lockable != wanted {
+			t.Errorf("%s: lockable=%t", p.Path, p.Lockable)
+		}
+	}
+	if p := probes[1]; !p.Info.Locked || p.Info.PID != os.Getpid() || p.Err != nil {
+		t.Errorf("held: %+v", p)
+	}
+	if probes[4].Err == nil {
+		t.Error("no error for a missing directory")
+	}
+
+	locks, conflicted, err := locking.FLockDirsPartial(dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 3 || len(conflicted) != 1 || conflicted[0] != dirs[1] {
+		t.Fatalf("got %d locks, conflicted %q", len(locks), conflicted)
+	}
+	if again, conflicted, err := locking.FLockDirsPartial(dirs[2]); err != nil || len(again) != 0 || len(conflicted) != 1 {
+		t.Fatalf("second attempt: %d locks, conflicted %q, %v", len(again), conflicted, err)
+	}
+	locks.Unlock()
+	held.Unlock()
+
+	if _, _, err = locking.FLockDirsPartial(dirs[0], filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("no error for a missing directory")
+	}
+	if locks, _, err = locking.FLockDirsPartial(dirs...); err != nil || len(locks) != 4 {
+		t.Fatalf("locks were left behind: %d, %v", len(locks), err)
+	}
+	locks.Unlock()
+}
@@ -0,0 +1,109 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// FLockOptions tell how NewFLockWith opens the lock file
+type FLockOptions struct {
+	Create bool        // create the file if it does not exist
+	Perm   os.FileMode // permissions of the created file, 0644 if zero
+	Write  bool        // open read-write, for filesystems refusing flock on read-only files
+	// Owner writes the holder (see Owner) into the file at each exclusive
+	// acquisition; it implies Write.
+	Owner bool
+	// KeepOpen keeps the file open between holds, saving an open and a
+	// close per acquisition on hot paths: call Close to release it.
+	KeepOpen bool
+}
+
+// NewFLockCreate creates a new Flock-based lock (unlocked first), creating
+// the file with perm if it does not exist
+func NewFLockCreate(path string, perm os.FileMode) (*FLock, error) {
+	return NewFLockWith(path, FLockOptions{Create: true, Perm: perm})
+}
+
+// NewFLockWith creates a new Flock-based lock (unlocked first), opening the
+// file as opts tell
+func NewFLockWith(path string, opts FLockOptions) (*FLock, error) {
+	lock := &FLock{path: path, perm: opts.Perm, owner: opts.Owner, keepOpen: opts.KeepOpen, sem: make(chan struct{}, 1)}
+	if lock.perm == 0 {
+		lock.perm = 0644
+	}
+	if opts.Write || opts.Owner {
+		lock.flag = os.O_RDWR
+	}
+	if opts.Create {
+		lock.flag |= os.O_CREATE
+	}
+	var err error
+	if lock.fh, err = lock.open(); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Owner returns the holder recorded in the file of a lock created with
+// FLockOptions.Owner
+func (lock *FLock) Owner() (Owner, error) {
+	if !lock.owner {
+		return Owner{}, errors.New("owner is not recorded, use FLockOptions.Owner")
+	}
+	return readOwnerFile(lock.path)
+}
+
+func (lock *FLock) open() (*os.File, error) {
+	if lock.closed {
+		if err := misuse(lock.path, "use after Close"); err != nil {
+			return nil, err
+		}
+		return nil, &os.PathError{Op: "open", Path: lock.path, Err: os.ErrClosed}
+	}
+	return os.OpenFile(lock.path, lock.flag, lock.perm)
+}
+
+// acquired runs the bookkeeping of an exclusive acquisition. If that fails,
+// the flock is released and held cleared - the caller gives up lock.sem.
+func (lock *FLock) acquired() error {
+	err := lock.beginGeneration()
+	if err == nil {
+		if capturingStacks() {
+			lock.stacked = writeHolderStack(lock.path)
+		}
+		if err = lock.recordOwner(); err != nil {
+			lock.endGeneration()
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	if lock.stacked {
+		removeHolderStack(lock.path)
+		lock.stacked = false
+	}
+	syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_UN)
+	lock.held, lock.shared = false, false
+	if !lock.keepOpen {
+		lock.fh.Close()
+		lock.fh = nil
+	}
+	return err
+}
+
+// recordOwner records the holder in the lock file, with FLockOptions.Owner
+func (lock *FLock) recordOwner() error {
+	if !lock.owner {
+		return nil
+	}
+	if err := lock.fh.Truncate(0); err != nil {
+		return err
+	}
+	_, err := lock.fh.WriteAt([]byte(currentOwner().String()), 0)
+	return err
+}
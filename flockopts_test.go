@@ -0,0 +1,95 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestFLockWith(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "created")
+	lock, err := locking.NewFLockCreate(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("created %v, %v", fi, err)
+	}
+
+	owned, err := locking.NewFLockWith(filepath.Join(dir, "owned"), locking.FLockOptions{Create: true, Owner: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = owned.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer owned.Unlock()
+	if o, err := owned.Owner(); err != nil || o.PID != os.Getpid() {
+		t.Errorf("owner=%+v, %v", o, err)
+	}
+}
+
+func TestFLockKeepOpen(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+
+	lock, err := locking.NewFLockWith(fh.Name(), locking.FLockOptions{KeepOpen: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	// still open, but not locked
+	other, _ := locking.NewFLock(fh.Name())
+	if ok, err := other.TryLock(); !ok || err != nil {
+		t.Fatalf("released lock is held: %t, %v", ok, err)
+	}
+	if ok, _ := lock.TryLock(); ok {
+		t.Error("locked twice")
+	}
+	other.Close()
+	if err = lock.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFLockOwnerFailure(t *testing.T) {
+	// the owner cannot be written into /dev/full
+	lock, err := locking.NewFLockWith("/dev/full", locking.FLockOptions{Owner: true})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer lock.Close()
+	if err = lock.Lock(); err == nil {
+		t.Fatal("Lock recorded the owner into /dev/full")
+	}
+	if ok, err := lock.TryLock(); ok || err == nil {
+		t.Fatalf("TryLock after the failed Lock: %t, %v", ok, err)
+	}
+	// released: another FLock gets it
+	other, err := locking.NewFLock("/dev/full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if ok, err := other.TryLock(); !ok || err != nil {
+		t.Fatalf("the failed Lock kept the lock: %t, %v", ok, err)
+	}
+}
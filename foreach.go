@@ -0,0 +1,62 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import "context"
+
+// ForEachLocked calls fn for each path, holding its FLock, one at a time in
+// order. The lock of the next path is acquired while fn processes the
+// current one, overlapping the lock wait with the work.
+// It stops at the first error (of locking or of fn), cancelling the wait
+// for the prefetched lock (or releasing it, if acquired).
+func ForEachLocked(paths []string, fn func(path string) error) error {
+	type result struct {
+		lock *FLock
+		err  error
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefetch := func(path string) <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			lock, err := NewFLock(path)
+			if err == nil {
+				if err = lock.LockContext(ctx); err != nil {
+					lock.Close()
+				}
+			}
+			ch <- result{lock: lock, err: err}
+		}()
+		return ch
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	next := prefetch(paths[0])
+	for i, path := range paths {
+		r := <-next
+		if r.err != nil {
+			return r.err
+		}
+		next = nil
+		if i+1 < len(paths) {
+			next = prefetch(paths[i+1])
+		}
+		err := fn(path)
+		if uerr := r.lock.Unlock(); err == nil {
+			err = uerr
+		}
+		if err != nil {
+			if next != nil {
+				cancel()
+				if r := <-next; r.err == nil {
+					r.lock.Unlock()
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
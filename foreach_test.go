@@ -0,0 +1,87 @@
+package locking_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestForEachLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var paths []string
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, strconv.Itoa(i))
+		if err = ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	var seen []string
+	if err = locking.ForEachLocked(paths, func(path string) error {
+		if ok, _ := tryFLock(t, path); ok {
+			t.Errorf("%s is not locked in fn", path)
+		}
+		seen = append(seen, path)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(paths) {
+		t.Errorf("processed %q", seen)
+	}
+
+	errStop := errors.New("stop")
+	seen = seen[:0]
+	if err = locking.ForEachLocked(paths, func(path string) error {
+		seen = append(seen, path)
+		if len(seen) == 2 {
+			return errStop
+		}
+		return nil
+	}); err != errStop {
+		t.Errorf("got %v, wanted the error of fn", err)
+	}
+	for _, path := range paths {
+		if ok, _ := tryFLock(t, path); !ok {
+			t.Errorf("%s left locked", path)
+		}
+	}
+
+	// the error of fn does not wait for the prefetch of a held lock
+	held, err := locking.NewFLock(paths[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = held.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer held.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		done <- locking.ForEachLocked(paths, func(path string) error {
+			if path == paths[1] {
+				return errStop
+			}
+			return nil
+		})
+	}()
+	select {
+	case err = <-done:
+		if err != errStop {
+			t.Errorf("got %v, wanted the error of fn", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hung on the prefetch of a held lock")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var forensicsDir atomic.Value // string
+
+// SetForensicsDir makes the stale lock breakers (DirLock.TryLockStale,
+// LeaseLock and NFSLeaseLock) archive a CrashRecord of each broken lock in
+// dir, "" to stop.
+func SetForensicsDir(dir string) { forensicsDir.Store(dir) }
+
+// CrashRecord describes a broken stale lock, as far as its metadata tells.
+// The exit status of the dead holder is known only to its parent, so
+// Reason tells only whether the process was gone, or its lease lapsed.
+type CrashRecord struct {
+	Lock          string
+	Kind          string // "dir", "lease" or "nfslease"
+	Reason        string // "dead" (no such process), "aged" (older than maxAge) or "lapsed"
+	Holder        Owner  // the recorded holder; Since is the acquisition, if known
+	LastHeartbeat time.Time
+	Metadata      string // the owner file or the lease, as found
+	BrokenAt      time.Time
+	BrokenBy      Owner
+}
+
+// recordCrash archives r, if a forensics dir is set
+func recordCrash(r CrashRecord) {
+	dir, _ := forensicsDir.Load().(string)
+	if dir == "" {
+		return
+	}
+	r.BrokenAt, r.BrokenBy = time.Now(), currentOwner()
+	b, err := json.Marshal(r)
+	if err == nil {
+		if err = os.MkdirAll(dir, 0755); err == nil {
+			name := strconv.FormatInt(r.BrokenAt.UnixNano(), 10) + "-" + filepath.Base(r.Lock) + ".json"
+			err = ioutil.WriteFile(filepath.Join(dir, name), b, 0644)
+		}
+	}
+	if err != nil {
+		debugf(1, "lock %s: crash record: %v", r.Lock, err)
+	}
+}
+
+// CrashRecords returns the records archived in dir, oldest first
+func CrashRecords(dir string) ([]CrashRecord, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]CrashRecord, 0, len(names))
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return records, err
+		}
+		var r CrashRecord
+		if err = json.Unmarshal(b, &r); err != nil {
+			return records, &os.PathError{Op: "parse", Path: name, Err: err}
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].BrokenAt.Before(records[j].BrokenAt) })
+	return records, nil
+}
+
+// tokenOwner parses a "host<sep>pid<sep>nanos" lease token
+func tokenOwner(token, sep string) Owner {
+	i := strings.LastIndex(token, sep)
+	if i < 0 {
+		return Owner{}
+	}
+	j := strings.LastIndex(token[:i], sep)
+	if j < 0 {
+		return Owner{}
+	}
+	o := Owner{Host: token[:j]}
+	o.PID, _ = strconv.Atoi(token[j+len(sep) : i])
+	if ns, err := strconv.ParseInt(token[i+len(sep):], 10, 64); err == nil {
+		o.Since = time.Unix(0, ns)
+	}
+	return o
+}
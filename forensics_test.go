@@ -0,0 +1,76 @@
+package locking_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestCrashRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	crashes := filepath.Join(dir, "crashes")
+	locking.SetForensicsDir(crashes)
+	defer locking.SetForensicsDir("")
+
+	cmd := exec.Command("true")
+	if err = cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	dead := cmd.Process.Pid
+	host, _ := os.Hostname()
+
+	// a DirLock of a dead holder
+	lockDir := filepath.Join(dir, "d")
+	if err = os.Mkdir(lockDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dl, _ := locking.NewDirLock(lockDir)
+	if err = dl.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	owner := fmt.Sprintf("%d\n%s\n%s\n", dead, host, time.Now().UTC().Format(time.RFC3339Nano))
+	if err = ioutil.WriteFile(filepath.Join(lockDir, ".lock", "owner"), []byte(owner), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := dl.TryLockStale(0); !ok || err != nil {
+		t.Fatalf("dead holder's lock not broken: %t, %v", ok, err)
+	}
+	dl.Unlock()
+
+	// a lapsed lease
+	leasePath := filepath.Join(dir, "lease")
+	lapsed := fmt.Sprintf("%s/%d/%d\n%d\n", host, dead, time.Now().Add(-time.Hour).UnixNano(), time.Now().Add(-time.Minute).UnixNano())
+	if err = ioutil.WriteFile(leasePath, []byte(lapsed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lease := locking.NewLeaseLock(leasePath, time.Minute)
+	if ok, err := lease.TryLock(); !ok || err != nil {
+		t.Fatalf("lapsed lease not stolen: %t, %v", ok, err)
+	}
+	lease.Unlock()
+
+	records, err := locking.CrashRecords(crashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, wanted 2", len(records))
+	}
+	d, l := records[0], records[1]
+	if d.Kind != "dir" || d.Reason != "dead" || d.Holder.PID != dead || d.Metadata != owner || d.BrokenBy.PID != os.Getpid() {
+		t.Errorf("dir record %+v", d)
+	}
+	if l.Kind != "lease" || l.Reason != "lapsed" || l.Holder.PID != dead || l.Holder.Host != host || l.Metadata != lapsed || l.LastHeartbeat.IsZero() {
+		t.Errorf("lease record %+v", l)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Frozen is a directory tree locked by Freeze
+type Frozen struct {
+	node  *TreeNode
+	locks FLocks
+}
+
+// Freeze freezes the TreeLock rooted at root: FreezeNode of its root node.
+func Freeze(root string) (*Frozen, error) {
+	tree, err := NewTreeLock(root)
+	if err != nil {
+		return nil, err
+	}
+	node, err := tree.Node(root)
+	if err != nil {
+		return nil, err
+	}
+	return FreezeNode(node)
+}
+
+// FreezeNode locks node (with the intents on its ancestors), then takes the
+// exclusive FLock of every directory and regular file under it (its
+// directory included), in lexical walk order, blocking — so every holder of
+// a node below or above it, or of a per-file FLock in the tree, has to
+// finish first, and new holders wait until Thaw. Backup tools can then copy
+// a quiesced dataset.
+// It is all-or-nothing: on error, the already acquired locks are released.
+//
+// The files and directories created after the freeze are covered only for
+// the writers locking them through the TreeLock: their per-file FLocks are
+// not taken.
+func FreezeNode(node *TreeNode) (*Frozen, error) {
+	if err := node.Lock(); err != nil {
+		return nil, err
+	}
+	var locks FLocks
+	err := filepath.Walk(node.Path(), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Name() == treeLockFile || !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+		lock, err := NewFLock(path)
+		if err != nil {
+			return err
+		}
+		if err = lock.Lock(); err != nil {
+			return err
+		}
+		locks = append(locks, lock)
+		return nil
+	})
+	if err != nil {
+		locks.Unlock()
+		node.Unlock()
+		return nil, err
+	}
+	return &Frozen{node: node, locks: locks}, nil
+}
+
+// Paths returns the locked paths, in locking order
+func (f *Frozen) Paths() []string {
+	paths := make([]string, len(f.locks))
+	for i, lock := range f.locks {
+		paths[i] = lock.path
+	}
+	return paths
+}
+
+// Thaw releases all the locks, in reverse order
+func (f *Frozen) Thaw() {
+	for i := len(f.locks) - 1; i >= 0; i-- {
+		f.locks[i].Unlock()
+	}
+	f.locks = nil
+	if f.node != nil {
+		f.node.Unlock()
+		f.node = nil
+	}
+}
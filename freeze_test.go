@@ -0,0 +1,82 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestFreeze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	data := filepath.Join(dir, "a", "b", "data")
+	ioutil.WriteFile(data, nil, 0644)
+
+	writer, err := locking.NewFLock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	frozen := make(chan *locking.Frozen, 1)
+	go func() {
+		f, err := locking.Freeze(dir)
+		if err != nil {
+			t.Error(err)
+		}
+		frozen <- f
+	}()
+	select {
+	case <-frozen:
+		t.Fatal("Freeze did not wait for the writer")
+	case <-time.After(30 * time.Millisecond):
+	}
+	writer.Unlock()
+	f := <-frozen
+	if paths := f.Paths(); len(paths) != 4 {
+		t.Errorf("locked %v", paths)
+	}
+	if ok, _ := tryFLock(t, data); ok {
+		t.Error("data is writable while frozen")
+	}
+	// the nodes of the tree, even the ones created after the freeze
+	tree, err := locking.NewTreeLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := filepath.Join(dir, "a", "later")
+	os.Mkdir(later, 0755)
+	for _, path := range []string{filepath.Join(dir, "a", "b"), later} {
+		node, err := tree.Node(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := node.TryLock(); ok || err != nil {
+			node.Unlock()
+			t.Errorf("node %s lockable while frozen: %t, %v", path, ok, err)
+		}
+	}
+	f.Thaw()
+	if ok, _ := tryFLock(t, data); !ok {
+		t.Error("data is still frozen after Thaw")
+	}
+}
+
+// tryFLock tries a fresh FLock on path, releasing it on success
+func tryFLock(t *testing.T, path string) (bool, error) {
+	lock, err := locking.NewFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+	return lock.TryLock()
+}
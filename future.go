@@ -0,0 +1,44 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import "context"
+
+// Future is a lock acquisition in progress, started by AcquireAsync
+type Future struct {
+	lock   Locker
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// AcquireAsync starts acquiring lock (with LockContext, until ctx is done)
+// and returns at once: start several, and proceed as each becomes available.
+func AcquireAsync(ctx context.Context, lock Locker) *Future {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &Future{lock: lock, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		f.err = LockContext(ctx, lock)
+		cancel()
+		close(f.done)
+	}()
+	return f
+}
+
+// Done is closed when the acquisition finished, successfully or not
+func (f *Future) Done() <-chan struct{} { return f.done }
+
+// Err waits for the acquisition, and returns its error: nil if the lock is held
+func (f *Future) Err() error {
+	<-f.done
+	return f.err
+}
+
+// Lock returns the lock being acquired
+func (f *Future) Lock() Locker { return f.lock }
+
+// Cancel stops the acquisition if it is still in progress (Err returns
+// the error of the context then). The lock is not released if acquired.
+func (f *Future) Cancel() { f.cancel() }
@@ -0,0 +1,39 @@
+package locking_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestAcquireAsync(t *testing.T) {
+	port := freePort(t)
+	free, held, holder := make(chanLock, 1), locking.NewPortLock(port), locking.NewPortLock(port)
+	if err := holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	fFree, fHeld := locking.AcquireAsync(ctx, free), locking.AcquireAsync(ctx, held)
+	select {
+	case <-fFree.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("free lock not acquired")
+	}
+	if err := fFree.Err(); err != nil {
+		t.Fatal(err)
+	}
+	free.Unlock()
+	select {
+	case <-fHeld.Done():
+		t.Fatal("held lock acquired")
+	case <-time.After(50 * time.Millisecond):
+	}
+	fHeld.Cancel()
+	if err := fHeld.Err(); err != context.Canceled {
+		t.Errorf("got %v, wanted Canceled", err)
+	}
+	holder.Unlock()
+}
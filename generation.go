@@ -0,0 +1,84 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// NewGenerationFLock returns an FLock which maintains a generation counter
+// in path+".gen": each exclusive hold bumps it on release.
+// The stamp and the lock are the same as Versioned's, so Versioned readers
+// of path can read optimistically while the writers use this lock, and the
+// writers using Versioned.Write exclude the ones using this lock.
+func NewGenerationFLock(path string) (*FLock, error) {
+	lock, err := NewFLock(path)
+	if err != nil {
+		return nil, err
+	}
+	if lock.gen, err = os.OpenFile(path+".gen", os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		lock.fh.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Generation returns the number of completed exclusive holds,
+// for cheap cache invalidation: reload only if it changed.
+func (lock *FLock) Generation() (uint64, error) {
+	if lock.gen == nil {
+		return 0, errors.New("generation is not tracked, use NewGenerationFLock")
+	}
+	n, err := readSeq(lock.gen)
+	return n / 2, err
+}
+
+// beginGeneration marks the stamp as being written (odd)
+func (lock *FLock) beginGeneration() error {
+	if lock.gen == nil {
+		return nil
+	}
+	n, err := readSeq(lock.gen)
+	if err != nil || n%2 == 1 { // odd: the previous holder crashed
+		return err
+	}
+	return writeSeq(lock.gen, n+1)
+}
+
+// endGeneration bumps the stamp to the next (even) generation
+func (lock *FLock) endGeneration() error {
+	if lock.gen == nil {
+		return nil
+	}
+	n, err := readSeq(lock.gen)
+	if err != nil {
+		return err
+	}
+	if n%2 == 0 {
+		n++
+	}
+	return writeSeq(lock.gen, n+1)
+}
+
+func readSeq(fh *os.File) (uint64, error) {
+	var b [8]byte
+	if _, err := fh.ReadAt(b[:], 0); err != nil {
+		if err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func writeSeq(fh *os.File, n uint64) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	_, err := fh.WriteAt(b[:], 0)
+	return err
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GlobLock holds the FLocks of all the files matching a pattern
+type GlobLock struct {
+	pattern string
+
+	mu    sync.Mutex
+	locks map[string]*FLock
+	stop  chan struct{}
+}
+
+// LockGlob locks every file matching the pattern (see filepath.Glob), blocking.
+//
+// The files are locked in sorted order, so competing LockGlob calls can't
+// deadlock; it is all-or-nothing: on error, the already acquired locks are released.
+func LockGlob(pattern string) (*GlobLock, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	g := &GlobLock{pattern: pattern, locks: make(map[string]*FLock, len(paths))}
+	for _, path := range paths {
+		lock, err := NewFLock(path)
+		if err == nil {
+			err = lock.Lock()
+		}
+		if err != nil {
+			g.Unlock()
+			return nil, err
+		}
+		g.locks[path] = lock
+	}
+	return g, nil
+}
+
+// Paths returns the locked paths, sorted
+func (g *GlobLock) Paths() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	paths := make([]string, 0, len(g.locks))
+	for path := range g.locks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Watch looks for new matches of the pattern every interval, and for each
+// new path calls accept: if it returns true, the path is tried with TryLock,
+// and onLocked is called with the result. The paths held elsewhere are
+// tried again at the next interval, without calling onLocked: blocking on
+// them out of the sorted order of LockGlob could deadlock.
+// The watching stops at Unlock.
+func (g *GlobLock) Watch(interval time.Duration, accept func(path string) bool, onLocked func(path string, err error)) {
+	g.mu.Lock()
+	if g.stop != nil {
+		g.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	g.stop = stop
+	g.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			paths, err := filepath.Glob(g.pattern)
+			if err != nil {
+				return
+			}
+			sort.Strings(paths)
+			for _, path := range paths {
+				g.mu.Lock()
+				_, known := g.locks[path]
+				g.mu.Unlock()
+				if known || !accept(path) {
+					continue
+				}
+				lock, err := NewFLock(path)
+				if err == nil {
+					var ok bool
+					if ok, err = lock.TryLock(); !ok && err == nil {
+						lock.Close()
+						continue
+					}
+				}
+				g.mu.Lock()
+				select {
+				case <-stop: // unlocked meanwhile
+					g.mu.Unlock()
+					if err == nil {
+						lock.Unlock()
+					}
+					return
+				default:
+				}
+				if err == nil {
+					g.locks[path] = lock
+				}
+				g.mu.Unlock()
+				if onLocked != nil {
+					onLocked(path, err)
+				}
+			}
+		}
+	}()
+}
+
+// Unlock releases all the locks and stops watching
+func (g *GlobLock) Unlock() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stop != nil {
+		close(g.stop)
+		g.stop = nil
+	}
+	var err error
+	for path, lock := range g.locks {
+		if uerr := lock.Unlock(); uerr != nil && err == nil {
+			err = uerr
+		}
+		delete(g.locks, path)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLockGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"b.shard", "a.shard", "other"} {
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	g, err := locking.LockGlob(filepath.Join(dir, "*.shard"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Unlock()
+	if paths := g.Paths(); len(paths) != 2 || filepath.Base(paths[0]) != "a.shard" {
+		t.Errorf("locked %v", paths)
+	}
+	other, _ := locking.NewFLock(filepath.Join(dir, "b.shard"))
+	if ok, _ := other.TryLock(); ok {
+		t.Error("b.shard is not locked")
+	}
+
+	locked := make(chan string, 1)
+	g.Watch(5*time.Millisecond, func(string) bool { return true }, func(path string, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- path
+	})
+	// a new match held elsewhere is not waited for
+	held, _ := locking.NewFLockCreate(filepath.Join(dir, "c.shard"), 0644)
+	if err = held.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case path := <-locked:
+		t.Fatalf("locked %q held elsewhere", path)
+	case <-time.After(30 * time.Millisecond):
+	}
+	held.Close()
+	select {
+	case path := <-locked:
+		if filepath.Base(path) != "c.shard" {
+			t.Errorf("locked %q", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("new match not locked")
+	}
+	if err = g.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := other.TryLock(); !ok {
+		t.Error("b.shard is still locked")
+	}
+	other.Unlock()
+}
@@ -0,0 +1,114 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HLC is a hybrid logical clock timestamp: the wall clock, with a logical
+// counter to order the events the wall clock cannot tell apart.
+//
+// The lock records (owner files, MetaLock documents) are stamped with it,
+// and reading a record advances the clock of this process past its stamp:
+// so an acquisition which follows another one (having seen its record) is
+// stamped later, even if the clock of its host lags behind.
+type HLC struct {
+	Wall    int64 // Unix nanoseconds
+	Logical uint32
+}
+
+var (
+	hlcMu   sync.Mutex
+	hlcLast HLC
+)
+
+// NowHLC returns the current timestamp of this process, later than all the
+// timestamps returned or observed so far
+func NowHLC() HLC {
+	return ObserveHLC(HLC{})
+}
+
+// ObserveHLC advances the clock of this process past the timestamp t
+// (received from another process), and returns the current timestamp.
+func ObserveHLC(t HLC) HLC {
+	pt := time.Now().UnixNano()
+	hlcMu.Lock()
+	defer hlcMu.Unlock()
+	last := hlcLast
+	switch {
+	case pt > last.Wall && pt > t.Wall:
+		hlcLast = HLC{Wall: pt}
+	case last.Wall == t.Wall:
+		hlcLast.Logical = last.Logical
+		if t.Logical > last.Logical {
+			hlcLast.Logical = t.Logical
+		}
+		hlcLast.Logical++
+	case last.Wall > t.Wall:
+		hlcLast.Logical++
+	default:
+		hlcLast = HLC{Wall: t.Wall, Logical: t.Logical + 1}
+	}
+	return hlcLast
+}
+
+// Compare returns -1, 0 or +1 as t is before, the same as, or after u
+func (t HLC) Compare(u HLC) int {
+	switch {
+	case t.Wall < u.Wall || t.Wall == u.Wall && t.Logical < u.Logical:
+		return -1
+	case t == u:
+		return 0
+	}
+	return 1
+}
+
+// Before reports whether t is before u
+func (t HLC) Before(u HLC) bool { return t.Compare(u) < 0 }
+
+// IsZero reports whether t is unset (for records written without it)
+func (t HLC) IsZero() bool { return t == HLC{} }
+
+// Time returns the wall clock part
+func (t HLC) Time() time.Time { return time.Unix(0, t.Wall) }
+
+// String returns t as "wall.logical"
+func (t HLC) String() string {
+	return strconv.FormatInt(t.Wall, 10) + "." + strconv.FormatUint(uint64(t.Logical), 10)
+}
+
+// ParseHLC parses the String form of an HLC
+func ParseHLC(s string) (HLC, error) {
+	var t HLC
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return t, fmt.Errorf("bad HLC %q", s)
+	}
+	var err error
+	if t.Wall, err = strconv.ParseInt(s[:i], 10, 64); err != nil {
+		return t, fmt.Errorf("bad HLC %q: %w", s, err)
+	}
+	logical, err := strconv.ParseUint(s[i+1:], 10, 32)
+	if err != nil {
+		return t, fmt.Errorf("bad HLC %q: %w", s, err)
+	}
+	t.Logical = uint32(logical)
+	return t, nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t HLC) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *HLC) UnmarshalText(b []byte) error {
+	var err error
+	*t, err = ParseHLC(string(b))
+	return err
+}
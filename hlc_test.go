@@ -0,0 +1,69 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestHLC(t *testing.T) {
+	a := locking.NowHLC()
+	if b := locking.NowHLC(); !a.Before(b) {
+		t.Errorf("%s is not before %s", a, b)
+	}
+	// a stamp from a host with its clock an hour ahead
+	remote := locking.HLC{Wall: time.Now().Add(time.Hour).UnixNano(), Logical: 3}
+	if got := locking.ObserveHLC(remote); !remote.Before(got) {
+		t.Errorf("observed %s, got %s", remote, got)
+	}
+	if got := locking.NowHLC(); !remote.Before(got) || got.Compare(got) != 0 {
+		t.Errorf("after %s got %s", remote, got)
+	}
+	if got, err := locking.ParseHLC(remote.String()); err != nil || got != remote {
+		t.Errorf("ParseHLC(%q) = %s, %v", remote, got, err)
+	}
+	if _, err := locking.ParseHLC("12"); err == nil {
+		t.Error("ParseHLC accepted a bad stamp")
+	}
+
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock := locking.DirLock(filepath.Join(dir, "lock"))
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	o, err := lock.Owner()
+	lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !remote.Before(o.HLC) {
+		t.Errorf("owner stamped %s, wanted after %s", o.HLC, remote)
+	}
+
+	ml := locking.NewMetaLock(filepath.Join(dir, "meta"), "")
+	var prev locking.HLC
+	for i := 0; i < 3; i++ {
+		if err = ml.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		m, err := ml.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !prev.Before(m.HLC) {
+			t.Errorf("%d. update stamped %s, previous %s", i, m.HLC, prev)
+		}
+		prev = m.HLC
+		if err = ml.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
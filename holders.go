@@ -0,0 +1,33 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"os"
+	"syscall"
+)
+
+// Holders returns the number of shared (reader) locks, and whether an
+// exclusive (writer) lock is held on path, as the kernel lock table shows:
+// flock, fcntl and OFD locks of any process on the host. Waiters are not counted.
+//
+// The result is a snapshot, only for monitoring.
+func Holders(path string) (readers int, writer bool, err error) {
+	readers, pid, err := holders(path)
+	return readers, pid != 0, err
+}
+
+// holders returns the number of readers, and the PID of the writer
+func holders(path string) (readers, writer int, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, syscall.ENOTSUP
+	}
+	return lockTable(uint64(st.Dev), uint64(st.Ino))
+}
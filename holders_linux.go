@@ -0,0 +1,72 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lockTable counts the locks of the inode in /proc/locks, where a line is like
+//
+//	1: FLOCK  ADVISORY  READ 26966 fe:00:15933474 0 EOF
+//
+// (waiters have a "->" after the number). writer is the PID of the exclusive
+// holder (-1 for OFD locks), 0 if none.
+func lockTable(dev, ino uint64) (readers, writer int, err error) {
+	fh, err := os.Open("/proc/locks")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fh.Close()
+	want := inodeKey(dev, ino)
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[1] == "->" || fields[5] != want {
+			continue
+		}
+		switch fields[3] {
+		case "READ":
+			readers++
+		case "WRITE":
+			if writer, err = strconv.Atoi(fields[4]); err != nil {
+				return readers, writer, err
+			}
+		}
+	}
+	return readers, writer, scanner.Err()
+}
+
+// lockedInodes returns the inodes (as inodeKey) with a lock held in /proc/locks
+func lockedInodes() (map[string]struct{}, error) {
+	fh, err := os.Open("/proc/locks")
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	locked := make(map[string]struct{})
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[1] == "->" {
+			continue
+		}
+		locked[fields[5]] = struct{}{}
+	}
+	return locked, scanner.Err()
+}
+
+// inodeKey returns the device:inode as /proc/locks shows it,
+// with the userspace encoding of dev_t
+func inodeKey(dev, ino uint64) string {
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	return fmt.Sprintf("%02x:%02x:%d", major, minor, ino)
+}
@@ -0,0 +1,16 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package locking
+
+import "syscall"
+
+// The kernel lock table is read only on Linux.
+func lockTable(dev, ino uint64) (int, int, error) { return 0, 0, syscall.ENOTSUP }
+
+func lockedInodes() (map[string]struct{}, error) { return nil, syscall.ENOTSUP }
+
+func inodeKey(dev, ino uint64) string { return "" }
@@ -0,0 +1,54 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestHolders(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the lock table is read on Linux only")
+	}
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fh.Name())
+	defer fh.Close()
+	path := fh.Name()
+
+	var shared []*os.File
+	for i := 0; i < 2; i++ {
+		r, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = syscall.Flock(int(r.Fd()), syscall.LOCK_SH); err != nil {
+			t.Fatal(err)
+		}
+		shared = append(shared, r)
+	}
+	if readers, writer, err := locking.Holders(path); err != nil || readers != 2 || writer {
+		t.Fatalf("readers=%d writer=%t, %v; wanted 2 readers", readers, writer, err)
+	}
+	for _, r := range shared {
+		r.Close()
+	}
+
+	lock, err := locking.NewFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+	if readers, writer, err := locking.Holders(path); err != nil || readers != 0 || !writer {
+		t.Fatalf("readers=%d writer=%t, %v; wanted the writer", readers, writer, err)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import "sync"
+
+// ReleaseHooks are callbacks run after every successful Unlock of the
+// wrapped locks, whichever code path releases them - register the cleanup
+// once, instead of at each release path.
+// The zero value has no hooks.
+type ReleaseHooks struct {
+	mu    sync.Mutex
+	hooks []func(name string)
+}
+
+// Add registers fn to be called after each successful Unlock, in
+// registration order, with the name given to Wrap.
+func (h *ReleaseHooks) Add(fn func(name string)) {
+	h.mu.Lock()
+	h.hooks = append(h.hooks, fn)
+	h.mu.Unlock()
+}
+
+// Wrap returns lock, running the hooks after each successful Unlock
+func (h *ReleaseHooks) Wrap(name string, lock Locker) TryLocker {
+	return hookedLock{Locker: lock, name: name, hooks: h}
+}
+
+func (h *ReleaseHooks) run(name string) {
+	h.mu.Lock()
+	hooks := make([]func(string), len(h.hooks))
+	copy(hooks, h.hooks)
+	h.mu.Unlock()
+	for _, fn := range hooks {
+		fn(name)
+	}
+}
+
+type hookedLock struct {
+	Locker
+	name  string
+	hooks *ReleaseHooks
+}
+
+func (l hookedLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	return tl.TryLock()
+}
+
+func (l hookedLock) Unlock() error {
+	err := l.Locker.Unlock()
+	if err == nil {
+		l.hooks.run(l.name)
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+package locking_test
+
+import (
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestReleaseHooks(t *testing.T) {
+	var hooks locking.ReleaseHooks
+	var released []string
+	hooks.Add(func(name string) { released = append(released, name) })
+	lock := hooks.Wrap("port", locking.NewPortLock(freePort(t)))
+	if err := testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	// testLock unlocks twice: after TryLock and after Lock
+	if len(released) != 2 || released[0] != "port" {
+		t.Errorf("released=%q", released)
+	}
+}
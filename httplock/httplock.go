@@ -0,0 +1,100 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package httplock serializes HTTP requests through a locking.LockRegistry:
+// per route, or per resource, for the endpoints which must never run
+// concurrently. The requests which cannot get the lock in time get
+// 423 Locked.
+//
+//	mw := httplock.Middleware{Registry: locking.NewProcessLockRegistry(dir),
+//		Key: httplock.PathKey("/admin/reindex/"), Timeout: time.Second}
+//	mux.Handle("/admin/reindex/", mw.Wrap(reindex))
+package httplock
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+// Middleware holds the lock of the key of the request while serving it
+type Middleware struct {
+	// Registry of the keys, a registry of this process only if nil
+	Registry *locking.LockRegistry
+	// Key returns the key of the request, "" to serve it without a lock.
+	// The path of the request if nil.
+	Key func(r *http.Request) string
+	// Timeout is the wait for the lock: 423 Locked after it, at once if zero
+	Timeout time.Duration
+	// OnError, if set, gets the errors of the acquisitions, answered
+	// with 500 Internal Server Error
+	OnError func(r *http.Request, err error)
+}
+
+// PathKey returns a Key function for the resources under prefix: the key
+// of /prefix/id/anything is /prefix/id, the paths not under prefix have no key.
+func PathKey(prefix string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return ""
+		}
+		id := r.URL.Path[len(prefix):]
+		if i := strings.IndexByte(id, '/'); i >= 0 {
+			id = id[:i]
+		}
+		if id == "" {
+			return ""
+		}
+		return prefix + id
+	}
+}
+
+// Wrap returns next, serialized per key
+func (m Middleware) Wrap(next http.Handler) http.Handler {
+	reg := m.Registry
+	if reg == nil {
+		reg = new(locking.LockRegistry)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		if m.Key != nil {
+			key = m.Key(r)
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var h *locking.Held
+		var err error
+		if m.Timeout <= 0 {
+			h, err = reg.TryAcquire(key)
+		} else {
+			ctx, cancel := context.WithTimeout(r.Context(), m.Timeout)
+			h, err = reg.AcquireContext(ctx, key)
+			cancel()
+			if err != nil && r.Context().Err() == nil && ctx.Err() != nil {
+				err = nil // timed out: held
+			}
+		}
+		switch {
+		case err != nil:
+			if r.Context().Err() != nil { // the client is gone
+				return
+			}
+			if m.OnError != nil {
+				m.OnError(r, err)
+			}
+			http.Error(w, "lock: "+err.Error(), http.StatusInternalServerError)
+			return
+		case h == nil:
+			http.Error(w, key+" is locked", http.StatusLocked)
+			return
+		}
+		defer h.Release()
+		next.ServeHTTP(w, r)
+	})
+}
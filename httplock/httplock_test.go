@@ -0,0 +1,59 @@
+package httplock_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking/httplock"
+)
+
+func TestMiddleware(t *testing.T) {
+	var once sync.Once
+	inside, release := make(chan struct{}), make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/1/reindex" {
+			first := false
+			once.Do(func() { first = true })
+			if first {
+				close(inside)
+				<-release
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mw := httplock.Middleware{Key: httplock.PathKey("/users/"), Timeout: 20 * time.Millisecond}
+	srv := httptest.NewServer(mw.Wrap(slow))
+	defer srv.Close()
+	get := func(path string) int {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	first := make(chan int, 1)
+	go func() { first <- get("/users/1/reindex") }()
+	<-inside
+	if code := get("/users/1/delete"); code != http.StatusLocked {
+		t.Errorf("same user: got %d, wanted 423", code)
+	}
+	if code := get("/users/2/delete"); code != http.StatusNoContent {
+		t.Errorf("other user: got %d", code)
+	}
+	if code := get("/other"); code != http.StatusNoContent {
+		t.Errorf("no key: got %d", code)
+	}
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first: got %d", code)
+	}
+	if code := get("/users/1/reindex"); code != http.StatusNoContent {
+		t.Errorf("after release: got %d", code)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNotInspectable is returned by Inspect for locks without Info
+var ErrNotInspectable = errors.New("lock cannot be inspected")
+
+// LockInfo is the state of a lock, as seen from outside, without acquiring it.
+// The fields a lock type cannot tell are left zero.
+type LockInfo struct {
+	Locked  bool
+	Readers int       // shared holders
+	PID     int       // holder process (-1: an OFD lock, of unknown process)
+	Host    string    // holder host
+	Since   time.Time // acquisition time
+	Expires time.Time // end of the lease
+}
+
+// Inspector is a lock which can tell its state without acquiring it
+type Inspector interface {
+	Info() (LockInfo, error)
+}
+
+// Inspect returns the state of lock, if it is an Inspector
+func Inspect(lock Locker) (LockInfo, error) {
+	if i, ok := lock.(Inspector); ok {
+		return i.Info()
+	}
+	return LockInfo{}, ErrNotInspectable
+}
+
+// Info returns the holders in the kernel lock table (Linux only), and the
+// recorded owner of a lock created with FLockOptions.Owner
+func (lock *FLock) Info() (LockInfo, error) {
+	var info LockInfo
+	var err error
+	if info.Readers, info.PID, err = holders(lock.path); err != nil {
+		return info, err
+	}
+	info.Locked = info.PID != 0 || info.Readers != 0
+	if lock.owner && info.PID != 0 {
+		if o, err := readOwnerFile(lock.path); err == nil {
+			info.Host, info.Since = o.Host, o.Since
+		}
+	}
+	return info, nil
+}
+
+// Info returns the recorded owner of the directory lock
+func (lock DirLock) Info() (LockInfo, error) {
+	o, err := readOwner(string(lock))
+	if err == nil {
+		return LockInfo{Locked: true, PID: o.PID, Host: o.Host, Since: o.Since}, nil
+	}
+	if !os.IsNotExist(err) {
+		return LockInfo{}, err
+	}
+	// released, or an ownerless lock
+	if _, err = os.Stat(string(lock)); err != nil {
+		if os.IsNotExist(err) {
+			return LockInfo{}, nil
+		}
+		return LockInfo{}, err
+	}
+	return LockInfo{Locked: true}, nil
+}
+
+// Info returns the recorded PID, locked if that process runs
+func (lock *PIDFileLock) Info() (LockInfo, error) {
+	pid, alive := lock.Holder()
+	info := LockInfo{Locked: alive, PID: pid}
+	if fi, err := os.Stat(lock.path); err == nil && alive {
+		info.Since = fi.ModTime()
+	}
+	return info, nil
+}
+
+// Info returns the holder and the expiry of the lease
+func (l *LeaseLock) Info() (LockInfo, error) {
+	token, expires, err := readLease(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return LockInfo{}, nil
+		}
+		return LockInfo{}, err
+	}
+	info := LockInfo{Locked: time.Now().Before(expires), Expires: expires}
+	// host/pid/nanos
+	if parts := strings.Split(token, "/"); len(parts) == 3 {
+		info.Host = parts[0]
+		info.PID, _ = strconv.Atoi(parts[1])
+	}
+	return info, nil
+}
+
+// Info returns the holder, and the expiry by the last heartbeat
+func (l *NFSLeaseLock) Info() (LockInfo, error) {
+	fi, err := os.Stat(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return LockInfo{}, nil
+		}
+		return LockInfo{}, err
+	}
+	// a lapsed heartbeat is taken over after TTL+AttrCache
+	expires := fi.ModTime().Add(l.ttl.get())
+	info := LockInfo{Locked: time.Now().Before(expires.Add(l.AttrCache)), Expires: expires}
+	if b, err := ioutil.ReadFile(l.path); err == nil {
+		// host.pid.nanos, where host may contain dots
+		if parts := strings.Split(string(b), "."); len(parts) >= 3 {
+			info.Host = strings.Join(parts[:len(parts)-2], ".")
+			info.PID, _ = strconv.Atoi(parts[len(parts)-2])
+		}
+	}
+	return info, nil
+}
+
+// Info returns the first conflicting lock on the whole file (F_GETLK).
+// Record locks of this process are not reported, except OFD locks of other
+// files.
+func (lock *FcntlLock) Info() (LockInfo, error) {
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	if err := syscall.FcntlFlock(lock.fh.Fd(), lock.cmds.getlk, &lk); err != nil {
+		return LockInfo{}, err
+	}
+	if lk.Type == syscall.F_UNLCK {
+		return LockInfo{}, nil
+	}
+	info := LockInfo{Locked: true, PID: int(lk.Pid)}
+	if lk.Type == syscall.F_RDLCK {
+		info.Readers = 1
+	}
+	return info, nil
+}
+
+// Info reports the port locked if it accepts connections; a range is locked
+// if all of its ports do, an ephemeral port if this PortLock holds it.
+func (p *PortLock) Info() (LockInfo, error) {
+	if p.ephemeral() {
+		return LockInfo{Locked: p.ln != nil}, nil
+	}
+	for _, port := range p.ports {
+		if info, _ := dialInfo("tcp", loopback(port)); !info.Locked {
+			return info, nil
+		}
+	}
+	if p.ports != nil {
+		return LockInfo{Locked: true}, nil
+	}
+	return dialInfo("tcp", p.hostport)
+}
+
+// Info reports the socket locked if it accepts connections
+func (s *SocketLock) Info() (LockInfo, error) {
+	return dialInfo("unix", s.addr)
+}
+
+func dialInfo(network, addr string) (LockInfo, error) {
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		return LockInfo{}, nil
+	}
+	conn.Close()
+	return LockInfo{Locked: true}, nil
+}
@@ -0,0 +1,82 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	host, _ := os.Hostname()
+
+	dl, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := locking.Inspect(dl); err != nil || info.Locked {
+		t.Fatalf("unlocked dir: %+v, %v", info, err)
+	}
+	if err = dl.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := locking.Inspect(dl)
+	if err != nil || !info.Locked || info.PID != os.Getpid() || info.Host != host || info.Since.IsZero() {
+		t.Errorf("locked dir: %+v, %v", info, err)
+	}
+	dl.Unlock()
+
+	ll := locking.NewLeaseLock(filepath.Join(dir, "lease"), time.Minute)
+	if err = ll.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	info, err = ll.Info()
+	if err != nil || !info.Locked || info.PID != os.Getpid() || info.Host != host || info.Expires.Before(time.Now()) {
+		t.Errorf("lease: %+v, %v", info, err)
+	}
+	ll.Unlock()
+	if info, err = ll.Info(); err != nil || info.Locked {
+		t.Errorf("released lease: %+v, %v", info, err)
+	}
+
+	if _, err = locking.Inspect(make(chanLock, 1)); err != locking.ErrNotInspectable {
+		t.Errorf("got %v, wanted ErrNotInspectable", err)
+	}
+}
+
+func TestInfoFLock(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the lock table is read on Linux only")
+	}
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+
+	lock, err := locking.NewFLockWith(path, locking.FLockOptions{Create: true, Owner: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := lock.Info(); err != nil || info.Locked {
+		t.Fatalf("unlocked: %+v, %v", info, err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+	info, err := lock.Info()
+	if err != nil || !info.Locked || info.PID != os.Getpid() || info.Since.IsZero() {
+		t.Errorf("locked: %+v, %v", info, err)
+	}
+}
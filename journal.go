@@ -0,0 +1,206 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// record framing: length, crc32 of the payload, payload, length again
+const (
+	journalHeader  = 8
+	journalTrailer = 4
+)
+
+// TornError is returned when a journal record is incomplete or fails its checksum
+type TornError struct {
+	Offset int64
+}
+
+func (e *TornError) Error() string {
+	return fmt.Sprintf("torn journal record at offset %d", e.Offset)
+}
+
+// Journal is a log file shared by many processes: each Append writes one
+// record at the tail, under a short exclusive record lock of the tail
+// (fcntl), so records of concurrent writers never interleave.
+//
+// The record lock is an OFD lock where available (Linux), so the Journals
+// of one process exclude each other as well; elsewhere it is a POSIX lock,
+// which belongs to the process: use one Journal per file per process then,
+// and do not read the journal (closing a descriptor of the file drops it).
+type Journal struct {
+	// Sync makes each Append fsync the file
+	Sync bool
+
+	mu   sync.Mutex
+	fh   *os.File
+	cmds fcntlCmds
+}
+
+// OpenJournal opens (or creates) the journal at path for appending
+func OpenJournal(path string) (*Journal, error) {
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cmds := ofdCmds
+	if cmds.setlk == 0 {
+		cmds = fcntlCmds{getlk: syscall.F_GETLK, setlk: syscall.F_SETLK, setlkw: syscall.F_SETLKW}
+	}
+	return &Journal{fh: fh, cmds: cmds}, nil
+}
+
+// Close the journal
+func (j *Journal) Close() error {
+	return j.fh.Close()
+}
+
+// Append writes rec as one record at the end of the journal.
+// A torn record left at the tail by a crashed writer is cut off first.
+func (j *Journal) Append(rec []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fd := int(j.fh.Fd())
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekEnd} // from EOF to infinity
+	if err := syscall.FcntlFlock(uintptr(fd), j.cmds.setlkw, &lk); err != nil {
+		return err
+	}
+	defer func() {
+		lk := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: io.SeekStart}
+		syscall.FcntlFlock(uintptr(fd), j.cmds.setlk, &lk)
+	}()
+
+	fi, err := j.fh.Stat()
+	if err != nil {
+		return err
+	}
+	end := fi.Size()
+	if !j.tailIntact(end) {
+		if end, err = lastGoodOffset(j.fh); err != nil {
+			return err
+		}
+		if err = j.fh.Truncate(end); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, journalHeader+len(rec)+journalTrailer)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(rec)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(rec))
+	copy(buf[journalHeader:], rec)
+	binary.BigEndian.PutUint32(buf[len(buf)-journalTrailer:], uint32(len(rec)))
+	if _, err = j.fh.WriteAt(buf, end); err != nil {
+		return err
+	}
+	if j.Sync {
+		return j.fh.Sync()
+	}
+	return nil
+}
+
+// tailIntact checks the last record which ends at end
+func (j *Journal) tailIntact(end int64) bool {
+	if end == 0 {
+		return true
+	}
+	if end < journalHeader+journalTrailer {
+		return false
+	}
+	var b [4]byte
+	if _, err := j.fh.ReadAt(b[:], end-journalTrailer); err != nil {
+		return false
+	}
+	n := int64(binary.BigEndian.Uint32(b[:]))
+	start := end - journalTrailer - n - journalHeader
+	if start < 0 {
+		return false
+	}
+	_, err := readRecord(io.NewSectionReader(j.fh, start, end-start))
+	return err == nil
+}
+
+// lastGoodOffset returns the end of the last intact record
+func lastGoodOffset(r io.ReaderAt) (int64, error) {
+	var off int64
+	br := bufio.NewReader(io.NewSectionReader(r, 0, 1<<62))
+	for {
+		rec, err := readRecord(br)
+		if err != nil {
+			if err == io.EOF || err == errTorn {
+				return off, nil
+			}
+			return off, err
+		}
+		off += int64(journalHeader + len(rec) + journalTrailer)
+	}
+}
+
+var errTorn = errors.New("torn record")
+
+// readRecord reads one record, returning io.EOF at a clean end and errTorn for a broken record
+func readRecord(r io.Reader) ([]byte, error) {
+	var hdr [journalHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTorn
+		}
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[0:4])
+	buf := make([]byte, int(n)+journalTrailer)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errTorn
+		}
+		return nil, err
+	}
+	rec := buf[:n]
+	if crc32.ChecksumIEEE(rec) != binary.BigEndian.Uint32(hdr[4:8]) ||
+		binary.BigEndian.Uint32(buf[n:]) != n {
+		return nil, errTorn
+	}
+	return rec, nil
+}
+
+// ReadJournal calls fn with each record of the journal at path, in order.
+// A broken record is reported as a *TornError — at the tail it may also be
+// an append in progress.
+func ReadJournal(path string, fn func(rec []byte) error) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	br := bufio.NewReader(fh)
+	var off int64
+	for {
+		rec, err := readRecord(br)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if err == errTorn {
+				return &TornError{Offset: off}
+			}
+			return err
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+		off += int64(journalHeader + len(rec) + journalTrailer)
+	}
+}
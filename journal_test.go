@@ -0,0 +1,111 @@
+package locking_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, err := locking.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if err := j.Append([]byte(fmt.Sprintf("writer %d record %d", w, i))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// a crashed writer leaves half a record behind
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Write([]byte{0, 0, 0, 42, 1, 2})
+	fh.Close()
+	err = locking.ReadJournal(path, func([]byte) error { return nil })
+	if _, ok := err.(*locking.TornError); !ok {
+		t.Errorf("wanted TornError, got %v", err)
+	}
+
+	if err = j.Append([]byte("after crash")); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	var last string
+	if err = locking.ReadJournal(path, func(rec []byte) error {
+		n++
+		last = string(rec)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 101 || last != "after crash" {
+		t.Errorf("got %d records, last %q", n, last)
+	}
+}
+
+func TestJournalLockOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, err := locking.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	ofd, err := locking.NewOFDLock(path)
+	if err != nil {
+		t.Skip("no OFD locks:", err)
+	}
+	ofd.Close()
+	// a record lock of this process excludes the Journal, as another one's
+	lock, err := locking.NewFcntlLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- j.Append([]byte("record")) }()
+	select {
+	case err = <-done:
+		t.Fatalf("Append beside the lock: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
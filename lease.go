@@ -0,0 +1,336 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLeaseLost is returned by LeaseLock.Unlock when the lease could not be
+// renewed in time, and may have been stolen
+var ErrLeaseLost = errors.New("lease lost")
+
+// ErrBadTTL is returned for a lease TTL which is not positive
+var ErrBadTTL = errors.New("lease TTL must be positive")
+
+// LeaseLock is a lock file holding an expiring lease: while held, a
+// goroutine renews it in every third of the TTL, and once it lapses (the
+// holder died or hung), others can steal it. Unlike flock, it works on any
+// filesystem with atomic link and rename - but it relies on the clocks of
+// the hosts being roughly in sync.
+type LeaseLock struct {
+	path string
+	ttl  ttlValue
+
+	mu    sync.Mutex
+	token string
+	lost  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// minLeaseTTL is the shortest lease TTL: the renewals tick in every third of it
+const minLeaseTTL = time.Millisecond
+
+// leaseTTL returns ttl, raised to minLeaseTTL
+func leaseTTL(ttl time.Duration) time.Duration {
+	if ttl < minLeaseTTL {
+		return minLeaseTTL
+	}
+	return ttl
+}
+
+// NewLeaseLock returns a lease lock on the lock file path (unlocked first),
+// with the given lease TTL (raised to 1ms if shorter)
+func NewLeaseLock(path string, ttl time.Duration) *LeaseLock {
+	l := &LeaseLock{path: path}
+	l.ttl.set(leaseTTL(ttl))
+	return l
+}
+
+// SetTTL changes the TTL of the following renewals; safe while held.
+// It returns ErrBadTTL if ttl is not positive, and raises it to 1ms if shorter.
+func (l *LeaseLock) SetTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrBadTTL
+	}
+	l.ttl.set(leaseTTL(ttl))
+	return nil
+}
+
+// Lock acquires the lease, blocking
+func (l *LeaseLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext acquires the lease, until ctx is done
+func (l *LeaseLock) LockContext(ctx context.Context) error {
+	eb := newExpBackoff(l.path)
+	defer beginWait(l.path)()
+	for {
+		ok, err := l.TryLock()
+		if ok && err == nil {
+			eb.Done()
+			return nil
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// TryLock acquires the lease, non-blocking, stealing it if it has lapsed
+func (l *LeaseLock) TryLock() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stop != nil {
+		return false, errors.New("lease already held by this LeaseLock")
+	}
+	host, _ := os.Hostname()
+	token := fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano())
+	for i := 0; i < 2; i++ {
+		ok, err := l.create(token)
+		if ok || err != nil {
+			return ok, err
+		}
+		if ok, err = l.steal(); !ok || err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Lost returns a channel which is closed when the lease could not be renewed.
+// It is nil when not held.
+func (l *LeaseLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Unlock stops the renewal and removes the lease.
+// It returns ErrLeaseLost if the lease has been lost meanwhile.
+func (l *LeaseLock) Unlock() error {
+	l.mu.Lock()
+	if l.stop == nil {
+		l.mu.Unlock()
+		return nil
+	}
+	close(l.stop)
+	done := l.done
+	l.mu.Unlock()
+	<-done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	token, lost := l.token, l.lost
+	l.token, l.lost, l.stop, l.done = "", nil, nil, nil
+	select {
+	case <-lost:
+		return ErrLeaseLost
+	default:
+	}
+	aside, err := takeLease(l.path, token)
+	if err != nil {
+		return err
+	}
+	return os.Remove(aside)
+}
+
+// create writes the lease aside and links it into place,
+// so a lease file is never seen half-written
+func (l *LeaseLock) create(token string) (bool, error) {
+	ttl := l.ttl.get()
+	tmp, err := writeLease(l.path, token, time.Now().Add(ttl))
+	if err != nil {
+		return false, err
+	}
+	err = os.Link(tmp, l.path)
+	os.Remove(tmp)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	l.token = token
+	l.lost = make(chan struct{})
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.renew(token, ttl, l.lost, l.stop, l.done)
+	return true, nil
+}
+
+// steal removes the lease if it has lapsed. The lease is renamed aside
+// first, and put back if it is not the one found lapsed (unless a new one
+// has been created meanwhile).
+func (l *LeaseLock) steal() (bool, error) {
+	token, expires, err := readLease(l.path)
+	if err != nil {
+		if os.IsNotExist(err) { // released meanwhile
+			return true, nil
+		}
+		return false, err
+	}
+	if time.Now().Before(expires) {
+		return false, nil
+	}
+	aside := fmt.Sprintf("%s.lapsed.%d.%d", l.path, os.Getpid(), time.Now().UnixNano())
+	if err = os.Rename(l.path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if t, e, err := readLease(aside); err != nil || t != token || !e.Equal(expires) {
+		// renewed or replaced meanwhile: give it back
+		if err = giveBack(aside, l.path); os.IsExist(err) {
+			err = nil
+		}
+		return false, err
+	}
+	r := CrashRecord{Lock: l.path, Kind: "lease", Reason: "lapsed", Holder: tokenOwner(token, "/")}
+	if fi, err := os.Stat(aside); err == nil {
+		r.LastHeartbeat = fi.ModTime() // renewals replace the file
+	}
+	meta, _ := ioutil.ReadFile(aside)
+	r.Metadata = string(meta)
+	recordCrash(r)
+	debugf(1, "lease %s: stole the lapsed lease of %s", l.path, token)
+	return true, os.Remove(aside)
+}
+
+// renew extends the lease in every third of the TTL, until stop is closed.
+// A renewal never overwrites the lease of another holder (see replaceLease):
+// racing a stealer, it may lose the lease, but never share it.
+func (l *LeaseLock) renew(token string, ttl time.Duration, lost, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	expires := time.Now().Add(ttl)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if t := l.ttl.get(); t != ttl {
+			ttl = t
+			ticker.Reset(ttl / 3)
+		}
+		next := time.Now().Add(ttl)
+		err := replaceLease(l.path, token, next)
+		if err == ErrLeaseLost {
+			debugf(1, "lease %s: stolen", l.path)
+			close(lost)
+			return
+		}
+		if err == nil {
+			expires = next
+		} else if time.Now().After(expires) {
+			debugf(1, "lease %s: lost: %v", l.path, err)
+			close(lost)
+			return
+		}
+	}
+}
+
+// takeLease renames the lease at path aside, if it is token's, and returns
+// the name it has aside. A lease of another token is put back, and
+// ErrLeaseLost returned. Unlike reading and then replacing or removing the
+// lease, this cannot overwrite the lease of a thief, taken meanwhile.
+func takeLease(path, token string) (string, error) {
+	aside := fmt.Sprintf("%s.own.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrLeaseLost
+		}
+		return "", err
+	}
+	if current, _, err := readLease(aside); err != nil || current != token {
+		giveBack(aside, path)
+		return "", ErrLeaseLost
+	}
+	return aside, nil
+}
+
+// replaceLease renews the lease of token at path, to expire at expires.
+// The lease is taken aside, and the renewed one linked into place: if
+// another holder has got in meanwhile, the link fails with ErrLeaseLost.
+func replaceLease(path, token string, expires time.Time) error {
+	tmp, err := writeLease(path, token, expires)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	aside, err := takeLease(path, token)
+	if err != nil {
+		return err
+	}
+	if err = os.Link(tmp, path); err != nil {
+		if os.IsExist(err) {
+			os.Remove(aside)
+			return ErrLeaseLost
+		}
+		// keep the old one
+		if err := giveBack(aside, path); os.IsExist(err) {
+			return ErrLeaseLost
+		}
+		return err
+	}
+	return os.Remove(aside)
+}
+
+// giveBack puts the lease renamed aside back to path, unless a new lease
+// has been created there meanwhile (then the one aside is removed)
+func giveBack(aside, path string) error {
+	err := os.Link(aside, path)
+	os.Remove(aside)
+	return err
+}
+
+func writeLease(path, token string, expires time.Time) (string, error) {
+	fh, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(fh, "%s\n%d\n", token, expires.UnixNano())
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fh.Name())
+		return "", err
+	}
+	return fh.Name(), nil
+}
+
+func readLease(path string) (token string, expires time.Time, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", expires, err
+	}
+	lines := strings.SplitN(string(b), "\n", 3)
+	if len(lines) < 2 {
+		return "", expires, fmt.Errorf("%s: bad lease %q", path, b)
+	}
+	ns, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return "", expires, err
+	}
+	return lines[0], time.Unix(0, ns), nil
+}
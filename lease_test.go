@@ -0,0 +1,99 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLeaseLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lease")
+
+	const ttl = 30 * time.Millisecond
+	a := locking.NewLeaseLock(path, ttl)
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	b := locking.NewLeaseLock(path, ttl)
+	// renewed, so never lapses
+	time.Sleep(3 * ttl)
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("renewed lease stolen: %t, %v", ok, err)
+	}
+	if err = a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a hung holder: its lease file stays, unrenewed
+	if err = ioutil.WriteFile(path, []byte("hung\n0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("lapsed lease not stolen: %t, %v", ok, err)
+	}
+	select {
+	case <-b.Lost():
+		t.Fatal("lease lost")
+	default:
+	}
+	if err = b.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaseLockStolen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lease")
+
+	// a TTL too short for the renewal ticker is raised
+	a := locking.NewLeaseLock(path, time.Nanosecond)
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	a.Unlock()
+
+	a = locking.NewLeaseLock(path, 30*time.Millisecond)
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	// a thief replaces the lease: the renewal must not overwrite it
+	thief := "thief\n" + strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10) + "\n"
+	if err = ioutil.WriteFile(path+".tmp", []byte(thief), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-a.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("stolen lease not lost")
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != thief {
+		t.Errorf("thief's lease overwritten: %q, %v", b, err)
+	}
+	if err = a.Unlock(); err != locking.ErrLeaseLost {
+		t.Errorf("Unlock of a stolen lease: got %v, wanted ErrLeaseLost", err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != thief {
+		t.Errorf("thief's lease removed: %q, %v", b, err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// LockedWriteFile rewrites path crash-safely and exclusively: it flocks the
+// file (created if missing), gives its contents to fn as r, and the output
+// of fn as w becomes the new contents: written to a temporary file, synced
+// and renamed into place before the lock is released. If fn fails, path is
+// left as it was.
+//
+// As the rename replaces the inode, a waiter which wakes up holding the
+// flock of the old one tries again on the new file - concurrent
+// LockedWriteFile calls on path are serialized.
+func LockedWriteFile(path string, fn func(r io.Reader, w io.Writer) error) error {
+	fh, fi, err := flockCurrent(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close() // releases the flock, after the rename
+
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(tmp)
+	err = fn(bufio.NewReader(fh), bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(fi.Mode().Perm())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	dh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dh.Close()
+	return dh.Sync()
+}
+
+// flockCurrent flocks path exclusively, until the locked file is the one at
+// path (not replaced while waiting)
+func flockCurrent(path string) (*os.File, os.FileInfo, error) {
+	defer beginWait(path)()
+	for {
+		fh, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = retryBlocking(func() error { return syscall.Flock(int(fh.Fd()), syscall.LOCK_EX) })
+		if err != nil {
+			fh.Close()
+			return nil, nil, err
+		}
+		fi, err := fh.Stat()
+		if err != nil {
+			fh.Close()
+			return nil, nil, err
+		}
+		if pfi, err := os.Stat(path); err == nil && os.SameFile(fi, pfi) {
+			return fh, fi, nil
+		}
+		fh.Close()
+	}
+}
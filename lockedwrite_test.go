@@ -0,0 +1,64 @@
+package locking_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLockedWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "counter")
+
+	// concurrent increments must not get lost
+	increment := func(r io.Reader, w io.Writer) error {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+		_, err = io.WriteString(w, strconv.Itoa(n+1)+"\n")
+		return err
+	}
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := locking.LockedWriteFile(path, increment); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if b, err := ioutil.ReadFile(path); err != nil || strings.TrimSpace(string(b)) != strconv.Itoa(writers) {
+		t.Fatalf("got %q, %v; wanted %d", b, err, writers)
+	}
+
+	errFn := errors.New("failed")
+	if err = locking.LockedWriteFile(path, func(r io.Reader, w io.Writer) error {
+		io.WriteString(w, "garbage")
+		return errFn
+	}); err != errFn {
+		t.Errorf("got %v, wanted the error of fn", err)
+	}
+	if b, _ := ioutil.ReadFile(path); strings.TrimSpace(string(b)) != strconv.Itoa(writers) {
+		t.Errorf("failed update changed the file to %q", b)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "counter.*")); len(names) != 0 {
+		t.Errorf("temporary files left: %q", names)
+	}
+}
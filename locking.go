@@ -3,10 +3,18 @@
 // license that can be found in the LICENSE file.
 
 // Package locking contains file- and network (port) locking primitives
+//
+// The package depends on the standard library only. Backends needing
+// anything more (database drivers, network clients) live in their own
+// subpackages (like sqllock), so importing locking for FLock alone does not
+// pull in their dependency trees.
 package locking
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net"
 	"os"
@@ -20,10 +28,80 @@ import (
 // AlreadyLocked is an error
 var AlreadyLocked = errors.New("AlreadyLocked")
 
-// FLock is a file-based lock
+// Locker is the common interface of the locks in this package
+type Locker interface {
+	Lock() error
+	Unlock() error
+}
+
+// ContextLocker is a Locker whose blocking acquisition can be cancelled:
+// all the lock types of this package implement it. The wrappers returned
+// as a Locker or TryLocker (Budget.Wrap, Recorder.Wrap ...) may not: use
+// the LockContext function for them.
+type ContextLocker interface {
+	Locker
+	// LockContext acquires the lock, blocking until ctx is done
+	LockContext(ctx context.Context) error
+}
+
+// LockContext acquires lock, until ctx is done.
+// Locks which are not ContextLockers are polled with TryLock if they are
+// TryLockers; else Lock runs on its own goroutine, and the lock is released
+// as soon as it is acquired after ctx is done.
+func LockContext(ctx context.Context, lock Locker) error {
+	switch l := lock.(type) {
+	case ContextLocker:
+		return l.LockContext(ctx)
+	case TryLocker:
+		delay := time.Millisecond
+		for {
+			if ok, err := l.TryLock(); ok || err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			if delay < 100*time.Millisecond {
+				delay *= 2
+			}
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- lock.Lock() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		go func() {
+			if err := <-done; err == nil {
+				lock.Unlock()
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+// FLock is a file-based lock. It is safe for concurrent use: the goroutines
+// sharing an FLock exclude each other, as the processes do.
 type FLock struct {
 	path string
 	fh   *os.File
+	gen  *os.File // generation stamp, if tracked
+	held bool
+	// shared is true while held with RLock
+	shared bool
+	closed bool
+	// keepOpen keeps fh open between holds, until Close
+	keepOpen bool
+	flag     int
+	perm     os.FileMode
+	owner    bool // write the holder into the file
+	stacked  bool // the acquisition stack is recorded, see SetCaptureStacks
+	// sem is taken by the hold: the flock is on the open file description,
+	// which the goroutines using this FLock share, so it does not exclude them
+	sem chan struct{}
 	sync.Mutex
 }
 
@@ -33,53 +111,199 @@ func NewFLock(path string) (*FLock, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &FLock{path: path, fh: fh}, nil
+	return &FLock{path: path, fh: fh, sem: make(chan struct{}, 1)}, nil
 }
 
-// Lock acquires the lock, blocking
-func (lock *FLock) Lock() error {
+// enter waits until no other goroutine holds this FLock - shared or not -,
+// and returns with lock.Mutex locked. With a nil ctx it does not wait.
+func (lock *FLock) enter(ctx context.Context) (ok bool, err error) {
+	if ctx == nil {
+		select {
+		case lock.sem <- struct{}{}:
+		default:
+			return false, nil
+		}
+	} else {
+		select {
+		case lock.sem <- struct{}{}:
+		case <-ctx.Done():
+			return false, contentionError(lock.path, func() string { return readHolderStack(lock.path) }, ctx.Err())
+		}
+	}
 	lock.Mutex.Lock()
-	defer lock.Mutex.Unlock()
+	return true, nil
+}
+
+// leave returns from enter: lock.Mutex is unlocked, and the hold is given
+// up if the acquisition failed
+func (lock *FLock) leave() {
+	if !lock.held {
+		<-lock.sem
+	}
+	lock.Mutex.Unlock()
+}
+
+// Lock acquires the lock, blocking: the other goroutines using this FLock
+// are excluded as well as the other processes.
+// It waits for a shared hold of this FLock, too - use Upgrade to convert that.
+func (lock *FLock) Lock() error {
+	lock.enter(context.Background())
 	if lock.fh == nil {
 		var err error
-		if lock.fh, err = os.Open(lock.path); err != nil {
+		if lock.fh, err = lock.open(); err != nil {
+			lock.leave()
 			return err
 		}
 	}
-	err := syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_EX)
+	start := time.Now()
+	defer beginWait(lock.path)()
+	// try first, so the blocked waits count as contended
+	attempts := 1
+	err := syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		if err == syscall.EWOULDBLOCK {
+			attempts = 2
+		}
+		err = retryBlocking(func() error { return syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_EX) })
+	}
+	if err == nil {
+		recordAcquisition(lock.path, attempts, 0, time.Since(start))
+		lock.held, lock.shared = true, false
+		err = lock.acquired()
+	}
+	lock.leave()
 	return err
 }
 
-// TryLock acquires the lock, non-blocking
-func (lock FLock) TryLock() (bool, error) {
-	lock.Mutex.Lock()
-	defer lock.Mutex.Unlock()
+// LockContext acquires the lock, until ctx is done.
+// As flock cannot be interrupted, it polls with a backoff
+// capped at 100ms - unlike Lock, it does not wake up at the release.
+func (lock *FLock) LockContext(ctx context.Context) error {
+	if _, err := lock.enter(ctx); err != nil {
+		return err
+	}
+	defer lock.leave()
 	if lock.fh == nil {
 		var err error
-		if lock.fh, err = os.Open(lock.path); err != nil {
+		if lock.fh, err = lock.open(); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	defer beginWait(lock.path)()
+	attempts, delay := 1, time.Millisecond
+	for {
+		err := syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			recordAcquisition(lock.path, attempts, 0, time.Since(start))
+			lock.held, lock.shared = true, false
+			return lock.acquired()
+		}
+		if err != syscall.EWOULDBLOCK && !Retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return contentionError(lock.path, func() string { return readHolderStack(lock.path) }, ctx.Err())
+		case <-time.After(delay):
+		}
+		attempts++
+		if delay < 100*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+// TryLock acquires the lock, non-blocking.
+//
+// The uncontended path is an open and a flock, then a flock and a close at
+// Unlock: about 5µs per TryLock+Unlock (BenchmarkFLockTryLock, Linux, tmpfs).
+// FLockOptions.KeepOpen saves the open and the close, for under 1µs.
+// It returns false while another goroutine holds this FLock.
+func (lock *FLock) TryLock() (bool, error) {
+	if ok, _ := lock.enter(nil); !ok {
+		return false, nil
+	}
+	if lock.fh == nil {
+		fh, err := lock.open()
+		if err != nil {
+			lock.leave()
 			return false, err
 		}
+		lock.fh = fh
 	}
 	err := syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
-	switch err {
-	case nil:
-		return true, nil
-	case syscall.EWOULDBLOCK:
+	if err == nil {
+		lock.held, lock.shared = true, false
+		if err = lock.acquired(); err == nil {
+			lock.Mutex.Unlock()
+			return true, nil
+		}
+		lock.leave()
+		return false, err
+	}
+	lock.leave()
+	if err == syscall.EWOULDBLOCK {
 		return false, nil
 	}
 	return false, err
 }
 
-// Unlock releases the lock
+// Unlock releases the lock, and closes the file (reopened by the next Lock),
+// unless opened with FLockOptions.KeepOpen.
+// Unlocking an unlocked FLock is a no-op (see SetMisusePolicy).
 func (lock *FLock) Unlock() error {
 	lock.Mutex.Lock()
-	defer lock.Mutex.Unlock()
+	if !lock.held {
+		if err := misuse(lock.path, "Unlock of a lock not held"); err != nil {
+			lock.Mutex.Unlock()
+			return err
+		}
+	}
+	err := lock.unlock(!lock.keepOpen)
+	lock.Mutex.Unlock()
+	return err
+}
+
+func (lock *FLock) unlock(closeFile bool) error {
 	if lock.fh == nil {
 		return nil
 	}
-	err := syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_UN)
-	lock.fh.Close()
-	lock.fh = nil
+	var err error
+	if lock.held {
+		if lock.stacked { // before the next holder records its own
+			removeHolderStack(lock.path)
+			lock.stacked = false
+		}
+		if !lock.shared {
+			err = lock.endGeneration()
+		}
+		if uerr := syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_UN); uerr != nil {
+			err = uerr
+		}
+		<-lock.sem
+	}
+	lock.held, lock.shared = false, false
+	if closeFile {
+		lock.fh.Close()
+		lock.fh = nil
+	}
+	return err
+}
+
+// Close releases the lock if held, and all the file descriptors of the
+// FLock (the generation counter's too). The FLock cannot be locked again.
+func (lock *FLock) Close() error {
+	lock.Mutex.Lock()
+	defer lock.Mutex.Unlock()
+	err := lock.unlock(true)
+	if lock.gen != nil {
+		if cerr := lock.gen.Close(); err == nil {
+			err = cerr
+		}
+		lock.gen = nil
+	}
+	lock.closed = true
 	return err
 }
 
@@ -106,10 +330,12 @@ func FLockDirs(dirs ...string) (FLocks, error) {
 		if lock, err = NewFLock(path); err != nil {
 			return nil, err
 		}
-		if ok, err = lock.TryLock(); err != nil {
+		if ok, err = lock.TryLock(); err != nil || !ok {
+			lock.Close()
+			if err == nil {
+				err = AlreadyLocked
+			}
 			return nil, err
-		} else if !ok {
-			return nil, AlreadyLocked
 		}
 		locks = append(locks, lock)
 	}
@@ -129,7 +355,7 @@ type DirLock string
 
 // NewDirLock create new directory-based lock
 // (creates a subdir, if not exists, but unlocked first)
-// WARNING: no automatic Unlock on exit/panic!
+// WARNING: no automatic Unlock on exit/panic! See Do and RegisterCleanup.
 func NewDirLock(path string) (DirLock, error) {
 	fi, err := os.Lstat(path)
 	if err != nil {
@@ -145,39 +371,78 @@ func NewDirLock(path string) (DirLock, error) {
 
 // Lock locks (creates .lock subdir)
 func (lock DirLock) Lock() error {
+	return lock.LockContext(context.Background())
+}
+
+// LockContext locks, until ctx is done
+func (lock DirLock) LockContext(ctx context.Context) error {
 	var (
 		ok  bool
 		err error
 	)
-	eb := &expBackoff{time.Second}
+	eb := newExpBackoff(string(lock))
+	defer beginWait(string(lock))()
 	for {
 		if ok, err = lock.TryLock(); ok && err == nil {
+			eb.Done()
 			return nil
 		}
-		if err != nil {
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
 			return err
 		}
-		eb.Sleep()
 	}
 }
 
-// TryLock acquires the lock, non-blocking
+// TryLock acquires the lock, non-blocking.
+// The holder is recorded in an owner file in the directory, which is
+// prepared aside and renamed into place, so a lock is never empty.
 func (lock DirLock) TryLock() (bool, error) {
-	err := os.Mkdir(string(lock), 0600)
-	if err == nil {
-		return true, nil
+	path := string(lock)
+	tmp, err := ioutil.TempDir(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return false, err
+	}
+	if err = writeOwner(tmp); err == nil {
+		if err = os.Rename(tmp, path); err == nil {
+			return true, nil
+		}
 	}
-	return false, nil
+	os.RemoveAll(tmp)
+	if os.IsExist(err) {
+		return false, nil
+	}
+	return false, err
 }
 
-// Unlock releases the directory lock
+// Unlock releases the directory lock. The lock is renamed aside, and
+// removed only if its owner file names this process: a lock broken and
+// acquired by another one meanwhile is put back, and ErrNotOwner returned.
 func (lock DirLock) Unlock() error {
-	return os.Remove(string(lock))
+	path := string(lock)
+	aside := fmt.Sprintf("%s.unlock.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if owner, err := readOwner(aside); err != nil || !owner.sameProcess(currentOwner()) {
+		// a lock directory is never empty, so this does not replace a new one
+		if err = os.Rename(aside, path); err != nil {
+			os.RemoveAll(aside)
+		}
+		return ErrNotOwner
+	}
+	return os.RemoveAll(aside)
 }
 
 // PortLock is a locker which locks by binding to a port on the loopback IPv4 interface
 type PortLock struct {
 	hostport string
+	ports    []int // tried in order, if a range
 	ln       net.Listener
 }
 
@@ -188,23 +453,42 @@ func NewPortLock(port int) *PortLock {
 
 // Lock locks on port
 func (p *PortLock) Lock() error {
-	eb := &expBackoff{time.Second}
+	return p.LockContext(context.Background())
+}
+
+// LockContext locks on port, until ctx is done
+func (p *PortLock) LockContext(ctx context.Context) error {
+	eb := newExpBackoff(p.hostport)
+	defer beginWait(p.hostport)()
 	for {
-		if ok, err := p.TryLock(); ok {
+		ok, err := p.TryLock()
+		if ok {
+			eb.Done()
+			return err
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
 			return err
 		}
-		eb.Sleep()
 	}
-	return nil
 }
 
 // TryLock acquires the lock, non-blocking
 func (p *PortLock) TryLock() (bool, error) {
-	if l, err := net.Listen("tcp", p.hostport); err == nil {
+	if p.ports != nil {
+		return p.tryRange()
+	}
+	l, err := net.Listen("tcp", p.hostport)
+	if err == nil {
 		p.ln = l // thanks to zhangpy
 		return true, nil
 	}
-	return false, nil
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return false, nil
+	}
+	return false, err
 }
 
 // Unlock unlocks the port lock
@@ -219,10 +503,61 @@ func (p *PortLock) Unlock() error {
 
 type expBackoff struct {
 	time.Duration
+	cfg      Backoff
+	name     string
+	start    time.Time
+	attempts int
+	slept    time.Duration
+}
+
+func newExpBackoff(name string) *expBackoff {
+	return CurrentBackoff().start(name)
+}
+
+// Sleep sleeps the current backoff, or returns the error of ctx if it is done
+// sooner, or ErrTimeout if the MaxWait of the configuration is spent
+func (eb *expBackoff) Sleep(ctx context.Context) error {
+	d := eb.Duration
+	if eb.cfg.MaxWait > 0 {
+		left := eb.cfg.MaxWait - time.Since(eb.start)
+		if left <= 0 {
+			return ErrTimeout
+		}
+		if d > left {
+			d = left
+		}
+	}
+	if eb.name != "" {
+		debugf(2, "lock %s: attempt %d, sleeping %s", eb.name, eb.attempts, d)
+	}
+	t := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	case <-t.C:
+	}
+	eb.attempts++
+	eb.slept += d
+	eb.Duration = eb.cfg.next(eb.Duration, rand.Float64())
+	return nil
+}
+
+// next returns the sleep after d, with the random r in [0, 1):
+// in [d, Multiplier*d), capped at Max
+func (b Backoff) next(d time.Duration, r float64) time.Duration {
+	mult := b.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+	d += time.Duration(float64(d) * (mult - 1) * r)
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
 }
 
-func (eb *expBackoff) Sleep() {
-	time.Sleep(eb.Duration)
-	// next sleep length will be in [t, 2t)
-	eb.Duration += time.Duration(float32(eb.Duration) * rand.Float32())
+// Done records the successful acquisition in the statistics
+func (eb *expBackoff) Done() {
+	recordAcquisition(eb.name, eb.attempts, eb.slept, time.Since(eb.start))
 }
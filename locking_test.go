@@ -1,10 +1,15 @@
 package locking_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/tgulacsi/go-locking"
 )
@@ -26,16 +31,88 @@ func TestFLock(t *testing.T) {
 	}
 }
 
-func TestPortLock(t *testing.T) {
-	port := 1337
-	for port < 65535 {
-		lock := locking.NewPortLock(port)
-		if ok, _ := lock.TryLock(); ok {
-			lock.Unlock()
-			break
+func TestFLockConcurrent(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+
+	flock, err := locking.NewFLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg               sync.WaitGroup
+		holders, overlap int32
+	)
+	hold := func() {
+		if atomic.AddInt32(&holders, 1) > 1 {
+			atomic.AddInt32(&overlap, 1)
 		}
-		port++
+		time.Sleep(50 * time.Microsecond)
+		atomic.AddInt32(&holders, -1)
+		if err := flock.Unlock(); err != nil {
+			t.Error(err)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if i%2 == 0 {
+					if err := flock.Lock(); err != nil {
+						t.Error(err)
+						return
+					}
+					hold()
+				} else if ok, err := flock.TryLock(); err != nil {
+					t.Error(err)
+					return
+				} else if ok {
+					hold()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if overlap != 0 {
+		t.Errorf("%d holds overlapped", overlap)
+	}
+
+	// TryLock and Unlock reuse, then close the descriptor
+	fds := func() int {
+		des, _ := ioutil.ReadDir("/proc/self/fd")
+		return len(des)
+	}
+	before := fds()
+	for i := 0; i < 10; i++ {
+		if ok, err := flock.TryLock(); !ok || err != nil {
+			t.Fatalf("%d. TryLock: %t, %v", i, ok, err)
+		}
+		if err = flock.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		if err = flock.Unlock(); err != nil {
+			t.Fatal("second Unlock:", err)
+		}
+	}
+	if after := fds(); before > 0 && after > before {
+		t.Errorf("%d descriptors leaked", after-before)
+	}
+
+	if err = flock.Close(); err != nil {
+		t.Fatal(err)
 	}
+	if _, err = flock.TryLock(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("TryLock after Close: %v", err)
+	}
+}
+
+func TestPortLock(t *testing.T) {
+	port := freePort(t)
 	t.Logf("port=%d", port)
 	lock := locking.NewPortLock(port)
 	if err := testLock(lock); err != nil {
@@ -43,15 +120,25 @@ func TestPortLock(t *testing.T) {
 	}
 }
 
-type locker interface {
-	Lock() error
-	Unlock() error
+// freePort returns a port which is not locked currently
+func freePort(t *testing.T) int {
+	port := 1337
+	for port < 65535 {
+		lock := locking.NewPortLock(port)
+		if ok, _ := lock.TryLock(); ok {
+			lock.Unlock()
+			return port
+		}
+		port++
+	}
+	t.Fatal("no free port")
+	return 0
 }
 
 // test the lock.
 //
 // FIXME(tgulacsi): to test IPC locks, a separate process should be run.
-func testLock(lock locker) error {
+func testLock(lock locking.Locker) error {
 	tryLock, isTryLocker := lock.(interface {
 		TryLock() (bool, error)
 	})
@@ -74,3 +161,71 @@ func testLock(lock locker) error {
 	}
 	return lock.Unlock()
 }
+
+func TestLockContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fh, err := ioutil.TempFile(dir, "flock.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	port := freePort(t)
+
+	newLocks := func() []locking.Locker {
+		flock, err := locking.NewFLock(fh.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dlock, err := locking.NewDirLock(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []locking.Locker{flock, dlock, locking.NewPortLock(port)}
+	}
+	holders, waiters := newLocks(), newLocks()
+	for i, holder := range holders {
+		if err := holder.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := waiters[i].(locking.ContextLocker).LockContext(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%T: got %v, wanted DeadlineExceeded", holder, err)
+		}
+		holder.Unlock()
+		if err = locking.LockContext(context.Background(), waiters[i]); err != nil {
+			t.Errorf("%T: %v", holder, err)
+		}
+		waiters[i].Unlock()
+	}
+}
+
+func BenchmarkFLockTryLock(b *testing.B) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		b.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+	for _, keepOpen := range []bool{false, true} {
+		b.Run(fmt.Sprintf("KeepOpen=%t", keepOpen), func(b *testing.B) {
+			flock, err := locking.NewFLockWith(fh.Name(), locking.FLockOptions{KeepOpen: keepOpen})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer flock.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if ok, err := flock.TryLock(); !ok || err != nil {
+					b.Fatal(ok, err)
+				}
+				flock.Unlock()
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package locktest provides a lock backend for fast unit tests: Noop locks
+// never block, but record the operations, and check the locking discipline
+// of the code under test at the end of the test:
+//
+//	func TestJob(t *testing.T) {
+//		locks := locktest.NewNoop(t)
+//		job := NewJob(locks.Lock("queue"))
+//		...
+//	}
+package locktest
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+// Op is a recorded lock operation
+type Op struct {
+	Key  string
+	Kind string // Lock, TryLock or Unlock
+	OK   bool   // TryLock acquired; Lock and Unlock are always OK
+	Time time.Time
+}
+
+func (op Op) String() string {
+	if op.Kind == "TryLock" {
+		return fmt.Sprintf("%s %s: %t", op.Kind, op.Key, op.OK)
+	}
+	return op.Kind + " " + op.Key
+}
+
+// Noop is a lock backend which never blocks: Lock acquires at once even if
+// the key is held - recording the overlap as a violation -, TryLock reports
+// whether the key is free, as a real lock would.
+//
+// The violations are unlocks of keys not held, overlapping holds of a key,
+// and the keys still held at the end.
+type Noop struct {
+	mu         sync.Mutex
+	ops        []Op
+	held       map[string]int
+	violations []string
+}
+
+// NewNoop returns a Noop, checked at the cleanup of t (if not nil)
+func NewNoop(t testing.TB) *Noop {
+	n := &Noop{held: make(map[string]int)}
+	if t != nil {
+		t.Cleanup(func() {
+			if err := n.Check(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	return n
+}
+
+// Lock returns the lock of key; the locks of the same key share their state
+func (n *Noop) Lock(key string) locking.TryLocker { return noopLock{n: n, key: key} }
+
+// Register makes locking.Open return the locks of n for scheme:key URIs,
+// for the code configured with lock URIs (or LockSpecs)
+func (n *Noop) Register(scheme string) {
+	locking.RegisterScheme(scheme, func(u *url.URL) (locking.Locker, error) {
+		key := u.Opaque
+		if key == "" {
+			key = u.Path
+		}
+		return n.Lock(key), nil
+	})
+}
+
+// Ops returns the operations recorded so far, in order
+func (n *Noop) Ops() []Op {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Op(nil), n.ops...)
+}
+
+// Check returns the violations of the locking discipline, nil if none
+func (n *Noop) Check() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var leaked []string
+	for key, holds := range n.held {
+		if holds > 0 {
+			leaked = append(leaked, fmt.Sprintf("%s is still held", key))
+		}
+	}
+	sort.Strings(leaked)
+	violations := append(append([]string(nil), n.violations...), leaked...)
+	if len(violations) == 0 {
+		return nil
+	}
+	return errors.New("locking discipline violated:\n\t" + strings.Join(violations, "\n\t"))
+}
+
+func (n *Noop) record(key, kind string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	op := Op{Key: key, Kind: kind, OK: true, Time: time.Now()}
+	switch kind {
+	case "Lock":
+		if n.held[key] > 0 {
+			n.violations = append(n.violations, fmt.Sprintf("%s locked while held (op %d)", key, len(n.ops)))
+		}
+		n.held[key]++
+	case "TryLock":
+		if op.OK = n.held[key] == 0; op.OK {
+			n.held[key]++
+		}
+	case "Unlock":
+		if n.held[key] == 0 {
+			n.violations = append(n.violations, fmt.Sprintf("%s unlocked while not held (op %d)", key, len(n.ops)))
+		} else {
+			n.held[key]--
+		}
+	}
+	n.ops = append(n.ops, op)
+	return op.OK
+}
+
+type noopLock struct {
+	n   *Noop
+	key string
+}
+
+func (l noopLock) Lock() error            { l.n.record(l.key, "Lock"); return nil }
+func (l noopLock) TryLock() (bool, error) { return l.n.record(l.key, "TryLock"), nil }
+func (l noopLock) Unlock() error          { l.n.record(l.key, "Unlock"); return nil }
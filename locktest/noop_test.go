@@ -0,0 +1,46 @@
+package locktest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+	"github.com/tgulacsi/go-locking/locktest"
+)
+
+func TestNoop(t *testing.T) {
+	good := locktest.NewNoop(t)
+	lock := good.Lock("a")
+	if err := locking.Do(lock, func() error {
+		if ok, _ := good.Lock("a").TryLock(); ok {
+			t.Error("TryLock of a held key succeeded")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ops := good.Ops(); len(ops) != 3 || ops[1].Kind != "TryLock" || ops[1].OK {
+		t.Errorf("got %v", ops)
+	}
+
+	bad := locktest.NewNoop(nil)
+	bad.Register("noop")
+	a, err := locking.Open("noop:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Lock()
+	a.Lock() // would deadlock with a real lock
+	a.Unlock()
+	bad.Lock("b").Unlock()
+	bad.Lock("c").Lock()
+	err = bad.Check()
+	if err == nil {
+		t.Fatal("no violations found")
+	}
+	for _, want := range []string{"a locked while held", "b unlocked while not held", "c is still held"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q is missing from %v", want, err)
+		}
+	}
+}
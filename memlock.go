@@ -0,0 +1,234 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+var _ DistLocker = (*MemLock)(nil)
+
+// MemLock is a named lock within this process: no files, no network. The
+// MemLocks of the same name exclude each other, so a single-process
+// deployment can use them instead of the cross-process locks by
+// configuration (mem:name with Open, or the "mem" backend of LockSpec).
+//
+// A positive TTL bounds the holds: the lock is released TTL after the
+// acquisition, and Lost is closed - unlike a LeaseLock, it is not renewed.
+type MemLock struct {
+	name string
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	hold     *memHold
+	expired  bool
+	lastLost chan struct{} // of the expired hold
+}
+
+type memHold struct {
+	entry *memEntry
+	lost  chan struct{}
+	timer *time.Timer
+}
+
+// memEntry is the shared state of the MemLocks of a name, while held or
+// waited for
+type memEntry struct {
+	sem  chan struct{}
+	refs int // holders and waiters, guarded by memMu
+
+	mu      sync.Mutex
+	since   time.Time
+	expires time.Time
+	free    chan struct{} // closed at the release, nil if not held
+}
+
+var (
+	memMu    sync.Mutex
+	memLocks = make(map[string]*memEntry)
+)
+
+// NewMemLock returns the in-process lock of name, with holds bounded by
+// ttl (if positive)
+func NewMemLock(name string, ttl time.Duration) *MemLock {
+	return &MemLock{name: name, ttl: ttl}
+}
+
+// memRef returns the entry of name, counting a reference: the entries are
+// dropped at the last memUnref, so the names do not accumulate
+func memRef(name string) *memEntry {
+	memMu.Lock()
+	defer memMu.Unlock()
+	e := memLocks[name]
+	if e == nil {
+		e = &memEntry{sem: make(chan struct{}, 1)}
+		memLocks[name] = e
+	}
+	e.refs++
+	return e
+}
+
+func memUnref(name string, e *memEntry) {
+	memMu.Lock()
+	defer memMu.Unlock()
+	if e.refs--; e.refs == 0 {
+		delete(memLocks, name)
+	}
+}
+
+// memLookup returns the entry of name, nil if it is neither held nor
+// waited for
+func memLookup(name string) *memEntry {
+	memMu.Lock()
+	defer memMu.Unlock()
+	return memLocks[name]
+}
+
+// Lock acquires the lock, blocking
+func (l *MemLock) Lock() error { return l.LockContext(context.Background()) }
+
+// LockContext acquires the lock, until ctx is done. It wakes up at the release.
+func (l *MemLock) LockContext(ctx context.Context) error {
+	start := time.Now()
+	e := memRef(l.name)
+	select {
+	case e.sem <- struct{}{}:
+		l.acquired(e)
+		recordAcquisition(l.name, 1, 0, time.Since(start))
+		return nil
+	default:
+	}
+	defer beginWait(l.name)()
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		memUnref(l.name, e)
+		return ctx.Err()
+	}
+	l.acquired(e)
+	recordAcquisition(l.name, 2, 0, time.Since(start))
+	return nil
+}
+
+// TryLock acquires the lock, non-blocking
+func (l *MemLock) TryLock() (bool, error) {
+	e := memRef(l.name)
+	select {
+	case e.sem <- struct{}{}:
+		l.acquired(e)
+		return true, nil
+	default:
+		memUnref(l.name, e)
+		return false, nil
+	}
+}
+
+func (l *MemLock) acquired(e *memEntry) {
+	now := time.Now()
+	h := &memHold{entry: e, lost: make(chan struct{})}
+	e.mu.Lock()
+	e.since, e.free = now, make(chan struct{})
+	if l.ttl > 0 {
+		e.expires = now.Add(l.ttl)
+	}
+	e.mu.Unlock()
+	l.mu.Lock()
+	l.hold, l.expired = h, false
+	if l.ttl > 0 {
+		h.timer = time.AfterFunc(l.ttl, func() { l.expire(h) })
+	}
+	l.mu.Unlock()
+}
+
+// expire releases the hold h at the end of the TTL
+func (l *MemLock) expire(h *memHold) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.hold != h {
+		return
+	}
+	l.hold, l.expired, l.lastLost = nil, true, h.lost
+	l.release(h)
+	close(h.lost)
+	debugf(1, "lock %s: hold expired after %s", l.name, l.ttl)
+}
+
+// Lost returns a channel which is closed when the hold expires at the TTL.
+// It is nil when not held.
+func (l *MemLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.hold != nil {
+		return l.hold.lost
+	}
+	if l.expired {
+		return l.lastLost
+	}
+	return nil
+}
+
+// Unlock releases the lock. It returns ErrLeaseLost if the hold has
+// expired meanwhile. Unlocking an unlocked MemLock is a no-op.
+func (l *MemLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.expired {
+		l.expired, l.lastLost = false, nil
+		return ErrLeaseLost
+	}
+	h := l.hold
+	if h == nil {
+		return nil
+	}
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	l.hold = nil
+	l.release(h)
+	return nil
+}
+
+func (l *MemLock) release(h *memHold) {
+	e := h.entry
+	e.mu.Lock()
+	close(e.free)
+	e.free, e.since, e.expires = nil, time.Time{}, time.Time{}
+	e.mu.Unlock()
+	<-e.sem
+	memUnref(l.name, e)
+}
+
+// Info returns the state of the lock
+func (l *MemLock) Info() (LockInfo, error) {
+	e := memLookup(l.name)
+	if e == nil {
+		return LockInfo{}, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.free == nil {
+		return LockInfo{}, nil
+	}
+	host, _ := os.Hostname()
+	return LockInfo{Locked: true, PID: os.Getpid(), Host: host, Since: e.since, Expires: e.expires}, nil
+}
+
+// Watch returns a channel closed at the release of the lock (at once if it
+// is free), without polling
+func (l *MemLock) Watch(ctx context.Context) (<-chan struct{}, error) {
+	if e := memLookup(l.name); e != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.free != nil {
+			return e.free, nil
+		}
+	}
+	free := make(chan struct{})
+	close(free)
+	return free, nil
+}
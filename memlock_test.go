@@ -0,0 +1,83 @@
+package locking_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestMemLock(t *testing.T) {
+	a := locking.NewMemLock("test-mem", 0)
+	if err := testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	b, err := locking.Open("mem:test-mem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := b.(locking.TryLocker).TryLock(); ok {
+		t.Fatal("two holders of the same name")
+	}
+	if info, _ := locking.Inspect(b); !info.Locked {
+		t.Errorf("Info: %+v", info)
+	}
+	free, _ := a.Watch(context.Background())
+	got := make(chan error, 1)
+	go func() { got <- b.Lock() }()
+	time.Sleep(10 * time.Millisecond)
+	a.Unlock()
+	select {
+	case <-free:
+	case <-time.After(time.Second):
+		t.Fatal("Watch not woken up at the release")
+	}
+	select {
+	case err = <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken up at the release")
+	}
+	b.Unlock()
+
+	// the hold expires at the TTL
+	c, err := locking.LockSpec{Backend: "mem", Target: "test-ttl", TTL: locking.Duration(20 * time.Millisecond)}.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.(*locking.MemLock).Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Lost not closed at the TTL")
+	}
+	d := locking.NewMemLock("test-ttl", 0)
+	if ok, _ := d.TryLock(); !ok {
+		t.Error("expired hold not released")
+	}
+	d.Unlock()
+	if err = c.Unlock(); err != locking.ErrLeaseLost {
+		t.Errorf("got %v, wanted ErrLeaseLost", err)
+	}
+
+	// the released names are dropped
+	n := locking.MemLockNames()
+	for i := 0; i < 100; i++ {
+		l := locking.NewMemLock("test-request-"+strconv.Itoa(i), 0)
+		l.Lock()
+		l.TryLock()
+		l.Unlock()
+	}
+	if got := locking.MemLockNames(); got != n {
+		t.Errorf("%d names kept, wanted %d", got, n)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ErrNotOwner is returned by MetaLock.Unlock if the lock is owned by somebody else
+var ErrNotOwner = errors.New("not the owner of the lock")
+
+// Meta is the state carried by a MetaLock
+type Meta struct {
+	Owner   string          `json:"owner,omitempty"` // empty if free
+	Epoch   uint64          `json:"epoch"`           // bumped at each change of the owner
+	Payload json.RawMessage `json:"payload,omitempty"`
+	HLC     HLC             `json:"hlc"` // of the last update
+}
+
+// MetaLock is a lock carrying state: a JSON document (Meta) at path, read and
+// modified atomically under the flock of path+".guard", and replaced with a
+// rename, so readers always see a complete version.
+//
+// The holder is the Owner in the document, so ownership can be handed over
+// to another process without releasing it (CompareAndSwapOwner).
+type MetaLock struct {
+	path string
+	id   string
+}
+
+// NewMetaLock returns the lock at path for the owner id
+// (host/pid if empty; ids must be unique across the users)
+func NewMetaLock(path, id string) *MetaLock {
+	if id == "" {
+		o := currentOwner()
+		id = o.Host + "/" + strconv.Itoa(o.PID)
+	}
+	return &MetaLock{path: path, id: id}
+}
+
+// ID returns the owner id of this lock
+func (l *MetaLock) ID() string { return l.id }
+
+// Read returns the current document (the zero Meta if there is none yet)
+func (l *MetaLock) Read() (Meta, error) {
+	var m Meta
+	b, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// Update calls fn with the current document under the guard, and writes
+// back the modification if fn returns nil: a read-modify-write no other
+// user of path can interleave with. The Epoch is bumped if the Owner changes,
+// and the HLC is advanced past the previous one.
+func (l *MetaLock) Update(fn func(*Meta) error) error {
+	guard, err := os.OpenFile(l.path+".guard", os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer guard.Close()
+	if err = syscall.Flock(int(guard.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(guard.Fd()), syscall.LOCK_UN)
+	m, err := l.Read()
+	if err != nil {
+		return err
+	}
+	owner := m.Owner
+	if err = fn(&m); err != nil {
+		return err
+	}
+	if m.Owner != owner {
+		m.Epoch++
+	}
+	m.HLC = ObserveHLC(m.HLC)
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeDurable(l.path, string(b))
+}
+
+// CompareAndSwapOwner makes to the owner if from is: it hands the lock over
+// without a window in which it is free. It reports whether it swapped.
+func (l *MetaLock) CompareAndSwapOwner(from, to string) (bool, error) {
+	swapped := false
+	err := l.Update(func(m *Meta) error {
+		if m.Owner != from {
+			return errNotAcquired
+		}
+		m.Owner, swapped = to, true
+		return nil
+	})
+	if err == errNotAcquired {
+		err = nil
+	}
+	return swapped, err
+}
+
+// TryLock makes this the owner if the lock is free (or owned by this id already)
+func (l *MetaLock) TryLock() (bool, error) {
+	ok, err := l.CompareAndSwapOwner("", l.id)
+	if ok || err != nil {
+		return ok, err
+	}
+	m, err := l.Read()
+	return err == nil && m.Owner == l.id, err
+}
+
+// Lock waits until this is the owner
+func (l *MetaLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext waits until this is the owner, or ctx is done
+func (l *MetaLock) LockContext(ctx context.Context) error {
+	eb := newExpBackoff(l.path)
+	defer beginWait(l.path)()
+	for {
+		ok, err := l.TryLock()
+		if ok {
+			eb.Done()
+			return err
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// Unlock frees the lock, keeping the payload. It returns ErrNotOwner if it
+// is owned by another id (for example after a handover).
+func (l *MetaLock) Unlock() error {
+	ok, err := l.CompareAndSwapOwner(l.id, "")
+	if err == nil && !ok {
+		err = ErrNotOwner
+	}
+	return err
+}
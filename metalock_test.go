@@ -0,0 +1,59 @@
+package locking_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestMetaLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deploy.json")
+
+	blue, green := locking.NewMetaLock(path, "blue"), locking.NewMetaLock(path, "green")
+	if err = testLock(blue); err != nil {
+		t.Fatal(err)
+	}
+	if err = blue.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := green.TryLock(); ok || err != nil {
+		t.Fatalf("green: %t, %v", ok, err)
+	}
+	if err = blue.Update(func(m *locking.Meta) error {
+		m.Payload = json.RawMessage(`{"version":"1.2"}`)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := blue.Read()
+
+	// hand over without releasing
+	if ok, err := green.CompareAndSwapOwner("green", "green"); ok || err != nil {
+		t.Fatalf("swapped from the wrong owner: %t, %v", ok, err)
+	}
+	if ok, err := blue.CompareAndSwapOwner("blue", "green"); !ok || err != nil {
+		t.Fatalf("handover: %t, %v", ok, err)
+	}
+	if ok, err := green.TryLock(); !ok || err != nil {
+		t.Fatalf("green does not own it after the handover: %t, %v", ok, err)
+	}
+	after, err := green.Read()
+	if err != nil || after.Owner != "green" || after.Epoch != before.Epoch+1 || string(after.Payload) != `{"version":"1.2"}` {
+		t.Errorf("after the handover: %+v, %v (before: %+v)", after, err, before)
+	}
+	if err = blue.Unlock(); err != locking.ErrNotOwner {
+		t.Errorf("Unlock by the old owner: %v", err)
+	}
+	if err = green.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Migration is the record of a hand-over between backends, kept by
+// MigrateContext
+type Migration struct {
+	From     string    `json:"from"` // the backends, by lock type
+	To       string    `json:"to"`
+	By       Owner     `json:"by"`
+	Started  time.Time `json:"started"`  // to acquired: both are held from here
+	Finished time.Time `json:"finished"` // from released; zero while in progress
+	HLC      HLC       `json:"hlc"`      // of the last update
+}
+
+// ReadMigration returns the migration recorded at path
+func ReadMigration(path string) (Migration, error) {
+	var m Migration
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return m, &os.PathError{Op: "parse", Path: path, Err: err}
+	}
+	ObserveHLC(m.HLC)
+	return m, nil
+}
+
+// Migrate hands a held lock over from one backend to another, as
+// MigrateContext does, blocking.
+func Migrate(from, to Locker, overlap time.Duration, record string) error {
+	return MigrateContext(context.Background(), from, to, overlap, record)
+}
+
+// MigrateContext hands a held lock over from one backend to another.
+//
+// from must be held by the caller: ErrNotHeld is returned if it can be told
+// that it is not. MigrateContext acquires to (until ctx is done), keeps both
+// for the overlap window, then releases from - so there is no moment when
+// neither lock is held.
+// If acquiring to fails, from is still held and the error is returned.
+//
+// The transition is recorded in the JSON document at record (if not empty):
+// when to is acquired, and again when from is released.
+func MigrateContext(ctx context.Context, from, to Locker, overlap time.Duration, record string) error {
+	if held, known := heldHere(from); known && !held {
+		return ErrNotHeld
+	}
+	if err := LockContext(ctx, to); err != nil {
+		return err
+	}
+	m := Migration{From: fmt.Sprintf("%T", from), To: fmt.Sprintf("%T", to),
+		By: currentOwner(), Started: time.Now(), HLC: NowHLC()}
+	if err := m.write(record); err != nil {
+		to.Unlock()
+		return err
+	}
+	if overlap > 0 {
+		time.Sleep(overlap)
+	}
+	err := from.Unlock()
+	m.Finished, m.HLC = time.Now(), NowHLC()
+	if werr := m.write(record); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// write replaces the record at path with m, "" for none
+func (m Migration) write(path string) error {
+	if path == "" {
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	fh, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(b)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(fh.Name(), path)
+	}
+	if err != nil {
+		os.Remove(fh.Name())
+	}
+	return err
+}
+
+// heldHere reports whether lock is held by this process, and whether that
+// can be told at all
+func heldHere(lock Locker) (held, known bool) {
+	switch l := lock.(type) {
+	case *FLock:
+		l.Mutex.Lock()
+		defer l.Mutex.Unlock()
+		return l.held && !l.shared, true
+	case DirLock:
+		o, err := l.Owner()
+		return err == nil && o.sameProcess(currentOwner()), true
+	case BootDirLock:
+		o, err := l.Owner()
+		return err == nil && o.sameProcess(currentOwner()), true
+	case *PortLock:
+		return l.ln != nil, true
+	case *SocketLock:
+		return l.ln != nil, true
+	case DistLocker:
+		lost := l.Lost()
+		if lost == nil {
+			return false, true
+		}
+		select {
+		case <-lost:
+			return false, true
+		default:
+			return true, true
+		}
+	}
+	return false, false
+}
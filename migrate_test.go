@@ -0,0 +1,54 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestMigrate(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+
+	from, err := locking.NewFLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := locking.NewPortLock(freePort(t))
+	record := fh.Name() + ".migration"
+	defer os.Remove(record)
+	if err = locking.Migrate(from, to, 0, record); err != locking.ErrNotHeld {
+		t.Fatalf("Migrate of an unheld lock: %v", err)
+	}
+	if err = from.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = locking.Migrate(from, to, 10*time.Millisecond, record); err != nil {
+		t.Fatal(err)
+	}
+	defer to.Unlock()
+	m, err := locking.ReadMigration(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.From != "*locking.FLock" || m.To != "*locking.PortLock" || m.By.PID != os.Getpid() ||
+		m.Finished.Sub(m.Started) < 10*time.Millisecond {
+		t.Errorf("recorded %+v", m)
+	}
+
+	other, err := locking.NewFLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := other.TryLock(); err != nil || !ok {
+		t.Errorf("old lock should be released, got %t, %v", ok, err)
+	}
+	other.Unlock()
+}
@@ -0,0 +1,56 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrMisuse is wrapped by the errors of the MisuseError policy
+var ErrMisuse = errors.New("lock misuse")
+
+// MisusePolicy is what happens when the API of an FLock is misused: Unlock
+// of a lock not held, use after Close. The other lock types keep their own
+// handling of these (like ShmLock's ErrNotOwner).
+//
+// A Lock of a held FLock is not a misuse: it waits for the release, as the
+// FLock cannot tell its goroutines apart (a relock by the holder deadlocks,
+// as with sync.Mutex).
+type MisusePolicy int32
+
+const (
+	// MisuseLog logs the misuse (with SetLogger) and carries on: Unlock is
+	// a no-op, the use after Close fails with os.ErrClosed. The default.
+	MisuseLog MisusePolicy = iota
+	// MisuseError returns an error wrapping ErrMisuse
+	MisuseError
+	// MisusePanic panics, for failing fast in development
+	MisusePanic
+)
+
+var misusePolicy int32
+
+// SetMisusePolicy sets the policy of the misuses, for all the FLocks
+func SetMisusePolicy(p MisusePolicy) { atomic.StoreInt32(&misusePolicy, int32(p)) }
+
+// misuse applies the policy to the misuse of lock: the returned error is
+// not nil only with MisuseError
+func misuse(lock, what string) error {
+	err := &misuseError{lock: lock, what: what}
+	switch MisusePolicy(atomic.LoadInt32(&misusePolicy)) {
+	case MisusePanic:
+		panic(err)
+	case MisuseError:
+		return err
+	}
+	debugf(0, "%v", err)
+	return nil
+}
+
+type misuseError struct{ lock, what string }
+
+func (e *misuseError) Error() string { return "lock " + e.lock + ": " + e.what }
+func (e *misuseError) Unwrap() error { return ErrMisuse }
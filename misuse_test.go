@@ -0,0 +1,64 @@
+package locking_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestMisusePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewFLockCreate(filepath.Join(dir, "lock"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer locking.SetMisusePolicy(locking.MisuseLog)
+
+	// the default logs, and carries on
+	var logged []string
+	locking.SetLogger(func(format string, args ...interface{}) { logged = append(logged, fmt.Sprintf(format, args...)) })
+	if err = lock.Unlock(); err != nil {
+		t.Errorf("Unlock of a lock not held: %v", err)
+	}
+	locking.SetLogger(nil)
+	if len(logged) != 1 || !strings.Contains(logged[0], "not held") {
+		t.Errorf("logged %q", logged)
+	}
+
+	locking.SetMisusePolicy(locking.MisuseError)
+	if err = lock.Unlock(); !errors.Is(err, locking.ErrMisuse) {
+		t.Errorf("Unlock of a lock not held: got %v, wanted ErrMisuse", err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lock.TryLock(); ok || err != nil {
+		t.Errorf("TryLock of a held lock: got %t, %v, wanted false", ok, err)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	locking.SetMisusePolicy(locking.MisusePanic)
+	lock.Close()
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("Lock after Close did not panic")
+			} else if err, _ := r.(error); !errors.Is(err, locking.ErrMisuse) {
+				t.Errorf("panicked with %v", r)
+			}
+		}()
+		lock.Lock()
+	}()
+}
@@ -0,0 +1,86 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"sort"
+)
+
+// MultiLock acquires a set of locks of any types all or nothing, always in
+// the order of their names, so competing MultiLocks over overlapping sets
+// cannot deadlock.
+type MultiLock struct {
+	names []string
+	locks []Locker
+}
+
+// NewMultiLock returns a MultiLock over locks, ordered by their names
+func NewMultiLock(locks map[string]Locker) *MultiLock {
+	m := &MultiLock{names: make([]string, 0, len(locks))}
+	for name := range locks {
+		m.names = append(m.names, name)
+	}
+	sort.Strings(m.names)
+	for _, name := range m.names {
+		m.locks = append(m.locks, locks[name])
+	}
+	return m
+}
+
+// Names returns the names of the locks, in locking order
+func (m *MultiLock) Names() []string {
+	return append([]string(nil), m.names...)
+}
+
+// Lock acquires all the locks, blocking
+func (m *MultiLock) Lock() error {
+	return m.LockContext(context.Background())
+}
+
+// LockContext acquires all the locks, until ctx is done.
+// If any acquisition fails, the already acquired ones are released.
+func (m *MultiLock) LockContext(ctx context.Context) error {
+	for i, lock := range m.locks {
+		if err := LockContext(ctx, lock); err != nil {
+			m.release(i)
+			return err
+		}
+	}
+	return nil
+}
+
+// TryLock acquires all the locks, non-blocking: if any of them is held
+// (or is not a TryLocker), the already acquired ones are released.
+func (m *MultiLock) TryLock() (bool, error) {
+	for i, lock := range m.locks {
+		tl, ok := lock.(TryLocker)
+		if !ok {
+			m.release(i)
+			return false, ErrNoTryLock
+		}
+		if ok, err := tl.TryLock(); !ok || err != nil {
+			m.release(i)
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Unlock releases all the locks, in reverse order, and returns the first error
+func (m *MultiLock) Unlock() error {
+	return m.release(len(m.locks))
+}
+
+// release unlocks the first n locks, in reverse order
+func (m *MultiLock) release(n int) error {
+	var err error
+	for i := n - 1; i >= 0; i-- {
+		if uerr := m.locks[i].Unlock(); uerr != nil && err == nil {
+			err = uerr
+		}
+	}
+	return err
+}
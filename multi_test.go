@@ -0,0 +1,46 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestMultiLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dlock, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := freePort(t)
+	m := locking.NewMultiLock(map[string]locking.Locker{
+		"b-port": locking.NewPortLock(port),
+		"a-dir":  dlock,
+	})
+	if names := m.Names(); len(names) != 2 || names[0] != "a-dir" {
+		t.Errorf("names=%q", names)
+	}
+	if err = testLock(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// all or nothing: the dir is released when the port is taken
+	holder := locking.NewPortLock(port)
+	if err = holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.TryLock(); ok || err != nil {
+		t.Fatalf("got %t, %v", ok, err)
+	}
+	if ok, err := dlock.TryLock(); !ok || err != nil {
+		t.Fatalf("dir lock not rolled back: %t, %v", ok, err)
+	}
+	dlock.Unlock()
+	holder.Unlock()
+}
@@ -0,0 +1,290 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// NFSLeaseLock is a lock file for hosts sharing an NFS mount, where neither
+// flock nor O_EXCL can be trusted:
+//
+//   - it is created by linking a unique file to the lock name, and checking
+//     the link count of the unique file, as the reply of link may be lost;
+//   - the holder heartbeats by rewriting the file, so its mtime is set by
+//     the server clock;
+//   - others take it over when the mtime is older than TTL+AttrCache by the
+//     server clock (the mtime of a freshly created file), as their attribute
+//     cache may show an old mtime for up to AttrCache.
+type NFSLeaseLock struct {
+	// AttrCache is the attribute cache timeout of the mount (acregmax),
+	// defaults to 60s
+	AttrCache time.Duration
+
+	path string
+	ttl  ttlValue
+
+	mu    sync.Mutex
+	token string
+	lost  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewNFSLeaseLock returns a lock on the lock file path (unlocked first),
+// heartbeating in every third of ttl (raised to 1ms if shorter)
+func NewNFSLeaseLock(path string, ttl time.Duration) *NFSLeaseLock {
+	l := &NFSLeaseLock{path: path}
+	l.ttl.set(leaseTTL(ttl))
+	return l
+}
+
+// SetTTL changes the TTL of the following heartbeats and take-overs;
+// safe while held. All users of the lock should agree on the TTL.
+// It returns ErrBadTTL if ttl is not positive, and raises it to 1ms if shorter.
+func (l *NFSLeaseLock) SetTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrBadTTL
+	}
+	l.ttl.set(leaseTTL(ttl))
+	return nil
+}
+
+// Lock acquires the lock, blocking
+func (l *NFSLeaseLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext acquires the lock, until ctx is done
+func (l *NFSLeaseLock) LockContext(ctx context.Context) error {
+	eb := newExpBackoff(l.path)
+	defer beginWait(l.path)()
+	for {
+		ok, err := l.TryLock()
+		if ok && err == nil {
+			eb.Done()
+			return nil
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// TryLock acquires the lock, non-blocking, taking it over if its holder
+// stopped heartbeating
+func (l *NFSLeaseLock) TryLock() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stop != nil {
+		return false, errors.New("lock already held by this NFSLeaseLock")
+	}
+	host, _ := os.Hostname()
+	token := fmt.Sprintf("%s.%d.%d", host, os.Getpid(), time.Now().UnixNano())
+	for i := 0; i < 2; i++ {
+		ok, err := l.create(token)
+		if ok || err != nil {
+			return ok, err
+		}
+		if ok, err = l.takeOver(); !ok || err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Lost returns a channel which is closed when the heartbeat failed for longer
+// than the TTL, or the lock has been taken over. It is nil when not held.
+func (l *NFSLeaseLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Unlock stops the heartbeat and removes the lock file, if it is still ours.
+// It returns ErrLeaseLost if the lock has been lost meanwhile.
+func (l *NFSLeaseLock) Unlock() error {
+	l.mu.Lock()
+	if l.stop == nil {
+		l.mu.Unlock()
+		return nil
+	}
+	close(l.stop)
+	done := l.done
+	l.mu.Unlock()
+	<-done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	token, lost := l.token, l.lost
+	l.token, l.lost, l.stop, l.done = "", nil, nil, nil
+	select {
+	case <-lost:
+		return ErrLeaseLost
+	default:
+	}
+	// renamed aside first, so a new holder's file is never removed
+	aside := fmt.Sprintf("%s.own.%d.%d", l.path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(l.path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return ErrLeaseLost
+		}
+		return err
+	}
+	if b, err := ioutil.ReadFile(aside); err != nil || string(b) != token {
+		giveBack(aside, l.path)
+		return ErrLeaseLost
+	}
+	return os.Remove(aside)
+}
+
+func (l *NFSLeaseLock) create(token string) (bool, error) {
+	unique := l.path + "." + token
+	if err := ioutil.WriteFile(unique, []byte(token), 0644); err != nil {
+		return false, err
+	}
+	defer os.Remove(unique)
+	lerr := os.Link(unique, l.path)
+	fi, err := os.Stat(unique)
+	if err != nil {
+		return false, err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || st.Nlink != 2 {
+		if lerr != nil && !os.IsExist(lerr) {
+			return false, lerr
+		}
+		return false, nil
+	}
+	l.token = token
+	l.lost = make(chan struct{})
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.heartbeat(token, l.ttl.get(), l.lost, l.stop, l.done)
+	return true, nil
+}
+
+// takeOver removes the lock file if its heartbeat is older than TTL+AttrCache
+// by the server clock. The file is renamed aside first, and put back if it
+// is not the one found stale (unless a new one has been created meanwhile).
+func (l *NFSLeaseLock) takeOver() (bool, error) {
+	fi, err := os.Stat(l.path)
+	if err != nil {
+		if os.IsNotExist(err) { // released meanwhile
+			return true, nil
+		}
+		return false, err
+	}
+	now, err := serverNow(filepath.Dir(l.path))
+	if err != nil {
+		return false, err
+	}
+	ac := l.AttrCache
+	if ac <= 0 {
+		ac = time.Minute
+	}
+	if now.Sub(fi.ModTime()) < l.ttl.get()+ac {
+		return false, nil
+	}
+	aside := fmt.Sprintf("%s.stale.%d.%d", l.path, os.Getpid(), time.Now().UnixNano())
+	if err = os.Rename(l.path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if afi, err := os.Stat(aside); err != nil || !os.SameFile(fi, afi) || !afi.ModTime().Equal(fi.ModTime()) {
+		// heartbeat or a new holder meanwhile: give it back, unless a new
+		// holder has created the lock since
+		if err = giveBack(aside, l.path); os.IsExist(err) {
+			err = nil
+		}
+		return false, err
+	}
+	meta, _ := ioutil.ReadFile(aside)
+	recordCrash(CrashRecord{Lock: l.path, Kind: "nfslease", Reason: "lapsed", Holder: tokenOwner(string(meta), "."),
+		LastHeartbeat: fi.ModTime(), Metadata: string(meta)})
+	debugf(1, "lock %s: took over the heartbeat of %s", l.path, fi.ModTime())
+	return true, os.Remove(aside)
+}
+
+// heartbeat rewrites the lock file in every third of the TTL, until stop is closed
+func (l *NFSLeaseLock) heartbeat(token string, ttl time.Duration, lost, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		b, err := ioutil.ReadFile(l.path)
+		if err == nil && string(b) != token {
+			debugf(1, "lock %s: taken over", l.path)
+			close(lost) // taken over
+			return
+		}
+		if t := l.ttl.get(); t != ttl {
+			ttl = t
+			ticker.Reset(ttl / 3)
+		}
+		if err == nil {
+			err = touch(l.path, token)
+		}
+		if err == nil {
+			last = time.Now()
+		} else if time.Since(last) > ttl {
+			debugf(1, "lock %s: lost: %v", l.path, err)
+			close(lost)
+			return
+		}
+	}
+}
+
+// touch rewrites the content, so the server sets the mtime
+func touch(path, content string) error {
+	fh, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = fh.WriteAt([]byte(content), 0); err == nil {
+		err = fh.Sync()
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// serverNow returns the current time of the file server of dir:
+// the mtime of a freshly written file
+func serverNow(dir string) (time.Time, error) {
+	fh, err := ioutil.TempFile(dir, ".clock.")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer os.Remove(fh.Name())
+	defer fh.Close()
+	if _, err = fh.Write([]byte{0}); err != nil {
+		return time.Time{}, err
+	}
+	fi, err := fh.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
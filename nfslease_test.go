@@ -0,0 +1,76 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestNFSLeaseLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+
+	const ttl = 30 * time.Millisecond
+	a, b := locking.NewNFSLeaseLock(path, ttl), locking.NewNFSLeaseLock(path, ttl)
+	a.AttrCache, b.AttrCache = ttl, ttl
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(4 * ttl) // heartbeats keep it fresh
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("live lock taken over: %t, %v", ok, err)
+	}
+	if err = a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a holder which stopped heartbeating
+	if err = ioutil.WriteFile(path, []byte("dead"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("stale lock not taken over: %t, %v", ok, err)
+	}
+	if err = b.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock of a new holder is left in place
+	d := locking.NewNFSLeaseLock(path, time.Hour)
+	if err = d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, []byte("new holder"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Unlock(); err != locking.ErrLeaseLost {
+		t.Errorf("Unlock of a lock taken over: %v", err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "new holder" {
+		t.Fatalf("the new holder's lock: %q, %v", b, err)
+	}
+	os.Remove(path)
+
+	// a TTL too short for the heartbeat ticker is raised
+	c := locking.NewNFSLeaseLock(path, time.Nanosecond)
+	if err = c.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	c.Unlock()
+}
@@ -0,0 +1,207 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Notification tells that a lock has been released
+type Notification struct {
+	ID       string // unique, for deduplication by the receiver
+	Lock     string // the name given to Wrap
+	Released time.Time
+	Holder   Owner // the releasing process
+}
+
+// Notifier delivers a Notification of each release of the wrapped locks:
+// Unlock writes it durably into the Outbox directory while still holding
+// the lock, and Run (or Flush) sends the pending ones in release order,
+// removing each after Send succeeded. So no release is lost, even if the
+// process crashes; if it crashes between Send and the removal, the next run
+// sends it again with the same ID - the receiver must drop duplicates to get
+// exactly once.
+//
+// Send can POST to a webhook (WebhookSender), or publish to NATS, Redis ...
+type Notifier struct {
+	Outbox string
+	Send   func(Notification) error
+
+	once sync.Once
+	kick chan struct{} // a release since the last flush
+}
+
+var notificationSeq uint64
+
+// Wrap returns lock, notifying of its releases under name
+func (n *Notifier) Wrap(name string, lock Locker) TryLocker {
+	return notifyLock{Locker: lock, name: name, notifier: n}
+}
+
+// Flush sends the pending notifications in order, and stops at the first
+// failure. Concurrent flushes of the outbox (from other processes, too)
+// wait for each other.
+func (n *Notifier) Flush() error {
+	if err := os.MkdirAll(n.Outbox, 0755); err != nil {
+		return err
+	}
+	fh, err := os.OpenFile(filepath.Join(n.Outbox, ".flush"), os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(fh.Fd()), syscall.LOCK_UN)
+	names, err := filepath.Glob(filepath.Join(n.Outbox, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		var note Notification
+		if err = json.Unmarshal(b, &note); err != nil {
+			return &os.PathError{Op: "parse", Path: name, Err: err}
+		}
+		if err = n.Send(note); err != nil {
+			return err
+		}
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run flushes the outbox after each release, and in every interval (to retry
+// failures; 10s if not positive), until ctx is done. Errors are passed to
+// onError, if not nil.
+func (n *Notifier) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := n.Flush(); err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.kicks():
+		}
+	}
+}
+
+func (n *Notifier) kicks() chan struct{} {
+	n.once.Do(func() { n.kick = make(chan struct{}, 1) })
+	return n.kick
+}
+
+// enqueue writes the notification of the release of name into the outbox
+func (n *Notifier) enqueue(name string) error {
+	if err := os.MkdirAll(n.Outbox, 0755); err != nil {
+		return err
+	}
+	now, owner := time.Now(), currentOwner()
+	seq := atomic.AddUint64(&notificationSeq, 1)
+	note := Notification{
+		ID:       fmt.Sprintf("%s-%d-%d-%d", owner.Host, owner.PID, now.UnixNano(), seq),
+		Lock:     name,
+		Released: now,
+		Holder:   owner,
+	}
+	b, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	return writeDurable(filepath.Join(n.Outbox, fmt.Sprintf("%020d-%d-%d.json", now.UnixNano(), owner.PID, seq)), string(b))
+}
+
+type notifyLock struct {
+	Locker
+	name     string
+	notifier *Notifier
+}
+
+func (l notifyLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	return tl.TryLock()
+}
+
+// Unlock queues the notification, then releases the lock. The lock is
+// released even if the notification cannot be queued (then it is lost,
+// and the error returned).
+func (l notifyLock) Unlock() error {
+	if err := l.notifier.enqueue(l.name); err != nil {
+		if uerr := l.Locker.Unlock(); uerr != nil {
+			return fmt.Errorf("release notification not queued: %w (unlock: %v)", err, uerr)
+		}
+		return fmt.Errorf("release notification not queued: %w", err)
+	}
+	if err := l.Locker.Unlock(); err != nil {
+		return err
+	}
+	select {
+	case l.notifier.kicks() <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// WebhookSender returns a Send which POSTs the notification as JSON to url,
+// with its ID in the Idempotency-Key header; any non-2xx status is a failure
+func WebhookSender(url string, client *http.Client) func(Notification) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return func(note Notification) error {
+		b, err := json.Marshal(note)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", note.ID)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook %s: %s", url, resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,127 @@
+package locking_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var got []locking.Notification
+	fail := true
+	n := &locking.Notifier{Outbox: filepath.Join(dir, "outbox"), Send: func(note locking.Notification) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return errors.New("down")
+		}
+		got = append(got, note)
+		return nil
+	}}
+	lock := n.Wrap("shard-1", make(chanLock, 1))
+	for i := 0; i < 6; i++ {
+		lock.Lock()
+		if err = lock.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// kept while the receiver is down
+	if err = n.Flush(); err == nil {
+		t.Fatal("Flush succeeded with a failing Send")
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	if err = n.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 6 {
+		t.Fatalf("got %d notifications, wanted 6", len(got))
+	}
+	seen := make(map[string]bool)
+	for i, note := range got {
+		if note.Lock != "shard-1" || note.Holder.PID != os.Getpid() || seen[note.ID] {
+			t.Errorf("%d. %+v", i, note)
+		}
+		if i > 0 && note.Released.Before(got[i-1].Released) {
+			t.Errorf("%d. out of order", i)
+		}
+		seen[note.ID] = true
+	}
+	if err = n.Flush(); err != nil || len(got) != 6 {
+		t.Fatalf("delivered again: %d, %v", len(got), err)
+	}
+
+	// an outbox which cannot be written does not keep the lock held
+	blocked := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chanLock, 1)
+	lock = (&locking.Notifier{Outbox: filepath.Join(blocked, "outbox")}).Wrap("shard-2", ch)
+	lock.Lock()
+	if err = lock.Unlock(); err == nil {
+		t.Error("Unlock succeeded without queueing the notification")
+	}
+	if len(ch) != 0 {
+		t.Error("lock still held after a failed notification")
+	}
+}
+
+func TestWebhookSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	received := make(chan locking.Notification, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		var note locking.Notification
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil || r.Header.Get("Idempotency-Key") != note.ID {
+			http.Error(w, "bad notification", http.StatusBadRequest)
+			return
+		}
+		received <- note
+	}))
+	defer srv.Close()
+
+	n := &locking.Notifier{Outbox: dir, Send: locking.WebhookSender(srv.URL, nil)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx, time.Hour, func(err error) { t.Error(err) })
+	lock := n.Wrap("res", make(chanLock, 1))
+	lock.Lock()
+	lock.Unlock()
+	select {
+	case note := <-received:
+		if note.Lock != "res" {
+			t.Errorf("got %+v", note)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification after the release")
+	}
+	if err = locking.WebhookSender(srv.URL+"/fail", nil)(locking.Notification{ID: "a"}); err == nil {
+		t.Error("no error for a 503 response")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Observer receives the events of the locks wrapped with Observe.
+// The methods are called synchronously, so they must be quick.
+type Observer interface {
+	// OnAcquireAttempt is called at the start of each Lock, LockContext and TryLock
+	OnAcquireAttempt(name string)
+	// OnContended is called when the lock is held by somebody else:
+	// a TryLock fails, or a Lock has to wait
+	OnContended(name string)
+	// OnAcquired is called after a successful acquisition, waited after the attempt
+	OnAcquired(name string, waited time.Duration)
+	// OnReleased is called after a successful Unlock, held after the acquisition
+	OnReleased(name string, held time.Duration)
+}
+
+// Observe returns lock, reporting its events under name to o.
+//
+// A blocking Lock first tries a TryLock (if lock is a TryLocker) to tell
+// contention, then blocks as lock does.
+func Observe(name string, lock Locker, o Observer) TryLocker {
+	return &observedLock{Locker: lock, name: name, o: o}
+}
+
+type observedLock struct {
+	Locker
+	name string
+	o    Observer
+
+	mu    sync.Mutex
+	since time.Time
+}
+
+func (l *observedLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+func (l *observedLock) LockContext(ctx context.Context) error {
+	start := time.Now()
+	l.o.OnAcquireAttempt(l.name)
+	if tl, ok := l.Locker.(TryLocker); ok {
+		if ok, err := tl.TryLock(); err != nil {
+			return err
+		} else if ok {
+			l.acquired(start)
+			return nil
+		}
+		l.o.OnContended(l.name)
+	}
+	if err := LockContext(ctx, l.Locker); err != nil {
+		return err
+	}
+	l.acquired(start)
+	return nil
+}
+
+func (l *observedLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	start := time.Now()
+	l.o.OnAcquireAttempt(l.name)
+	ok, err := tl.TryLock()
+	if ok && err == nil {
+		l.acquired(start)
+	} else if err == nil {
+		l.o.OnContended(l.name)
+	}
+	return ok, err
+}
+
+func (l *observedLock) acquired(start time.Time) {
+	now := time.Now()
+	l.mu.Lock()
+	l.since = now
+	l.mu.Unlock()
+	l.o.OnAcquired(l.name, now.Sub(start))
+}
+
+func (l *observedLock) Unlock() error {
+	err := l.Locker.Unlock()
+	if err == nil {
+		l.mu.Lock()
+		since := l.since
+		l.since = time.Time{}
+		l.mu.Unlock()
+		if !since.IsZero() {
+			l.o.OnReleased(l.name, time.Since(since))
+		}
+	}
+	return err
+}
+
+// Counters is an Observer counting the events per lock name.
+// It is an expvar.Var (its String is JSON), so it can be published with
+// expvar.Publish("locks", counters). The zero value is ready to use.
+type Counters struct {
+	mu     sync.Mutex
+	byName map[string]*LockCounters
+}
+
+// LockCounters are the counters of one lock
+type LockCounters struct {
+	Attempts  int64
+	Contended int64
+	Acquired  int64
+	Released  int64
+	Waited    time.Duration // total wait of the acquisitions
+	Held      time.Duration // total hold time of the releases
+}
+
+// ContentionRate returns the ratio of the contended attempts
+func (c LockCounters) ContentionRate() float64 {
+	if c.Attempts == 0 {
+		return 0
+	}
+	return float64(c.Contended) / float64(c.Attempts)
+}
+
+func (c *Counters) update(name string, fn func(*LockCounters)) {
+	c.mu.Lock()
+	if c.byName == nil {
+		c.byName = make(map[string]*LockCounters)
+	}
+	lc := c.byName[name]
+	if lc == nil {
+		lc = new(LockCounters)
+		c.byName[name] = lc
+	}
+	fn(lc)
+	c.mu.Unlock()
+}
+
+// OnAcquireAttempt implements Observer
+func (c *Counters) OnAcquireAttempt(name string) {
+	c.update(name, func(lc *LockCounters) { lc.Attempts++ })
+}
+
+// OnContended implements Observer
+func (c *Counters) OnContended(name string) {
+	c.update(name, func(lc *LockCounters) { lc.Contended++ })
+}
+
+// OnAcquired implements Observer
+func (c *Counters) OnAcquired(name string, waited time.Duration) {
+	c.update(name, func(lc *LockCounters) { lc.Acquired++; lc.Waited += waited })
+}
+
+// OnReleased implements Observer
+func (c *Counters) OnReleased(name string, held time.Duration) {
+	c.update(name, func(lc *LockCounters) { lc.Released++; lc.Held += held })
+}
+
+// Get returns the counters of name
+func (c *Counters) Get(name string) LockCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lc := c.byName[name]; lc != nil {
+		return *lc
+	}
+	return LockCounters{}
+}
+
+// Names returns the names observed, sorted
+func (c *Counters) Names() []string {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.byName))
+	for name := range c.byName {
+		names = append(names, name)
+	}
+	c.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// String returns the counters as a JSON object keyed by the lock names.
+// Durations are in nanoseconds.
+func (c *Counters) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byName == nil {
+		return "{}"
+	}
+	b, _ := json.Marshal(c.byName)
+	return string(b)
+}
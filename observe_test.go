@@ -0,0 +1,56 @@
+package locking_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestObserve(t *testing.T) {
+	var c locking.Counters
+	port := freePort(t)
+	lock := locking.Observe("port", locking.NewPortLock(port), &c)
+	if err := testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	other := locking.Observe("port", locking.NewPortLock(port), &c)
+	if err := lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := other.TryLock(); ok {
+		t.Fatal("port locked twice")
+	}
+	lock.Unlock()
+
+	got := c.Get("port")
+	if got.Attempts != 4 || got.Contended != 1 || got.Acquired != 3 || got.Released != 3 {
+		t.Errorf("counters=%+v", got)
+	}
+	if r := got.ContentionRate(); r != 0.25 {
+		t.Errorf("contention rate=%f", r)
+	}
+	var all map[string]locking.LockCounters
+	if err := json.Unmarshal([]byte(c.String()), &all); err != nil || all["port"] != got {
+		t.Errorf("String=%s, %v", c.String(), err)
+	}
+}
+
+func TestCountersConcurrent(t *testing.T) {
+	var c locking.Counters
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.OnAcquired("a", time.Millisecond)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_ = c.String()
+	}
+	<-done
+	if got := c.Get("a").Acquired; got != 100 {
+		t.Errorf("acquired %d, wanted 100", got)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MigrationLock runs a job (a schema migration, say) exactly once, across
+// processes and crashes: under Lock, it runs the job only if the durable
+// completion Marker is absent, and writes the marker before releasing.
+//
+// While the job runs, Marker+".started" exists: finding it means a previous
+// run crashed midway, and Interrupted decides what happens.
+type MigrationLock struct {
+	Lock   Locker
+	Marker string // path of the completion marker
+	// Interrupted is called instead of the job, with the start time of the
+	// crashed run. Returning nil runs the job again; nil Interrupted always reruns.
+	Interrupted func(started time.Time) error
+}
+
+// Done reports whether the job has completed (without locking)
+func (m MigrationLock) Done() (bool, error) {
+	_, err := os.Stat(m.Marker)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Run runs job unless it has completed already, and tells whether it ran.
+// When job fails, no marker is written, so the next Run retries.
+func (m MigrationLock) Run(job func() error) (ran bool, err error) {
+	if err = m.Lock.Lock(); err != nil {
+		return false, err
+	}
+	defer func() {
+		if uerr := m.Lock.Unlock(); uerr != nil && err == nil {
+			err = uerr
+		}
+	}()
+	if done, err := m.Done(); done || err != nil {
+		return false, err
+	}
+	started := m.Marker + ".started"
+	if b, err := ioutil.ReadFile(started); err == nil {
+		if m.Interrupted != nil {
+			t, _ := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+			if err = m.Interrupted(t); err != nil {
+				return false, err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	if err = writeDurable(started, time.Now().UTC().Format(time.RFC3339Nano)+"\n"); err != nil {
+		return false, err
+	}
+	if err = job(); err != nil {
+		os.Remove(started) // a clean failure, not a crash
+		return true, err
+	}
+	host, _ := os.Hostname()
+	if err = writeDurable(m.Marker, fmt.Sprintf("%s\n%s/%d\n",
+		time.Now().UTC().Format(time.RFC3339Nano), host, os.Getpid())); err != nil {
+		return true, err
+	}
+	return true, os.Remove(started)
+}
+
+// writeDurable writes content to path atomically, and syncs it to disk with
+// the directory entry
+func writeDurable(path, content string) error {
+	dir := filepath.Dir(path)
+	fh, err := ioutil.TempFile(dir, filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = fh.WriteString(content)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(fh.Name(), path)
+	}
+	if err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+	dh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dh.Close()
+	return dh.Sync()
+}
@@ -0,0 +1,53 @@
+package locking_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestMigrationLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(dir, "v2.done")
+
+	// a crashed run left its start behind
+	if err = ioutil.WriteFile(marker+".started", []byte(time.Now().Format(time.RFC3339Nano)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var interrupted bool
+	m := locking.MigrationLock{Lock: lock, Marker: marker,
+		Interrupted: func(time.Time) error { interrupted = true; return nil }}
+
+	failed := errors.New("failed")
+	if ran, err := m.Run(func() error { return failed }); !ran || err != failed {
+		t.Fatalf("got %t, %v", ran, err)
+	}
+	if !interrupted {
+		t.Error("crashed run not reported")
+	}
+	runs := 0
+	for i := 0; i < 2; i++ {
+		if _, err := m.Run(func() error { runs++; return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("ran %d times", runs)
+	}
+	if done, err := m.Done(); !done || err != nil {
+		t.Errorf("done=%t, %v", done, err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import "fmt"
+
+// LockOrder declares the intended acquisition order of named locks:
+// a lock may only be acquired while holding locks declared before it.
+// Names not declared are not checked.
+// Command lockorder checks the Chain.Lock calls against the order statically.
+type LockOrder struct {
+	rank map[string]int
+}
+
+// NewLockOrder returns the order of the given names, first to last
+func NewLockOrder(names ...string) *LockOrder {
+	o := &LockOrder{rank: make(map[string]int, len(names))}
+	for i, name := range names {
+		o.rank[name] = i
+	}
+	return o
+}
+
+// OrderError is returned when a lock is acquired out of the declared order
+type OrderError struct {
+	Held, Acquiring string
+}
+
+func (e *OrderError) Error() string {
+	return fmt.Sprintf("lock order violation: acquiring %q while holding %q", e.Acquiring, e.Held)
+}
+
+// Check returns an *OrderError if acquiring next while holding held violates the order
+func (o *LockOrder) Check(held []string, next string) error {
+	r, ok := o.rank[next]
+	if !ok {
+		return nil
+	}
+	for _, h := range held {
+		if hr, ok := o.rank[h]; ok && hr >= r {
+			return &OrderError{Held: h, Acquiring: next}
+		}
+	}
+	return nil
+}
+
+// Chain tracks the locks held by one call chain (goroutine),
+// checking every acquisition against the order.
+func (o *LockOrder) Chain() *Chain {
+	return &Chain{order: o}
+}
+
+// Chain is a set of locks acquired in a checked order.
+// It is not safe for concurrent use: use one Chain per goroutine.
+type Chain struct {
+	order *LockOrder
+	names []string
+	locks []Locker
+}
+
+// Lock acquires lock under name, returning an *OrderError when it would
+// violate the order (without trying to lock).
+func (c *Chain) Lock(name string, lock Locker) error {
+	if err := c.order.Check(c.names, name); err != nil {
+		return err
+	}
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	c.names = append(c.names, name)
+	c.locks = append(c.locks, lock)
+	return nil
+}
+
+// Unlock releases the latest lock acquired under name
+func (c *Chain) Unlock(name string) error {
+	for i := len(c.names) - 1; i >= 0; i-- {
+		if c.names[i] != name {
+			continue
+		}
+		lock := c.locks[i]
+		c.names = append(c.names[:i], c.names[i+1:]...)
+		c.locks = append(c.locks[:i], c.locks[i+1:]...)
+		return lock.Unlock()
+	}
+	return fmt.Errorf("lock %q is not held", name)
+}
+
+// Held returns the names of the held locks, in acquisition order
+func (c *Chain) Held() []string {
+	return append([]string(nil), c.names...)
+}
@@ -0,0 +1,35 @@
+package locking_test
+
+import (
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLockOrder(t *testing.T) {
+	order := locking.NewLockOrder("db", "cache")
+	c := order.Chain()
+	cache := locking.NewPortLock(freePort(t))
+	if err := c.Lock("cache", cache); err != nil {
+		t.Fatal(err)
+	}
+	db := locking.NewPortLock(freePort(t))
+	err := c.Lock("db", db)
+	if _, ok := err.(*locking.OrderError); !ok {
+		t.Fatalf("wanted OrderError, got %v", err)
+	}
+	if err = c.Unlock("cache"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Lock("db", db); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Lock("cache", cache); err != nil {
+		t.Fatal(err)
+	}
+	if held := c.Held(); len(held) != 2 {
+		t.Errorf("held=%v", held)
+	}
+	c.Unlock("cache")
+	c.Unlock("db")
+}
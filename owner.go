@@ -0,0 +1,141 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Owner is the holder of a DirLock, as recorded in its owner file
+type Owner struct {
+	PID   int
+	Host  string
+	Since time.Time
+	HLC   HLC // zero in records written before the HLC stamps
+}
+
+const ownerFile = "owner"
+
+func currentOwner() Owner {
+	host, _ := os.Hostname()
+	return Owner{PID: os.Getpid(), Host: host, Since: time.Now(), HLC: NowHLC()}
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("%d\n%s\n%s\n%s\n", o.PID, o.Host, o.Since.UTC().Format(time.RFC3339Nano), o.HLC)
+}
+
+func (o Owner) sameProcess(other Owner) bool {
+	return o.PID == other.PID && o.Host == other.Host
+}
+
+// Alive reports whether the owner process may still be alive:
+// false only if it ran on this host, and there is no such process now.
+func (o Owner) Alive() bool {
+	if !o.local() {
+		return true
+	}
+	return syscall.Kill(o.PID, 0) != syscall.ESRCH
+}
+
+// local reports whether the owner ran on this host, so Alive can check it
+func (o Owner) local() bool {
+	host, _ := os.Hostname()
+	return host == o.Host && o.PID > 0
+}
+
+func writeOwner(dir string) error {
+	return ioutil.WriteFile(filepath.Join(dir, ownerFile), []byte(currentOwner().String()), 0600)
+}
+
+func readOwner(dir string) (Owner, error) {
+	return readOwnerFile(filepath.Join(dir, ownerFile))
+}
+
+func readOwnerFile(path string) (Owner, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Owner{}, err
+	}
+	var o Owner
+	lines := strings.SplitN(string(b), "\n", 5)
+	if len(lines) < 3 {
+		return o, fmt.Errorf("%s: bad owner file %q", path, b)
+	}
+	if o.PID, err = strconv.Atoi(lines[0]); err != nil {
+		return o, err
+	}
+	o.Host = lines[1]
+	if o.Since, err = time.Parse(time.RFC3339Nano, lines[2]); err != nil {
+		return o, err
+	}
+	if len(lines) > 3 && lines[3] != "" {
+		if o.HLC, err = ParseHLC(lines[3]); err != nil {
+			return o, err
+		}
+		ObserveHLC(o.HLC)
+	}
+	return o, nil
+}
+
+// Owner returns the recorded holder of the lock
+func (lock DirLock) Owner() (Owner, error) {
+	return readOwner(string(lock))
+}
+
+// TryLockStale acquires the lock, non-blocking, breaking it first if it is
+// stale: its owner process on this host is dead, or (with a positive
+// maxAge) its owner on another host - whose process cannot be checked -
+// has held it longer than maxAge. A live local owner is never broken.
+//
+// A lock is broken by renaming it aside, and checking that the renamed one
+// is the same that was found stale - so a lock acquired meanwhile is put back.
+//
+// DirLocks are not renewed: maxAge breaks a healthy remote holder as well,
+// once it has held the lock that long. Use a LeaseLock for holds of unbounded
+// length which must expire when the holder dies or hangs.
+func (lock DirLock) TryLockStale(maxAge time.Duration) (bool, error) {
+	if ok, err := lock.TryLock(); ok || err != nil {
+		return ok, err
+	}
+	path := string(lock)
+	owner, err := readOwner(path)
+	if err != nil {
+		if os.IsNotExist(err) { // released meanwhile, or an ownerless lock
+			return lock.TryLock()
+		}
+		return false, err
+	}
+	if owner.Alive() && (owner.local() || maxAge <= 0 || time.Since(owner.Since) < maxAge) {
+		return false, nil
+	}
+	aside := fmt.Sprintf("%s.stale.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+	if err = os.Rename(path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return lock.TryLock()
+		}
+		return false, err
+	}
+	if broken, err := readOwner(aside); err != nil || broken != owner {
+		// not the one found stale: give it back
+		return false, os.Rename(aside, path)
+	}
+	reason := "dead"
+	if owner.Alive() {
+		reason = "aged"
+	}
+	meta, _ := ioutil.ReadFile(filepath.Join(aside, ownerFile))
+	recordCrash(CrashRecord{Lock: path, Kind: "dir", Reason: reason, Holder: owner, Metadata: string(meta)})
+	os.RemoveAll(aside)
+	debugf(1, "lock %s: broke the stale lock of %d@%s since %s", path, owner.PID, owner.Host, owner.Since)
+	return lock.TryLock()
+}
@@ -0,0 +1,72 @@
+package locking_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestDirLockStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if o, err := lock.Owner(); err != nil || o.PID != os.Getpid() || !o.Alive() {
+		t.Fatalf("owner=%+v, %v", o, err)
+	}
+	if ok, err := lock.TryLockStale(time.Hour); ok || err != nil {
+		t.Fatalf("live lock broken: %t, %v", ok, err)
+	}
+	if ok, err := lock.TryLockStale(time.Nanosecond); ok || err != nil {
+		t.Fatalf("live lock broken by age: %t, %v", ok, err)
+	}
+
+	// an old holder on another host
+	old := fmt.Sprintf("%d\n%s\n%s\n", 1, "elsewhere.invalid", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano))
+	if err = ioutil.WriteFile(filepath.Join(dir, ".lock", "owner"), []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Unlock(); err != locking.ErrNotOwner {
+		t.Fatalf("Unlock of another's lock: %v", err)
+	}
+	if ok, err := lock.TryLockStale(time.Hour); ok || err != nil {
+		t.Fatalf("young remote lock broken: %t, %v", ok, err)
+	}
+	if ok, err := lock.TryLockStale(time.Second); !ok || err != nil {
+		t.Fatalf("old remote lock not broken: %t, %v", ok, err)
+	}
+
+	// a dead holder
+	cmd := exec.Command("true")
+	if err = cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%d\n%s\n%s\n", cmd.Process.Pid, host, time.Now().UTC().Format(time.RFC3339Nano))
+	if err = ioutil.WriteFile(filepath.Join(dir, ".lock", "owner"), []byte(owner), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lock.TryLockStale(0); !ok || err != nil {
+		t.Fatalf("dead holder's lock not broken: %t, %v", ok, err)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
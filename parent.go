@@ -0,0 +1,115 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrParentExited is returned by the Unlock of a DieWithParent lock already
+// released at the exit of the parent process
+var ErrParentExited = errors.New("lock released at the exit of the parent process")
+
+// DieWithParent binds the holds of a lock to the life of the parent process,
+// for workers holding locks on behalf of their supervisor: when the parent
+// exits, the lock is released, so an orphaned worker does not keep it.
+//
+// The parent is watched with a pidfd on Linux, by polling getppid elsewhere.
+type DieWithParent struct {
+	// OnRelease, if set, is called after the release at the exit of the
+	// parent, with the error of the release
+	OnRelease func(err error)
+}
+
+// Wrap returns lock, released at the exit of the parent
+func (d DieWithParent) Wrap(lock Locker) TryLocker {
+	return &parentLock{Locker: lock, onRelease: d.OnRelease}
+}
+
+type parentLock struct {
+	Locker
+	onRelease func(error)
+
+	mu       sync.Mutex
+	stop     chan struct{} // of the watcher of the current hold
+	released bool          // at the exit of the parent
+}
+
+func (l *parentLock) Lock() error {
+	if err := l.Locker.Lock(); err != nil {
+		return err
+	}
+	l.watch()
+	return nil
+}
+
+func (l *parentLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	if ok, err := tl.TryLock(); !ok || err != nil {
+		return ok, err
+	}
+	l.watch()
+	return true, nil
+}
+
+// watch starts watching the parent for the new hold
+func (l *parentLock) watch() {
+	ppid, stop := os.Getppid(), make(chan struct{})
+	l.mu.Lock()
+	l.stop, l.released = stop, false
+	l.mu.Unlock()
+	go func() {
+		if !waitParent(ppid, stop) {
+			return
+		}
+		l.mu.Lock()
+		if l.stop != stop { // released meanwhile
+			l.mu.Unlock()
+			return
+		}
+		l.stop, l.released = nil, true
+		err := l.Locker.Unlock()
+		l.mu.Unlock()
+		debugf(1, "parent %d exited: lock released (%v)", ppid, err)
+		if l.onRelease != nil {
+			l.onRelease(err)
+		}
+	}()
+}
+
+func (l *parentLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		l.released = false
+		return ErrParentExited
+	}
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	return l.Locker.Unlock()
+}
+
+// pollParent reports whether the parent ppid has exited (this process has
+// been reparented), checking every 100ms until stop is closed
+func pollParent(ppid int, stop <-chan struct{}) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for os.Getppid() == ppid {
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
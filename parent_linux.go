@@ -0,0 +1,59 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"os"
+	"syscall"
+)
+
+// the same on all architectures
+const sysPidfdOpen = 434
+
+// waitParent reports whether the parent ppid has exited, before stop is
+// closed: it waits for the pidfd of the parent to become readable, and
+// polls on kernels without pidfd (before 5.3).
+func waitParent(ppid int, stop <-chan struct{}) bool {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(ppid), 0, 0)
+	if errno != 0 {
+		return pollParent(ppid, stop)
+	}
+	if err := syscall.SetNonblock(int(fd), true); err != nil {
+		syscall.Close(int(fd))
+		return pollParent(ppid, stop)
+	}
+	fh := os.NewFile(fd, "pidfd")
+	if os.Getppid() != ppid { // exited before pidfd_open: the pid may be reused
+		fh.Close()
+		return true
+	}
+	rc, err := fh.SyscallConn()
+	if err != nil {
+		fh.Close()
+		return pollParent(ppid, stop)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		waited := false
+		exited <- rc.Read(func(uintptr) bool {
+			// called again when readable: at the exit
+			done := waited
+			waited = true
+			return done
+		})
+	}()
+	select {
+	case <-stop:
+		fh.Close() // ends the Read
+		<-exited
+		return false
+	case err = <-exited:
+		fh.Close()
+		if err != nil { // not pollable
+			return pollParent(ppid, stop)
+		}
+		return true
+	}
+}
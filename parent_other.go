@@ -0,0 +1,12 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package locking
+
+// waitParent reports whether the parent ppid has exited, before stop is closed
+func waitParent(ppid int, stop <-chan struct{}) bool {
+	return pollParent(ppid, stop)
+}
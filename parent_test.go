@@ -0,0 +1,84 @@
+package locking_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestDieWithParent(t *testing.T) {
+	if path := os.Getenv("LOCK_TEST_PARENT"); path != "" {
+		fl, _ := locking.NewFLock(path)
+		lock := locking.DieWithParent{OnRelease: func(err error) {
+			fmt.Printf("released %v\n", err)
+		}}.Wrap(fl)
+		if err := lock.Lock(); err != nil {
+			os.Exit(2)
+		}
+		fmt.Printf("locked %d\n", os.Getpid())
+		select {}
+	}
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+	lock, err := locking.NewFLockCreate(path, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(locking.DieWithParent{}.Wrap(lock)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the parent shell exits when its stdin is closed, leaving the worker orphaned
+	cmd := exec.Command("sh", "-c", `"$0" -test.run='^TestDieWithParent$' & read x`, os.Args[0])
+	cmd.Env = append(os.Environ(), "LOCK_TEST_PARENT="+path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(stdout)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "locked ")))
+	if err != nil {
+		t.Fatalf("worker said %q", line)
+	}
+	defer syscall.Kill(pid, syscall.SIGKILL)
+	if ok, _ := lock.TryLock(); ok {
+		t.Fatal("the worker does not hold the lock")
+	}
+	start := time.Now()
+	stdin.Close()
+	if line, err = br.ReadString('\n'); err != nil || line != "released <nil>\n" {
+		t.Fatalf("worker said %q, %v", line, err)
+	}
+	cmd.Wait()
+	t.Logf("released %s after the exit of the parent", time.Since(start))
+	// the worker lives on, without the lock
+	if ok, err := lock.TryLock(); !ok || err != nil {
+		t.Fatalf("lock not released: %t, %v", ok, err)
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,121 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PathPolicy maps path prefixes (mount points) to the lock backends
+// required under them, so the operators choose the backends where the
+// correctness depends on it, and the applications stay backend-agnostic:
+//
+//	{"rules": [{"prefix": "/nfs", "backend": "nfslease", "ttl": "30s"},
+//	 {"prefix": "/var/lock", "backend": "file", "strict": true}]}
+//
+// Set with SetPathPolicy, it is consulted by NewBestLock and Open: the
+// backend of the policy overrides the one of a file, dir, fcntl or ofd URI.
+type PathPolicy struct {
+	Rules []PathRule `json:"rules" yaml:"rules"`
+}
+
+// PathRule is a rule of a PathPolicy
+type PathRule struct {
+	Prefix string `json:"prefix" yaml:"prefix"`
+	// Backend is a backend of LockSpec, or "nfslease" for an NFSLeaseLock
+	Backend string   `json:"backend" yaml:"backend"`
+	TTL     Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	Strict  bool     `json:"strict,omitempty" yaml:"strict,omitempty"`
+}
+
+var pathPolicy atomic.Value // *PathPolicy
+
+// SetPathPolicy sets the policy of the following NewBestLock and Open calls,
+// nil for none (the default)
+func SetPathPolicy(p *PathPolicy) { pathPolicy.Store(p) }
+
+// LoadPathPolicy reads the JSON policy at path, and sets it
+func LoadPathPolicy(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var p PathPolicy
+	if err = json.Unmarshal(b, &p); err != nil {
+		return &os.PathError{Op: "parse", Path: path, Err: err}
+	}
+	SetPathPolicy(&p)
+	return nil
+}
+
+// Rule returns the rule of path: the one with the longest prefix containing it
+func (p *PathPolicy) Rule(path string) (PathRule, bool) {
+	var best PathRule
+	found := false
+	if p == nil {
+		return best, found
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	for _, r := range p.Rules {
+		prefix := filepath.Clean(r.Prefix)
+		if !(path == prefix || prefix == "/" || strings.HasPrefix(path, prefix+"/")) {
+			continue
+		}
+		if !found || len(prefix) > len(filepath.Clean(best.Prefix)) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// lock returns the lock of the rule at path
+func (r PathRule) lock(path string) (Locker, error) {
+	if r.Backend == "nfslease" {
+		ttl := time.Duration(r.TTL)
+		if ttl <= 0 {
+			ttl = 30 * time.Second
+		}
+		return NewNFSLeaseLock(path, ttl), nil
+	}
+	return LockSpec{Backend: r.Backend, Target: path, TTL: r.TTL, Strict: r.Strict}.Build()
+}
+
+func currentPathRule(path string) (PathRule, bool) {
+	p, _ := pathPolicy.Load().(*PathPolicy)
+	return p.Rule(path)
+}
+
+// NewBestLock returns the lock of the lock file path: the backend of the
+// PathPolicy, if it has a rule for path. Otherwise an FLock (creating the
+// file), or an NFSLeaseLock with a 30s TTL where Strict finds flock unsafe.
+func NewBestLock(path string) (Locker, error) {
+	if r, ok := currentPathRule(path); ok {
+		return r.lock(path)
+	}
+	lock, err := NewFLockCreate(path, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = Strict(lock); err != nil {
+		if _, unsafe := err.(*UnsafeError); !unsafe {
+			lock.Close()
+			return nil, err
+		}
+		lock.Close()
+		if fi, err := os.Stat(path); err == nil && fi.Size() == 0 {
+			os.Remove(path) // would be read as a bad lease
+		}
+		return NewNFSLeaseLock(path, 30*time.Second), nil
+	}
+	return lock, nil
+}
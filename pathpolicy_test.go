@@ -0,0 +1,62 @@
+package locking_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestPathPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"nfs", "nfs/local", "other"} {
+		if err = os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy := filepath.Join(dir, "policy.json")
+	if err = ioutil.WriteFile(policy, []byte(`{"rules": [
+		{"prefix": "`+dir+`/nfs", "backend": "nfslease", "ttl": "30s"},
+		{"prefix": "`+dir+`/nfs/local/", "backend": "mem"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = locking.LoadPathPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	defer locking.SetPathPolicy(nil)
+
+	for path, want := range map[string]string{
+		filepath.Join(dir, "nfs", "a"):          "*locking.NFSLeaseLock",
+		filepath.Join(dir, "nfs", "local", "a"): "*locking.MemLock",
+		filepath.Join(dir, "nfsx"):              "*locking.FLock",
+		filepath.Join(dir, "other", "a"):        "*locking.FLock",
+	} {
+		lock, err := locking.NewBestLock(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if got := typeName(lock); got != want {
+			t.Errorf("%s: got %s, wanted %s", path, got, want)
+		}
+		if err = testLock(lock); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+	// the policy overrides the scheme
+	lock, err := locking.Open("file:" + filepath.Join(dir, "nfs", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := typeName(lock); got != "*locking.NFSLeaseLock" {
+		t.Errorf("Open: got %s", got)
+	}
+}
+
+func typeName(v interface{}) string { return fmt.Sprintf("%T", v) }
@@ -0,0 +1,120 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// PIDFileLock is a daemon pidfile: while held, the file contains the PID of
+// the holder and is flocked by it. A pidfile whose recorded process is
+// gone is reclaimed; one whose process runs is refused even without the
+// flock (written by another tool).
+type PIDFileLock struct {
+	path string
+	fh   *os.File
+}
+
+// NewPIDFileLock returns a lock on the pidfile at path (unlocked first)
+func NewPIDFileLock(path string) *PIDFileLock {
+	return &PIDFileLock{path: path}
+}
+
+// Holder returns the recorded PID, and whether that process is running.
+// pid is 0 if there is no (valid) pidfile.
+func (lock *PIDFileLock) Holder() (pid int, alive bool) {
+	b, err := ioutil.ReadFile(lock.path)
+	if err != nil {
+		return 0, false
+	}
+	if pid, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil || pid <= 0 {
+		return 0, false
+	}
+	return pid, syscall.Kill(pid, 0) != syscall.ESRCH
+}
+
+// Lock acquires the lock, blocking
+func (lock *PIDFileLock) Lock() error {
+	return lock.LockContext(context.Background())
+}
+
+// LockContext acquires the lock, until ctx is done
+func (lock *PIDFileLock) LockContext(ctx context.Context) error {
+	eb := newExpBackoff(lock.path)
+	defer beginWait(lock.path)()
+	for {
+		ok, err := lock.TryLock()
+		if ok {
+			eb.Done()
+			return err
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// TryLock acquires the lock, non-blocking
+func (lock *PIDFileLock) TryLock() (bool, error) {
+	if lock.fh != nil {
+		return true, nil
+	}
+	fh, err := os.OpenFile(lock.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	if err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		fh.Close()
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+	// the previous holder may have removed the file while we waited
+	if fi, err := fh.Stat(); err != nil {
+		fh.Close()
+		return false, err
+	} else if pfi, err := os.Stat(lock.path); err != nil || !os.SameFile(fi, pfi) {
+		fh.Close()
+		return false, nil
+	}
+	if pid, alive := lock.Holder(); alive && pid != os.Getpid() {
+		fh.Close()
+		return false, nil
+	}
+	if err = fh.Truncate(0); err == nil {
+		_, err = fh.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err == nil {
+		err = fh.Sync()
+	}
+	if err != nil {
+		fh.Close()
+		return false, err
+	}
+	lock.fh = fh
+	return true, nil
+}
+
+// Unlock removes the pidfile and releases the lock
+func (lock *PIDFileLock) Unlock() error {
+	if lock.fh == nil {
+		return nil
+	}
+	err := os.Remove(lock.path)
+	if cerr := lock.fh.Close(); err == nil {
+		err = cerr
+	}
+	lock.fh = nil
+	return err
+}
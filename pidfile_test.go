@@ -0,0 +1,49 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestPIDFileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "daemon.pid")
+
+	a, b := locking.NewPIDFileLock(path), locking.NewPIDFileLock(path)
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if pid, alive := b.Holder(); pid != os.Getpid() || !alive {
+		t.Errorf("holder=%d alive=%t", pid, alive)
+	}
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("b: %t, %v", ok, err)
+	}
+	a.Unlock()
+
+	// left behind by a dead process
+	cmd := exec.Command("true")
+	if err = cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	if err = ioutil.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("dead holder's pidfile not reclaimed: %t, %v", ok, err)
+	}
+	b.Unlock()
+}
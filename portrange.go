@@ -0,0 +1,72 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// NewPortLockRange returns a lock on the first free port of [lo, hi], tried
+// in order, or in random order if shuffle is true (to spread concurrent
+// users). Port tells which one is bound.
+func NewPortLockRange(lo, hi int, shuffle bool) (*PortLock, error) {
+	if lo < 1 || hi > 65535 || lo > hi {
+		return nil, errors.New("bad port range " + strconv.Itoa(lo) + "-" + strconv.Itoa(hi))
+	}
+	ports := make([]int, 0, hi-lo+1)
+	for port := lo; port <= hi; port++ {
+		ports = append(ports, port)
+	}
+	if shuffle {
+		rand.Shuffle(len(ports), func(i, j int) { ports[i], ports[j] = ports[j], ports[i] })
+	}
+	return &PortLock{hostport: "127.0.0.1:" + strconv.Itoa(lo) + "-" + strconv.Itoa(hi), ports: ports}, nil
+}
+
+// NewEphemeralPortLock returns a PortLock binding a port chosen by the OS:
+// it never contends, but reserves a free port for the holder (see Port).
+func NewEphemeralPortLock() *PortLock {
+	return &PortLock{hostport: loopback(0)}
+}
+
+// Addr returns the bound address, nil if not held
+func (p *PortLock) Addr() net.Addr {
+	if p.ln == nil {
+		return nil
+	}
+	return p.ln.Addr()
+}
+
+// Port returns the bound port, 0 if not held
+func (p *PortLock) Port() int {
+	if a, ok := p.Addr().(*net.TCPAddr); ok {
+		return a.Port
+	}
+	return 0
+}
+
+func (p *PortLock) tryRange() (bool, error) {
+	for _, port := range p.ports {
+		l, err := net.Listen("tcp", loopback(port))
+		if err == nil {
+			p.ln = l
+			return true, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (p *PortLock) ephemeral() bool { return p.hostport == loopback(0) }
+
+func loopback(port int) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
@@ -0,0 +1,65 @@
+package locking_test
+
+import (
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestPortLockRange(t *testing.T) {
+	lo := freePort(t)
+	if lo > 65000 {
+		t.Skip("no room for a range above", lo)
+	}
+	if _, err := locking.NewPortLockRange(lo, lo-1, false); err == nil {
+		t.Error("empty range accepted")
+	}
+	a, err := locking.NewPortLockRange(lo, lo+1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Unlock()
+	if a.Port() != lo {
+		t.Errorf("bound %d, wanted %d", a.Port(), lo)
+	}
+	b, _ := locking.NewPortLockRange(lo, lo+1, true)
+	if ok, err := b.TryLock(); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		defer b.Unlock()
+		if b.Port() != lo+1 {
+			t.Errorf("second holder bound %d, wanted %d", b.Port(), lo+1)
+		}
+		if info, _ := a.Info(); !info.Locked {
+			t.Error("full range reported free")
+		}
+	}
+}
+
+func TestEphemeralPortLock(t *testing.T) {
+	lock := locking.NewEphemeralPortLock()
+	if lock.Addr() != nil || lock.Port() != 0 {
+		t.Error("address before Lock")
+	}
+	if err := lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	port := lock.Port()
+	if port == 0 {
+		t.Fatal("no port bound")
+	}
+	// the reserved port is locked for a PortLock on it
+	if ok, _ := locking.NewPortLock(port).TryLock(); ok {
+		t.Error("reserved port bound again")
+	}
+	if info, _ := lock.Info(); !info.Locked {
+		t.Error("held ephemeral port reported free")
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,252 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TryLocker is a Locker which can also be acquired without blocking
+type TryLocker interface {
+	Locker
+	TryLock() (bool, error)
+}
+
+// ErrNoTryLock is returned when TryLock is called on a wrapped lock which does not support it
+var ErrNoTryLock = errors.New("TryLock not supported")
+
+// Event is one recorded lock operation
+type Event struct {
+	Time time.Duration `json:"t"`    // since the start of the recording
+	Lock string        `json:"lock"` // name of the lock handle
+	Op   string        `json:"op"`   // "wait", "locked", "trylock", "unlock"
+	OK   bool          `json:"ok,omitempty"`
+	Err  string        `json:"err,omitempty"`
+}
+
+// Recorder records the operations of wrapped locks as JSON lines,
+// to be replayed later by Replay.
+type Recorder struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+	err   error
+}
+
+// NewRecorder returns a Recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w), start: time.Now()}
+}
+
+// Err returns the first write error
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(name, op string, ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ev := Event{Time: time.Since(r.start), Lock: name, Op: op, OK: ok}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	if werr := r.enc.Encode(ev); werr != nil && r.err == nil {
+		r.err = werr
+	}
+}
+
+// Wrap returns a TryLocker recording the operations of lock under name.
+// Each handle (each holder) should get its own name.
+func (r *Recorder) Wrap(name string, lock Locker) TryLocker {
+	return recordedLock{Locker: lock, name: name, rec: r}
+}
+
+type recordedLock struct {
+	Locker
+	name string
+	rec  *Recorder
+}
+
+func (l recordedLock) Lock() error {
+	l.rec.record(l.name, "wait", false, nil)
+	err := l.Locker.Lock()
+	l.rec.record(l.name, "locked", err == nil, err)
+	return err
+}
+
+func (l recordedLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	ok, err := tl.TryLock()
+	l.rec.record(l.name, "trylock", ok, err)
+	return ok, err
+}
+
+func (l recordedLock) Unlock() error {
+	err := l.Locker.Unlock()
+	l.rec.record(l.name, "unlock", err == nil, err)
+	return err
+}
+
+// ReadEvents reads the events written by a Recorder
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	dec := json.NewDecoder(r)
+	for {
+		var ev Event
+		if err := dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				return events, nil
+			}
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
+
+// FakeClock is a manually advanced clock for replays
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set the fake time, firing the timers due
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.set(t)
+	c.mu.Unlock()
+}
+
+// Advance the fake time by d, firing the timers due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.set(c.now.Add(d))
+	c.mu.Unlock()
+}
+
+// set sets the time and fires the timers due, with c.mu held
+func (c *FakeClock) set(t time.Time) {
+	c.now = t
+	timers := c.timers[:0]
+	for _, tm := range c.timers {
+		if tm.at.After(t) {
+			timers = append(timers, tm)
+		} else {
+			tm.c <- t
+		}
+	}
+	c.timers = timers
+}
+
+// Sleep advances the fake time by d at once: the sleeps of Replay
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// After returns a channel receiving the fake time when it is advanced
+// by at least d, as time.After
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tm := fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		tm.c <- c.now
+	} else {
+		c.timers = append(c.timers, tm)
+	}
+	return tm.c
+}
+
+// ReplayError is returned by Replay when the replayed outcome differs from the recorded one
+type ReplayError struct {
+	Index int
+	Event Event
+	Got   bool
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("event %d (%s %s at %s): recorded ok=%t, replayed ok=%t",
+		e.Index, e.Event.Lock, e.Event.Op, e.Event.Time, e.Event.OK, e.Got)
+}
+
+// Replay executes the recorded events one by one, in the recorded order,
+// on the lockers (keyed by the recorded names), sleeping the recorded time
+// before each: with clock.Sleep, so the fake time is the recorded one at
+// each event and the timers of clock fire in order, or in real time with a
+// nil clock.
+//
+// An acquisition recorded as successful is replayed with TryLock (when
+// available), so the replay never blocks; a differing outcome is
+// returned as a *ReplayError.
+func Replay(events []Event, lockers map[string]Locker, clock *FakeClock) error {
+	sleep := time.Sleep
+	if clock != nil {
+		sleep = clock.Sleep
+	}
+	var at time.Duration
+	for i, ev := range events {
+		if d := ev.Time - at; d > 0 {
+			sleep(d)
+			at = ev.Time
+		}
+		if ev.Op == "wait" {
+			continue
+		}
+		lock, ok := lockers[ev.Lock]
+		if !ok {
+			return fmt.Errorf("event %d: no locker for %q", i, ev.Lock)
+		}
+		var got bool
+		switch ev.Op {
+		case "locked", "trylock":
+			if !ev.OK && ev.Op == "locked" {
+				continue // a failed Lock has no effect
+			}
+			if tl, isTry := lock.(TryLocker); isTry {
+				var err error
+				if got, err = tl.TryLock(); err != nil {
+					return err
+				}
+			} else {
+				got = lock.Lock() == nil
+			}
+		case "unlock":
+			got = lock.Unlock() == nil
+		default:
+			return fmt.Errorf("event %d: unknown op %q", i, ev.Op)
+		}
+		if got != ev.OK {
+			return &ReplayError{Index: i, Event: ev, Got: got}
+		}
+	}
+	return nil
+}
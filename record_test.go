@@ -0,0 +1,94 @@
+package locking_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec := locking.NewRecorder(&buf)
+	port := freePort(t)
+	a := rec.Wrap("a", locking.NewPortLock(port))
+	b := rec.Wrap("b", locking.NewPortLock(port))
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := b.TryLock(); ok {
+		t.Fatal("b should not get the lock")
+	}
+	a.Unlock()
+	if ok, _ := b.TryLock(); !ok {
+		t.Fatal("b should get the lock")
+	}
+	b.Unlock()
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := locking.ReadEvents(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("got %d events, wanted 6: %v", len(events), events)
+	}
+	start := time.Unix(0, 0)
+	clock := locking.NewFakeClock(start)
+	at := make(map[string][]time.Duration)
+	lockers := map[string]locking.Locker{
+		"a": clockedLock{TryLocker: locking.NewPortLock(port), name: "a", clock: clock, start: start, at: at},
+		"b": clockedLock{TryLocker: locking.NewPortLock(port), name: "b", clock: clock, start: start, at: at},
+	}
+	timer := clock.After(events[2].Time)
+	if err := locking.Replay(events, lockers, clock); err != nil {
+		t.Fatal(err)
+	}
+	if got := clock.Now().Sub(start); got != events[len(events)-1].Time {
+		t.Errorf("clock at %s, wanted %s", got, events[len(events)-1].Time)
+	}
+	for _, ev := range events {
+		if ev.Op == "wait" {
+			continue
+		}
+		if got := at[ev.Lock][0]; got != ev.Time {
+			t.Errorf("%s %s replayed at %s, recorded at %s", ev.Lock, ev.Op, got, ev.Time)
+		}
+		at[ev.Lock] = at[ev.Lock][1:]
+	}
+	select {
+	case tm := <-timer:
+		if tm.Sub(start) < events[2].Time {
+			t.Errorf("timer fired at %s, before %s", tm.Sub(start), events[2].Time)
+		}
+	default:
+		t.Error("timer of the clock not fired by the replay")
+	}
+
+	// a replay with the lock held elsewhere diverges
+	other := locking.NewPortLock(port)
+	other.Lock()
+	defer other.Unlock()
+	err = locking.Replay(events, lockers, nil)
+	if _, ok := err.(*locking.ReplayError); !ok {
+		t.Errorf("wanted ReplayError, got %v", err)
+	}
+}
+
+// clockedLock notes the time of clock at each operation
+type clockedLock struct {
+	locking.TryLocker
+	name  string
+	clock *locking.FakeClock
+	start time.Time
+	at    map[string][]time.Duration
+}
+
+func (l clockedLock) note() { l.at[l.name] = append(l.at[l.name], l.clock.Now().Sub(l.start)) }
+
+func (l clockedLock) TryLock() (bool, error) { l.note(); return l.TryLocker.TryLock() }
+
+func (l clockedLock) Unlock() error { l.note(); return l.TryLocker.Unlock() }
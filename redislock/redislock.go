@@ -0,0 +1,303 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package redislock implements locks on a Redis server
+// (SET key token NX PX ttl), shared by the processes of several hosts.
+//
+// The lock expires after its TTL unless renewed, so a dead holder does not
+// block the others forever; a held Lock renews it in every third of the TTL.
+// It talks RESP itself, so it depends on the standard library only.
+//
+// Importing the package registers the redis scheme of locking.Open:
+// redis://[:password@]host[:port]/key[?ttl=30s].
+package redislock
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+var (
+	// ErrNotHeld is returned by Unlock when the key is not held by this Lock
+	ErrNotHeld = errors.New("lock not held")
+	// ErrLost is returned by Unlock when the lock could not be renewed in time
+	ErrLost = errors.New("lock expired before renewal")
+)
+
+const (
+	renewScript  = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+var _ locking.DistLocker = (*Lock)(nil)
+
+// Lock is a Redis lock.
+//
+// It keeps one connection to the server, opened by the first command and
+// reopened after a failed one: Close it when the Lock is not used any more.
+type Lock struct {
+	// DialTimeout bounds connecting and each command, defaults to 5s
+	DialTimeout time.Duration
+	// Password for AUTH, if needed
+	Password string
+
+	addr, key string
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	token string
+	lost  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+
+	connMu sync.Mutex // the renewals use the connection beside mu's holder
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+}
+
+// minTTL is the shortest TTL: PX counts milliseconds
+const minTTL = time.Millisecond
+
+// New returns an (unlocked) lock on key, at the Redis server at addr
+// (host:port), with the given TTL (raised to 1ms if shorter)
+func New(addr, key string, ttl time.Duration) *Lock {
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+	return &Lock{addr: addr, key: key, ttl: ttl}
+}
+
+func init() {
+	locking.RegisterScheme("redis", func(u *url.URL) (locking.Locker, error) {
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, errors.New("redis lock URI needs host and key: redis://host:port/key")
+		}
+		ttl := 30 * time.Second
+		if s := u.Query().Get("ttl"); s != "" {
+			var err error
+			if ttl, err = time.ParseDuration(s); err != nil {
+				return nil, err
+			}
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "6379")
+		}
+		l := New(addr, key, ttl)
+		if u.User != nil {
+			l.Password, _ = u.User.Password()
+		}
+		return l, nil
+	})
+}
+
+// Lock acquires the lock, blocking
+func (l *Lock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext acquires the lock, polling until ctx is done
+func (l *Lock) LockContext(ctx context.Context) error {
+	delay := 10 * time.Millisecond
+	for {
+		ok, err := l.tryLock(ctx)
+		if ok || err != nil && !locking.Retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// TryLock acquires the lock, non-blocking
+func (l *Lock) TryLock() (bool, error) {
+	return l.tryLock(context.Background())
+}
+
+func (l *Lock) tryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stop != nil {
+		return false, errors.New("lock already held by this Lock")
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return false, err
+	}
+	token := hex.EncodeToString(b[:])
+	// the server's expiry is counted from its receipt of SET: count ours
+	// from before sending it, so Lost is never late
+	expires := time.Now().Add(l.ttl)
+	reply, err := l.do(ctx, "SET", l.key, token, "NX", "PX", strconv.FormatInt(l.ttl.Milliseconds(), 10))
+	if err != nil || reply == nil {
+		return false, err
+	}
+	l.token = token
+	l.lost = make(chan struct{})
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.renew(token, expires, l.lost, l.stop, l.done)
+	return true, nil
+}
+
+// Lost returns a channel which is closed when the lock could not be renewed.
+// It is nil when not held.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Unlock releases the lock, if it is still held by this Lock.
+// It returns ErrLost if the lock has been lost meanwhile.
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	if l.stop == nil {
+		l.mu.Unlock()
+		return nil
+	}
+	close(l.stop)
+	done := l.done
+	l.mu.Unlock()
+	<-done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	token, lost := l.token, l.lost
+	l.token, l.lost, l.stop, l.done = "", nil, nil, nil
+	select {
+	case <-lost:
+		return ErrLost
+	default:
+	}
+	reply, err := l.do(context.Background(), "EVAL", unlockScript, "1", l.key, token)
+	if err == nil && reply != int64(1) {
+		err = ErrNotHeld
+	}
+	return err
+}
+
+// renew extends the expiry in every third of the TTL, until stop is closed
+func (l *Lock) renew(token string, expires time.Time, lost, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	ttl := strconv.FormatInt(l.ttl.Milliseconds(), 10)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		next := time.Now().Add(l.ttl)
+		reply, err := l.do(context.Background(), "EVAL", renewScript, "1", l.key, token, ttl)
+		if err == nil && reply != int64(1) { // expired, maybe taken by another
+			close(lost)
+			return
+		}
+		if err == nil {
+			expires = next
+		} else if time.Now().After(expires) {
+			close(lost)
+			return
+		}
+	}
+}
+
+// Close closes the connection to the server. It does not release the lock.
+func (l *Lock) Close() error {
+	l.connMu.Lock()
+	defer l.connMu.Unlock()
+	if l.conn == nil {
+		return nil
+	}
+	err := l.conn.Close()
+	l.conn = nil
+	return err
+}
+
+// do runs one command on the connection of the Lock, dialing it if needed,
+// until ctx is done or DialTimeout passes. The connection is closed after
+// an error, as its state is unknown then.
+func (l *Lock) do(ctx context.Context, args ...string) (interface{}, error) {
+	timeout := l.DialTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	l.connMu.Lock()
+	defer l.connMu.Unlock()
+	reply, err := l.command(ctx, deadline, args)
+	if err != nil {
+		if _, ok := err.(redisError); !ok && l.conn != nil {
+			l.conn.Close()
+			l.conn = nil
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}
+	return reply, err
+}
+
+func (l *Lock) command(ctx context.Context, deadline time.Time, args []string) (interface{}, error) {
+	if l.conn == nil {
+		d := net.Dialer{Deadline: deadline}
+		conn, err := d.DialContext(ctx, "tcp", l.addr)
+		if err != nil {
+			return nil, err
+		}
+		r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+		if l.Password != "" {
+			conn.SetDeadline(deadline)
+			if err = writeCommand(w, "AUTH", l.Password); err == nil {
+				_, err = readReply(r)
+			}
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		l.conn, l.r, l.w = conn, r, w
+	}
+	l.conn.SetDeadline(deadline)
+	if ctx.Done() != nil { // interrupt the exchange when ctx is done
+		stop := make(chan struct{})
+		defer close(stop)
+		conn := l.conn
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+	}
+	if err := writeCommand(l.w, args...); err != nil {
+		return nil, err
+	}
+	return readReply(l.r)
+}
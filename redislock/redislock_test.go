@@ -0,0 +1,205 @@
+package redislock_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+	"github.com/tgulacsi/go-locking/redislock"
+)
+
+func TestLock(t *testing.T) {
+	srv := newFakeRedis(t)
+	a := redislock.New(srv.addr, "k", time.Second)
+	b := redislock.New(srv.addr, "k", time.Second)
+	if ok, err := a.TryLock(); !ok || err != nil {
+		t.Fatalf("a: %t, %v", ok, err)
+	}
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("b: %t, %v", ok, err)
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLost(t *testing.T) {
+	srv := newFakeRedis(t)
+	a := redislock.New(srv.addr, "k", 30*time.Millisecond)
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // renewed meanwhile
+	select {
+	case <-a.Lost():
+		t.Fatal("renewed lock lost")
+	default:
+	}
+	srv.mu.Lock()
+	delete(srv.keys, "k") // evicted
+	srv.mu.Unlock()
+	select {
+	case <-a.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("loss not notified")
+	}
+	if err := a.Unlock(); err != redislock.ErrLost {
+		t.Errorf("wanted ErrLost, got %v", err)
+	}
+}
+
+func TestConnection(t *testing.T) {
+	srv := newFakeRedis(t)
+	a := redislock.New(srv.addr, "k", 30*time.Millisecond)
+	defer a.Close()
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // renewed meanwhile
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	conns := srv.conns
+	srv.stall = true // no replies from now on
+	srv.mu.Unlock()
+	if conns != 1 {
+		t.Errorf("%d connections, wanted 1", conns)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := a.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("LockContext of a stalled server: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("LockContext returned after %s", d)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	srv := newFakeRedis(t)
+	lock, err := locking.Open("redis://" + srv.addr + "/k?ttl=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := redislock.New(srv.addr, "k", time.Second).TryLock(); ok || err != nil {
+		t.Errorf("opened lock not held: %t, %v", ok, err)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locking.Open("redis://" + srv.addr); err == nil {
+		t.Error("URI without key accepted")
+	}
+}
+
+// fakeRedis understands just the commands of the lock
+type fakeRedis struct {
+	addr  string
+	mu    sync.Mutex
+	keys  map[string]fakeKey
+	conns int  // accepted
+	stall bool // do not reply
+}
+
+type fakeKey struct {
+	value   string
+	expires time.Time
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv := &fakeRedis{addr: ln.Addr().String(), keys: make(map[string]fakeKey)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.conns++
+			srv.mu.Unlock()
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (srv *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			r.ReadString('\n') // $len
+			arg, _ := r.ReadString('\n')
+			args[i] = strings.TrimSuffix(arg, "\r\n")
+		}
+		srv.mu.Lock()
+		stall := srv.stall
+		srv.mu.Unlock()
+		if !stall {
+			conn.Write([]byte(srv.do(args)))
+		}
+	}
+}
+
+func (srv *fakeRedis) do(args []string) string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	now := time.Now()
+	for k, v := range srv.keys {
+		if now.After(v.expires) {
+			delete(srv.keys, k)
+		}
+	}
+	switch args[0] {
+	case "SET": // key value NX PX ms
+		if _, ok := srv.keys[args[1]]; ok {
+			return "$-1\r\n"
+		}
+		ms, _ := strconv.Atoi(args[5])
+		srv.keys[args[1]] = fakeKey{value: args[2], expires: now.Add(time.Duration(ms) * time.Millisecond)}
+		return "+OK\r\n"
+	case "EVAL": // script 1 key token [ms]
+		k, ok := srv.keys[args[3]]
+		if !ok || k.value != args[4] {
+			return ":0\r\n"
+		}
+		if strings.Contains(args[1], "del") {
+			delete(srv.keys, args[3])
+		} else {
+			ms, _ := strconv.Atoi(args[5])
+			k.expires = now.Add(time.Duration(ms) * time.Millisecond)
+			srv.keys[args[3]] = k
+		}
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
@@ -0,0 +1,149 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// ReentrantLock lets the goroutine holding lock take it again: nested
+// Lock/Unlock pairs only count, the lock is released at the outermost
+// Unlock. Other goroutines of the process wait for the owner (flock alone
+// would let them in, as they share the descriptor), other processes for lock.
+//
+// The goroutine is identified by parsing runtime.Stack, some microseconds
+// per call. Where a context is passed along, WithLock is cheaper.
+type ReentrantLock struct {
+	lock Locker
+	sem  chan struct{} // held by the owner goroutine
+
+	mu    sync.Mutex
+	owner uint64
+	count int
+}
+
+// NewReentrantLock returns lock, reentrant for the owner goroutine
+func NewReentrantLock(lock Locker) *ReentrantLock {
+	return &ReentrantLock{lock: lock, sem: make(chan struct{}, 1)}
+}
+
+// Lock acquires the lock, or counts another hold of the owner
+func (l *ReentrantLock) Lock() error {
+	g := goid()
+	if l.reenter(g) {
+		return nil
+	}
+	l.sem <- struct{}{}
+	if err := l.lock.Lock(); err != nil {
+		<-l.sem
+		return err
+	}
+	l.own(g)
+	return nil
+}
+
+// LockContext acquires the lock until ctx is done (with the LockContext
+// function), or counts another hold of the owner
+func (l *ReentrantLock) LockContext(ctx context.Context) error {
+	g := goid()
+	if l.reenter(g) {
+		return nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := LockContext(ctx, l.lock); err != nil {
+		<-l.sem
+		return err
+	}
+	l.own(g)
+	return nil
+}
+
+// TryLock acquires the lock non-blocking, or counts another hold of the owner
+func (l *ReentrantLock) TryLock() (bool, error) {
+	g := goid()
+	if l.reenter(g) {
+		return true, nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		return false, nil
+	}
+	tl, ok := l.lock.(TryLocker)
+	if !ok {
+		<-l.sem
+		return false, ErrNoTryLock
+	}
+	if ok, err := tl.TryLock(); !ok || err != nil {
+		<-l.sem
+		return false, err
+	}
+	l.own(g)
+	return true, nil
+}
+
+// Unlock releases one hold; the last one releases the lock.
+// It returns ErrNotOwner if called by another goroutine.
+func (l *ReentrantLock) Unlock() error {
+	g := goid()
+	l.mu.Lock()
+	if l.count == 0 || l.owner != g {
+		l.mu.Unlock()
+		return ErrNotOwner
+	}
+	if l.count--; l.count > 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.owner = 0
+	l.mu.Unlock()
+	err := l.lock.Unlock()
+	<-l.sem
+	return err
+}
+
+// Count returns the number of holds of the owner, 0 if not held
+func (l *ReentrantLock) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+func (l *ReentrantLock) reenter(g uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count > 0 && l.owner == g {
+		l.count++
+		return true
+	}
+	return false
+}
+
+func (l *ReentrantLock) own(g uint64) {
+	l.mu.Lock()
+	l.owner, l.count = g, 1
+	l.mu.Unlock()
+}
+
+// goid returns the ID of the calling goroutine, from the
+// "goroutine 123 [running]:" header of its stack trace
+func goid() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
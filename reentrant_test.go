@@ -0,0 +1,77 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestReentrantLock(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+	flock, err := locking.NewFLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := locking.NewReentrantLock(flock)
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal("nested Lock:", err)
+	}
+	if ok, err := lock.TryLock(); !ok || err != nil {
+		t.Fatalf("nested TryLock: %t, %v", ok, err)
+	}
+	if err = lock.LockContext(context.Background()); err != nil {
+		t.Fatal("nested LockContext:", err)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if n := lock.Count(); n != 3 {
+		t.Errorf("count=%d, wanted 3", n)
+	}
+
+	// another goroutine waits, and cannot unlock
+	done := make(chan bool)
+	go func() {
+		ok, _ := lock.TryLock()
+		err := lock.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		cerr := lock.LockContext(ctx)
+		done <- ok || err != locking.ErrNotOwner || cerr != context.DeadlineExceeded
+	}()
+	if <-done {
+		t.Error("another goroutine got in")
+	}
+
+	// other processes are excluded until the outermost Unlock
+	other, _ := locking.NewFLock(fh.Name())
+	defer other.Close()
+	for i := 0; i < 3; i++ {
+		if ok, _ := other.TryLock(); ok {
+			t.Fatalf("released after %d of 3 Unlocks", i)
+		}
+		if err = lock.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := other.TryLock(); !ok || err != nil {
+		t.Fatalf("not released: %t, %v", ok, err)
+	}
+	other.Unlock()
+}
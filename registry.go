@@ -0,0 +1,382 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"io"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockRegistry serializes goroutines on dynamic string keys (like a
+// customer ID). An entry lives only while it is held or waited for, so the
+// registry does not grow with the number of keys ever used.
+// The zero value is ready to use.
+type LockRegistry struct {
+	// Process, if set, returns the cross-process lock of name, acquired
+	// after the in-process one: the goroutines of this process queue on the
+	// registry, and only one of them waits for the other processes.
+	// Each acquisition gets a lock of its own: it is closed (if it is an
+	// io.Closer) at the release, or when the acquisition fails.
+	Process func(name string) (Locker, error)
+
+	// Options, if set, returns the options of name, so one registry can
+	// serve resources with different needs.
+	Options func(name string) KeyOptions
+
+	// sharded by the hash of the name, not to serialize all the lookups
+	shards [registryShards]registryShard
+}
+
+const registryShards = 64
+
+type registryShard struct {
+	mu      sync.Mutex
+	entries map[string]*registryEntry
+}
+
+type registryEntry struct {
+	sem     chan struct{} // taken by the exclusive holder, or the shared holders together
+	rmu     chan struct{} // guards readers
+	readers int
+	refs    int          // holders and waiters
+	stack   atomic.Value // string, of the exclusive holder, see SetCaptureStacks
+}
+
+// KeyOptions are the options of a name of a LockRegistry
+type KeyOptions struct {
+	// TTL, if positive, releases the hold after TTL, if not released before:
+	// a leaked Held does not block the name forever. Held.Lost tells the
+	// holder, and its Release returns ErrLeaseLost.
+	TTL time.Duration
+	// Backoff of the waits for the Process lock, the default if nil
+	Backoff *Backoff
+	// Shared holds can be held together, by any number of holders.
+	// The Process lock is acquired shared if it has RLock (like FLock and
+	// RWFLock), exclusively otherwise.
+	Shared bool
+}
+
+func (r *LockRegistry) options(name string) KeyOptions {
+	if r.Options == nil {
+		return KeyOptions{}
+	}
+	return r.Options(name)
+}
+
+// NewProcessLockRegistry returns a LockRegistry whose second tier is the
+// FLock named name in dir
+func NewProcessLockRegistry(dir string) *LockRegistry {
+	return &LockRegistry{Process: func(name string) (Locker, error) {
+		return newScopedFLock(dir, name, 0644)
+	}}
+}
+
+// Held is an acquired name of a LockRegistry
+type Held struct {
+	r      *LockRegistry
+	name   string
+	entry  *registryEntry
+	shared bool
+	proc   Locker
+	timer  *time.Timer
+	lost   chan struct{} // closed at the end of the TTL
+	once   sync.Once
+	// expired is set by the release at the end of the TTL
+	expired bool
+}
+
+// Name returns the name held
+func (h *Held) Name() string { return h.name }
+
+// Shared reports whether the hold is shared
+func (h *Held) Shared() bool { return h.shared }
+
+// Lost returns a channel which is closed when the hold is released at the
+// end of its TTL; nil without a TTL.
+func (h *Held) Lost() <-chan struct{} { return h.lost }
+
+// Release releases the name. Only the first call has an effect.
+// It returns ErrLeaseLost if the hold has been released at the end of its
+// TTL already.
+func (h *Held) Release() error {
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	err := h.release(false)
+	if h.expired {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+func (h *Held) release(expiring bool) error {
+	var err error
+	h.once.Do(func() {
+		if expiring {
+			h.expired = true
+			defer close(h.lost)
+			debugf(1, "lock %s: hold expired", h.name)
+		}
+		if h.proc != nil {
+			if ul, ok := h.proc.(interface{ RUnlock() error }); ok && h.shared {
+				err = ul.RUnlock()
+			} else {
+				err = h.proc.Unlock()
+			}
+			closeProcess(h.proc)
+		}
+		if h.shared {
+			h.entry.runlock()
+		} else {
+			h.entry.stack.Store("")
+			<-h.entry.sem
+		}
+		h.r.unref(h.name, h.entry)
+	})
+	return err
+}
+
+// acquired starts the TTL of the completed acquisition
+func (h *Held) acquired(ttl time.Duration) *Held {
+	if !h.shared && capturingStacks() {
+		h.entry.stack.Store(string(debug.Stack()))
+	}
+	if ttl > 0 {
+		h.lost = make(chan struct{})
+		h.timer = time.AfterFunc(ttl, func() { h.release(true) })
+	}
+	return h
+}
+
+// Acquire acquires name, blocking
+func (r *LockRegistry) Acquire(name string) (*Held, error) {
+	return r.AcquireContext(context.Background(), name)
+}
+
+// AcquireContext acquires name, until ctx is done
+func (r *LockRegistry) AcquireContext(ctx context.Context, name string) (*Held, error) {
+	opts := r.options(name)
+	e := r.ref(name)
+	var err error
+	if opts.Shared {
+		err = e.rlock(ctx)
+	} else {
+		select {
+		case e.sem <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		err = contentionError(name, e.holderStack, err)
+		r.unref(name, e)
+		return nil, err
+	}
+	h := &Held{r: r, name: name, entry: e, shared: opts.Shared}
+	if r.Process == nil {
+		return h.acquired(opts.TTL), nil
+	}
+	proc, err := r.Process(name)
+	if err != nil {
+		h.Release()
+		return nil, err
+	}
+	if rl, ok := proc.(interface{ RLock() error }); ok && opts.Shared {
+		err = rlockContext(ctx, name, rl, opts.Backoff)
+	} else if opts.Backoff != nil {
+		err = LockContext(ctx, opts.Backoff.Wrap(proc))
+	} else {
+		err = LockContext(ctx, proc)
+	}
+	if err != nil {
+		h.Release()
+		closeProcess(proc)
+		return nil, err
+	}
+	h.proc = proc
+	return h.acquired(opts.TTL), nil
+}
+
+// closeProcess closes the Process lock of an acquisition, if it can be closed
+func closeProcess(proc Locker) {
+	if c, ok := proc.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// rlockContext acquires lock shared, until ctx is done: polling TryRLock
+// if it has no RLockContext
+func rlockContext(ctx context.Context, name string, lock interface{ RLock() error }, backoff *Backoff) error {
+	if cl, ok := lock.(interface {
+		RLockContext(context.Context) error
+	}); ok {
+		return cl.RLockContext(ctx)
+	}
+	tl, ok := lock.(interface{ TryRLock() (bool, error) })
+	if !ok {
+		return lock.RLock()
+	}
+	eb := newExpBackoff(name)
+	if backoff != nil {
+		eb = backoff.start(name)
+	}
+	for {
+		ok, err := tl.TryRLock()
+		if ok && err == nil {
+			eb.Done()
+			return nil
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// TryAcquire acquires name, non-blocking: it returns nil if name is held,
+// by a goroutine of this or (with Process) another process.
+func (r *LockRegistry) TryAcquire(name string) (*Held, error) {
+	opts := r.options(name)
+	e := r.ref(name)
+	if !e.tryLock(opts.Shared) {
+		r.unref(name, e)
+		return nil, nil
+	}
+	h := &Held{r: r, name: name, entry: e, shared: opts.Shared}
+	if r.Process == nil {
+		return h.acquired(opts.TTL), nil
+	}
+	proc, err := r.Process(name)
+	if err != nil {
+		h.Release()
+		return nil, err
+	}
+	ok := false
+	if tl, isTry := proc.(interface{ TryRLock() (bool, error) }); isTry && opts.Shared {
+		ok, err = tl.TryRLock()
+	} else if tl, isTry := proc.(TryLocker); isTry {
+		ok, err = tl.TryLock()
+	} else {
+		err = ErrNoTryLock
+	}
+	if !ok || err != nil {
+		h.Release()
+		closeProcess(proc)
+		return nil, err
+	}
+	h.proc = proc
+	return h.acquired(opts.TTL), nil
+}
+
+// Len returns the number of names held or waited for
+func (r *LockRegistry) Len() int {
+	n := 0
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.mu.Lock()
+		n += len(sh.entries)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// shard returns the shard of name (FNV-1a)
+func (r *LockRegistry) shard(name string) *registryShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h = (h ^ uint32(name[i])) * 16777619
+	}
+	return &r.shards[h%registryShards]
+}
+
+func (r *LockRegistry) ref(name string) *registryEntry {
+	sh := r.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.entries == nil {
+		sh.entries = make(map[string]*registryEntry)
+	}
+	e := sh.entries[name]
+	if e == nil {
+		e = &registryEntry{sem: make(chan struct{}, 1), rmu: make(chan struct{}, 1)}
+		sh.entries[name] = e
+	}
+	e.refs++
+	return e
+}
+
+func (r *LockRegistry) unref(name string, e *registryEntry) {
+	sh := r.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if e.refs--; e.refs == 0 {
+		delete(sh.entries, name)
+	}
+}
+
+func (e *registryEntry) holderStack() string {
+	s, _ := e.stack.Load().(string)
+	return s
+}
+
+// rlock takes a shared hold of the entry, until ctx is done
+func (e *registryEntry) rlock(ctx context.Context) error {
+	select {
+	case e.rmu <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-e.rmu }()
+	if e.readers == 0 {
+		select {
+		case e.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	e.readers++
+	return nil
+}
+
+// tryLock takes a shared or an exclusive hold, non-blocking
+func (e *registryEntry) tryLock(shared bool) bool {
+	if !shared {
+		select {
+		case e.sem <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case e.rmu <- struct{}{}:
+	default: // a shared waiter waits for an exclusive holder
+		return false
+	}
+	defer func() { <-e.rmu }()
+	if e.readers == 0 {
+		select {
+		case e.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	e.readers++
+	return true
+}
+
+func (e *registryEntry) runlock() {
+	e.rmu <- struct{}{}
+	if e.readers--; e.readers == 0 {
+		<-e.sem
+	}
+	<-e.rmu
+}
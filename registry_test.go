@@ -0,0 +1,215 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLockRegistry(t *testing.T) {
+	var r locking.LockRegistry
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		inside  = make(map[string]int)
+		overlap bool
+	)
+	for i := 0; i < 20; i++ {
+		name := []string{"a", "b"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h, err := r.Acquire(name)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			inside[name]++
+			overlap = overlap || inside[name] > 1
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			inside[name]--
+			mu.Unlock()
+			h.Release()
+		}()
+	}
+	wg.Wait()
+	if overlap {
+		t.Error("two goroutines held the same name")
+	}
+	if n := r.Len(); n != 0 {
+		t.Errorf("%d entries left after release", n)
+	}
+
+	h, err := r.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h2, err := r.TryAcquire("a"); h2 != nil || err != nil {
+		t.Fatalf("TryAcquire of a held name: %v, %v", h2, err)
+	}
+	if h2, err := r.TryAcquire("b"); h2 == nil || err != nil {
+		t.Fatalf("TryAcquire of a free name: %v, %v", h2, err)
+	} else {
+		h2.Release()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.AcquireContext(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted DeadlineExceeded", err)
+	}
+	h.Release()
+	h.Release()
+	if n := r.Len(); n != 0 {
+		t.Errorf("%d entries left after release", n)
+	}
+}
+
+func TestProcessLockRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two registries stand for two processes
+	r1, r2 := locking.NewProcessLockRegistry(dir), locking.NewProcessLockRegistry(dir)
+	h, err := r1.Acquire("customer-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h2, err := r2.TryAcquire("customer-1"); h2 != nil || err != nil {
+		t.Fatalf("the second tier let another holder in: %v, %v", h2, err)
+	}
+	// the contended acquisitions close their Process locks
+	fds := func() int {
+		des, _ := ioutil.ReadDir("/proc/self/fd")
+		return len(des)
+	}
+	before := fds()
+	for i := 0; i < 100; i++ {
+		if h2, err := r2.TryAcquire("customer-1"); h2 != nil || err != nil {
+			t.Fatalf("%d. TryAcquire: %v, %v", i, h2, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	if h2, err := r2.AcquireContext(ctx, "customer-1"); h2 != nil || err == nil {
+		t.Fatalf("AcquireContext of a held name: %v, %v", h2, err)
+	}
+	cancel()
+	if after := fds(); before > 0 && after > before {
+		t.Errorf("%d descriptors leaked", after-before)
+	}
+	if _, err := r2.TryAcquire("../escape"); err != locking.ErrBadName {
+		t.Errorf("got %v, wanted ErrBadName", err)
+	}
+	if err = h.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if h2, err := r2.TryAcquire("customer-1"); h2 == nil || err != nil {
+		t.Fatalf("after release: %v, %v", h2, err)
+	} else {
+		h2.Release()
+	}
+}
+
+func TestLockRegistryOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newRegistry := func() *locking.LockRegistry {
+		r := locking.NewProcessLockRegistry(dir)
+		r.Options = func(name string) locking.KeyOptions {
+			switch name {
+			case "shared":
+				return locking.KeyOptions{Shared: true}
+			case "leaky":
+				return locking.KeyOptions{TTL: 20 * time.Millisecond}
+			}
+			return locking.KeyOptions{Backoff: &locking.Backoff{Initial: time.Millisecond, MaxWait: 20 * time.Millisecond}}
+		}
+		return r
+	}
+	r1, r2 := newRegistry(), newRegistry()
+
+	// shared holds, in this and the other process
+	h1, err := r1.Acquire("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := r1.Acquire("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h1.Shared() {
+		t.Error("not a shared hold")
+	}
+	if h3, err := r2.TryAcquire("shared"); h3 == nil || err != nil {
+		t.Errorf("shared hold of the other process: %v, %v", h3, err)
+	} else {
+		h3.Release()
+	}
+	h1.Release()
+	h2.Release()
+
+	// the TTL releases a leaked hold
+	leaked, err := r1.Acquire("leaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err := r1.TryAcquire("leaky"); h != nil || err != nil {
+		t.Fatalf("TryAcquire of a held name: %v, %v", h, err)
+	}
+	select {
+	case <-leaked.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Lost not closed at the end of the TTL")
+	}
+	if h, err := r1.TryAcquire("leaky"); h == nil || err != nil {
+		t.Fatalf("after the TTL: %v, %v", h, err)
+	} else {
+		h.Release()
+	}
+	if err = leaked.Release(); err != locking.ErrLeaseLost {
+		t.Errorf("Release after the TTL: got %v, wanted ErrLeaseLost", err)
+	}
+
+	// the backoff of the other keys gives up soon
+	h, err := r1.Acquire("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r2.Acquire("other"); err != locking.ErrTimeout {
+		t.Errorf("got %v, wanted ErrTimeout", err)
+	}
+	h.Release()
+	if n := r1.Len() + r2.Len(); n != 0 {
+		t.Errorf("%d entries left after release", n)
+	}
+}
+
+func BenchmarkLockRegistry(b *testing.B) {
+	var r locking.LockRegistry
+	var n int64
+	b.RunParallel(func(pb *testing.PB) {
+		name := strconv.FormatInt(atomic.AddInt64(&n, 1), 10)
+		for pb.Next() {
+			h, err := r.Acquire(name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			h.Release()
+		}
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReservationExpired is returned by Confirm after the reservation was released
+var ErrReservationExpired = errors.New("reservation expired")
+
+// Reservation is a provisionally held lock, see Reserve
+type Reservation struct {
+	lock Locker
+
+	mu        sync.Mutex
+	timer     *time.Timer
+	confirmed bool
+	released  bool
+}
+
+// Reserve acquires lock (blocking) for at most confirmWithin: unless
+// Confirm is called by then, the lock is released automatically.
+//
+// Interactive tools can show what the lock covers, and confirm only
+// after the user agreed - nobody else can take the lock in between.
+func Reserve(lock Locker, confirmWithin time.Duration) (*Reservation, error) {
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	return newReservation(lock, confirmWithin), nil
+}
+
+// TryReserve is the non-blocking Reserve. It returns AlreadyLocked if the lock is held.
+func TryReserve(lock TryLocker, confirmWithin time.Duration) (*Reservation, error) {
+	ok, err := lock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, AlreadyLocked
+	}
+	return newReservation(lock, confirmWithin), nil
+}
+
+func newReservation(lock Locker, confirmWithin time.Duration) *Reservation {
+	r := &Reservation{lock: lock}
+	r.mu.Lock()
+	r.timer = time.AfterFunc(confirmWithin, func() { r.Cancel() })
+	r.mu.Unlock()
+	return r
+}
+
+// Confirm turns the reservation into a normal hold, which lasts until Unlock.
+// It returns ErrReservationExpired if the reservation was already released.
+func (r *Reservation) Confirm() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.released {
+		return ErrReservationExpired
+	}
+	r.timer.Stop()
+	r.confirmed = true
+	return nil
+}
+
+// Cancel releases an unconfirmed reservation (a no-op after Confirm).
+func (r *Reservation) Cancel() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.confirmed || r.released {
+		return nil
+	}
+	r.timer.Stop()
+	r.released = true
+	return r.lock.Unlock()
+}
+
+// Unlock releases the lock, confirmed or not
+func (r *Reservation) Unlock() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.released {
+		return nil
+	}
+	r.timer.Stop()
+	r.released = true
+	return r.lock.Unlock()
+}
@@ -0,0 +1,38 @@
+package locking_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestReserve(t *testing.T) {
+	port := freePort(t)
+	r, err := locking.Reserve(locking.NewPortLock(port), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = locking.TryReserve(locking.NewPortLock(port), time.Second); err != locking.AlreadyLocked {
+		t.Fatalf("wanted AlreadyLocked, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err = r.Confirm(); err != locking.ErrReservationExpired {
+		t.Fatalf("wanted ErrReservationExpired, got %v", err)
+	}
+
+	r, err = locking.TryReserve(locking.NewPortLock(port), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Confirm(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if ok, _ := locking.NewPortLock(port).TryLock(); ok {
+		t.Error("confirmed reservation was released")
+	}
+	if err = r.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// ResultLock is a lock whose holder can publish a small result at release,
+// in the file at path: waiters which need only the outcome ("the cache is
+// rebuilt") take it with LockOrResult, without acquiring the lock.
+type ResultLock struct {
+	lock TryLocker
+	path string
+
+	mu      sync.Mutex
+	pending []byte
+	publish bool
+}
+
+// NewResultLock returns lock, with its results published at path
+func NewResultLock(lock TryLocker, path string) *ResultLock {
+	return &ResultLock{lock: lock, path: path}
+}
+
+// Lock acquires the lock, blocking
+func (l *ResultLock) Lock() error { return LockContext(context.Background(), l.lock) }
+
+// LockContext acquires the lock, until ctx is done
+func (l *ResultLock) LockContext(ctx context.Context) error { return LockContext(ctx, l.lock) }
+
+// TryLock acquires the lock, non-blocking
+func (l *ResultLock) TryLock() (bool, error) { return l.lock.TryLock() }
+
+// Publish sets the result to be published at the next Unlock
+func (l *ResultLock) Publish(result []byte) {
+	l.mu.Lock()
+	l.pending, l.publish = result, true
+	l.mu.Unlock()
+}
+
+// Unlock publishes the result (if any), then releases the lock
+func (l *ResultLock) Unlock() error {
+	l.mu.Lock()
+	result, publish := l.pending, l.publish
+	l.pending, l.publish = nil, false
+	l.mu.Unlock()
+	if publish {
+		r, err := readFlightResult(l.path)
+		if err == nil {
+			var b []byte
+			if b, err = json.Marshal(flightResult{Seq: r.Seq + 1, Value: result}); err == nil {
+				err = writeDurable(l.path, string(b))
+			}
+		}
+		if err != nil {
+			l.lock.Unlock()
+			return err
+		}
+	}
+	return l.lock.Unlock()
+}
+
+// Result returns the last published result
+func (l *ResultLock) Result() (result []byte, published bool, err error) {
+	r, err := readFlightResult(l.path)
+	return r.Value, r.Seq != 0, err
+}
+
+// LockOrResult waits until either the lock is acquired (published is false),
+// or a result is published (published is true, the lock is not held).
+func (l *ResultLock) LockOrResult(ctx context.Context) (result []byte, published bool, err error) {
+	before, err := readFlightResult(l.path)
+	if err != nil {
+		return nil, false, err
+	}
+	eb := newExpBackoff(l.path)
+	defer beginWait(l.path)()
+	for {
+		ok, err := l.lock.TryLock()
+		if err != nil && !Retryable(err) {
+			return nil, false, err
+		}
+		r, rerr := readFlightResult(l.path)
+		if rerr != nil {
+			if ok {
+				l.lock.Unlock()
+			}
+			return nil, false, rerr
+		}
+		if r.Seq != before.Seq {
+			if ok {
+				err = l.lock.Unlock()
+			}
+			return r.Value, true, err
+		}
+		if ok {
+			eb.Done()
+			return nil, false, nil
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return nil, false, err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestResultLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newLock := func() *locking.ResultLock {
+		fl, err := locking.NewFLockCreate(filepath.Join(dir, "lock"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return locking.NewResultLock(fl, filepath.Join(dir, "result"))
+	}
+	holder, waiter := newLock(), newLock()
+	if err = testLock(holder); err != nil {
+		t.Fatal(err)
+	}
+	if _, published, err := holder.Result(); published || err != nil {
+		t.Fatalf("published before Publish: %t, %v", published, err)
+	}
+
+	ctx := context.Background()
+	if err = holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []byte, 1)
+	go func() {
+		result, published, err := waiter.LockOrResult(ctx)
+		if err != nil || !published {
+			t.Errorf("waiter: published=%t, %v", published, err)
+		}
+		done <- result
+	}()
+	time.Sleep(20 * time.Millisecond)
+	holder.Publish([]byte("rebuilt"))
+	if err = holder.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-done:
+		if string(result) != "rebuilt" {
+			t.Errorf("got %q", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter did not get the result")
+	}
+	// the waiter did not keep the lock
+	if ok, err := holder.TryLock(); !ok || err != nil {
+		t.Fatalf("lock left held: %t, %v", ok, err)
+	}
+	holder.Unlock()
+
+	// without a new result, the lock is acquired
+	if _, published, err := waiter.LockOrResult(ctx); published || err != nil {
+		t.Fatalf("free lock: published=%t, %v", published, err)
+	}
+	waiter.Unlock()
+	if result, published, err := waiter.Result(); !published || string(result) != "rebuilt" || err != nil {
+		t.Errorf("Result: %q, %t, %v", result, published, err)
+	}
+}
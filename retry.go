@@ -0,0 +1,63 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Retryable is consulted by the Lock loops when an attempt fails with an
+// error (not just contention): true keeps on trying, false returns the error.
+//
+// It defaults to IsRetryable; replace it (before locking) to customize.
+var Retryable = IsRetryable
+
+// maxRetries bounds the retries of a blocking attempt by retryBlocking
+const maxRetries = 10
+
+// retryBlocking calls the attempt op, and repeats it while it fails with a
+// Retryable error other than EWOULDBLOCK (contention), at most maxRetries
+// times, sleeping 10ms doubled up to 1s in between. The last error is
+// returned.
+func retryBlocking(op func() error) error {
+	err := op()
+	delay := 10 * time.Millisecond
+	for i := 0; i < maxRetries && err != nil && err != syscall.EWOULDBLOCK && Retryable(err); i++ {
+		time.Sleep(delay)
+		if delay < time.Second {
+			delay *= 2
+		}
+		err = op()
+	}
+	return err
+}
+
+// IsRetryable classifies err as transient (connection refused, timeout,
+// interrupted system call...) or fatal (permission denied, missing path...).
+// Unknown errors are fatal, so a Lock doesn't spin on them forever.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EINTR, syscall.EAGAIN, syscall.EBUSY, syscall.ENOLCK,
+			syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED,
+			syscall.ETIMEDOUT, syscall.EHOSTUNREACH, syscall.ENETUNREACH,
+			syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ESTALE:
+			return true
+		}
+		return false
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package locking_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestIsRetryable(t *testing.T) {
+	for err, want := range map[error]bool{
+		syscall.ECONNREFUSED:               true,
+		&os.PathError{Err: syscall.EINTR}:  true,
+		syscall.EACCES:                     false,
+		&os.PathError{Err: syscall.ENOENT}: false,
+		errors.New("something unknown"):    false,
+	} {
+		if got := locking.IsRetryable(err); got != want {
+			t.Errorf("%v: got %t, wanted %t", err, got, want)
+		}
+	}
+}
+
+func TestDirLockFatal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock := locking.DirLock(filepath.Join(dir, "missing", "x.lock"))
+	if err := lock.Lock(); !os.IsNotExist(err) {
+		t.Errorf("wanted not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// LockerFactory returns the Locker for key on one backend
+type LockerFactory func(key string) (Locker, error)
+
+// Ring spreads lock keys over several backends (lock servers) by consistent
+// hashing, so adding or removing a backend moves only its share of keys.
+type Ring struct {
+	hashes   []uint64
+	owners   []string
+	backends map[string]LockerFactory
+}
+
+// NewRing returns a Ring of the named backends, each placed on the ring
+// replicas times (virtual nodes) for an even spread.
+func NewRing(replicas int, backends map[string]LockerFactory) *Ring {
+	if replicas < 1 {
+		replicas = 1
+	}
+	r := &Ring{backends: backends}
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	points := make([]point, 0, replicas*len(backends))
+	for name := range backends {
+		for i := 0; i < replicas; i++ {
+			points = append(points, point{hash: hashKey(name + "#" + strconv.Itoa(i)), owner: name})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+		return points[i].hash < points[j].hash
+	})
+	for _, p := range points {
+		r.hashes = append(r.hashes, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// Backend returns the name of the backend responsible for key
+func (r *Ring) Backend(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// Locker returns the Locker for key, from its backend
+func (r *Ring) Locker(key string) (Locker, error) {
+	name := r.Backend(key)
+	if name == "" {
+		return nil, errors.New("empty ring")
+	}
+	return r.backends[name](key)
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV spreads similar short strings poorly, so mix (splitmix64 finalizer)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
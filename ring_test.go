@@ -0,0 +1,37 @@
+package locking_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestRing(t *testing.T) {
+	factory := func(key string) (locking.Locker, error) { return locking.NewPortLock(0), nil }
+	backends := map[string]locking.LockerFactory{"a": factory, "b": factory, "c": factory}
+	ring := locking.NewRing(64, backends)
+	before := make(map[string]string)
+	count := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key] = ring.Backend(key)
+		count[before[key]]++
+	}
+	for name, n := range count {
+		if n < 500 {
+			t.Errorf("backend %s got only %d keys", name, n)
+		}
+	}
+
+	delete(backends, "c")
+	ring = locking.NewRing(64, backends)
+	for key, was := range before {
+		if now := ring.Backend(key); was != "c" && now != was {
+			t.Errorf("%s moved from %s to %s", key, was, now)
+		}
+	}
+	if _, err := ring.Locker("x"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"syscall"
+	"time"
+)
+
+// RLock acquires the lock shared, blocking: other readers may hold it at
+// the same time, writers (Lock) are excluded. Release it with Unlock.
+//
+// Upgrade converts it to an exclusive hold (Lock would wait for it), Downgrade
+// does the reverse (use it instead of RLock, to bump the generation) - but flock
+// converts by releasing and reacquiring, so another writer may get in between.
+func (lock *FLock) RLock() error {
+	return lock.rlock(syscall.LOCK_SH)
+}
+
+// TryRLock acquires the lock shared, non-blocking. As TryLock, it returns
+// false while another goroutine holds this FLock.
+func (lock *FLock) TryRLock() (bool, error) {
+	err := lock.rlock(syscall.LOCK_SH | syscall.LOCK_NB)
+	switch err {
+	case nil:
+		return true, nil
+	case syscall.EWOULDBLOCK:
+		return false, nil
+	}
+	return false, err
+}
+
+// Upgrade converts the shared lock, held by the caller, to an exclusive one.
+// It returns ErrNotHeld if the lock is not held shared.
+func (lock *FLock) Upgrade() error {
+	lock.Mutex.Lock()
+	defer lock.Mutex.Unlock()
+	if !lock.held || !lock.shared {
+		return ErrNotHeld
+	}
+	start := time.Now()
+	defer beginWait(lock.path)()
+	if err := retryBlocking(func() error { return syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_EX) }); err != nil {
+		return err
+	}
+	recordAcquisition(lock.path, 1, 0, time.Since(start))
+	lock.shared = false
+	if err := lock.acquired(); err != nil {
+		<-lock.sem
+		return err
+	}
+	return nil
+}
+
+// Downgrade converts the exclusively held lock to a shared one
+func (lock *FLock) Downgrade() error {
+	lock.Mutex.Lock()
+	defer lock.Mutex.Unlock()
+	if !lock.held || lock.shared {
+		return nil
+	}
+	if err := lock.endGeneration(); err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(lock.fh.Fd()), syscall.LOCK_SH); err != nil {
+		return err
+	}
+	lock.shared = true
+	return nil
+}
+
+func (lock *FLock) rlock(how int) error {
+	ctx := context.Background()
+	if how&syscall.LOCK_NB != 0 {
+		ctx = nil
+	}
+	if ok, err := lock.enter(ctx); !ok {
+		if err == nil {
+			err = syscall.EWOULDBLOCK
+		}
+		return err
+	}
+	defer lock.leave()
+	if lock.fh == nil {
+		var err error
+		if lock.fh, err = lock.open(); err != nil {
+			return err
+		}
+	}
+	op := func() error { return syscall.Flock(int(lock.fh.Fd()), how) }
+	var err error
+	if how&syscall.LOCK_NB == 0 {
+		err = retryBlocking(op)
+	} else {
+		err = op()
+	}
+	if err == nil {
+		lock.held, lock.shared = true, true
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestRLock(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+
+	var readers [2]*locking.FLock
+	for i := range readers {
+		if readers[i], err = locking.NewFLock(fh.Name()); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := readers[i].TryRLock(); !ok || err != nil {
+			t.Fatalf("reader %d: %t, %v", i, ok, err)
+		}
+	}
+	writer, err := locking.NewFLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := writer.TryLock(); ok {
+		t.Fatal("writer got in beside the readers")
+	}
+	readers[1].Unlock()
+	if err = readers[0].Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := writer.TryRLock(); ok {
+		t.Fatal("reader got in beside the writer")
+	}
+	if err = readers[0].Downgrade(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := writer.TryRLock(); !ok || err != nil {
+		t.Fatalf("reader beside the downgraded: %t, %v", ok, err)
+	}
+	writer.Unlock()
+	readers[0].Unlock()
+}
+
+func TestLockWaitsForRLock(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+
+	lock, err := locking.NewFLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	if err = lock.RLock(); err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan error, 1)
+	go func() {
+		err := lock.Lock()
+		locked <- err
+		if err == nil {
+			lock.Unlock()
+		}
+	}()
+	select {
+	case err = <-locked:
+		t.Fatalf("Lock of another goroutine got in beside RLock: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock did not get the lock after the RLock was released")
+	}
+	if err = lock.Upgrade(); err != locking.ErrNotHeld {
+		t.Errorf("Upgrade of an unheld lock: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	runtimeBackoff atomic.Value // Backoff, set by SetDefaultBackoff
+	verbosity      int32
+	logfMu         sync.Mutex
+	logf           func(format string, args ...interface{})
+)
+
+// SetDefaultBackoff replaces DefaultBackoff for the following waits;
+// safe to call while the locks are used
+func SetDefaultBackoff(b Backoff) { runtimeBackoff.Store(b) }
+
+// CurrentBackoff returns the backoff used by the polling locks now
+func CurrentBackoff() Backoff {
+	if b, ok := runtimeBackoff.Load().(Backoff); ok {
+		return b
+	}
+	return DefaultBackoff
+}
+
+// SetLogger sets the function the package logs with, nil for none
+// (the default). What is logged depends on SetVerbosity.
+func SetLogger(fn func(format string, args ...interface{})) {
+	logfMu.Lock()
+	logf = fn
+	logfMu.Unlock()
+}
+
+// SetVerbosity sets the amount of logging: 0 is nothing, 1 logs broken
+// stale locks and lost leases, 2 each backoff sleep, too
+func SetVerbosity(v int) { atomic.StoreInt32(&verbosity, int32(v)) }
+
+func debugf(v int, format string, args ...interface{}) {
+	if int(atomic.LoadInt32(&verbosity)) < v {
+		return
+	}
+	logfMu.Lock()
+	fn := logf
+	logfMu.Unlock()
+	if fn != nil {
+		fn(format, args...)
+	}
+}
+
+// RuntimeConfig is the configuration which can be changed while running:
+//
+//	{"verbosity": 1, "backoff": {"initial": "100ms", "max": "5s"}, "capture_stacks": true}
+//
+// The missing parts are left as they are, the parts of the backoff too.
+type RuntimeConfig struct {
+	Verbosity     *int         `json:"verbosity,omitempty" yaml:"verbosity,omitempty"`
+	Backoff       *BackoffSpec `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+	CaptureStacks *bool        `json:"capture_stacks,omitempty" yaml:"capture_stacks,omitempty"`
+}
+
+// Apply sets the configuration
+func (c RuntimeConfig) Apply() {
+	if c.Verbosity != nil {
+		SetVerbosity(*c.Verbosity)
+	}
+	if c.Backoff != nil {
+		SetDefaultBackoff(c.Backoff.merge(CurrentBackoff()))
+	}
+	if c.CaptureStacks != nil {
+		SetCaptureStacks(*c.CaptureStacks)
+	}
+}
+
+// LoadRuntimeConfig reads the JSON RuntimeConfig at path, and applies it
+func LoadRuntimeConfig(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var c RuntimeConfig
+	if err = json.Unmarshal(b, &c); err != nil {
+		return &os.PathError{Op: "parse", Path: path, Err: err}
+	}
+	c.Apply()
+	return nil
+}
+
+// ReloadOnSIGHUP calls LoadRuntimeConfig(path) at each SIGHUP, until stop is
+// called, passing its errors to onError (if not nil): operators can turn on
+// logging, or tune the backoff without restarting the lock holders.
+func ReloadOnSIGHUP(path string, onError func(error)) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigs:
+			}
+			if err := LoadRuntimeConfig(path); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(done)
+		})
+	}
+}
+
+// ttlValue is a TTL which can be changed while a lease is held
+type ttlValue int64
+
+func (v *ttlValue) get() time.Duration  { return time.Duration(atomic.LoadInt64((*int64)(v))) }
+func (v *ttlValue) set(d time.Duration) { atomic.StoreInt64((*int64)(v), int64(d)) }
@@ -0,0 +1,127 @@
+package locking_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestRuntimeConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer locking.SetDefaultBackoff(locking.DefaultBackoff)
+	defer locking.SetVerbosity(0)
+	defer locking.SetLogger(nil)
+
+	var mu sync.Mutex
+	var logs []string
+	locking.SetLogger(func(format string, args ...interface{}) {
+		mu.Lock()
+		logs = append(logs, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	})
+	cfg := filepath.Join(dir, "lock.json")
+	if err = ioutil.WriteFile(cfg, []byte(`{"verbosity": 2, "backoff": {"initial": "2ms", "max": "4ms", "max_wait": "30ms"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stop := locking.ReloadOnSIGHUP(cfg, func(err error) { t.Error(err) })
+	defer stop()
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	for deadline := time.Now().Add(5 * time.Second); locking.CurrentBackoff().Initial != 2*time.Millisecond; {
+		if time.Now().After(deadline) {
+			t.Fatal("config not reloaded on SIGHUP")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if b := locking.CurrentBackoff(); b.Max != 4*time.Millisecond || b.MaxWait != 30*time.Millisecond {
+		t.Errorf("backoff %+v", b)
+	}
+	// a partial backoff keeps the other parts
+	locking.RuntimeConfig{Backoff: &locking.BackoffSpec{Multiplier: 3}}.Apply()
+	if b := locking.CurrentBackoff(); b.Multiplier != 3 || b.Initial != 2*time.Millisecond || b.Max != 4*time.Millisecond {
+		t.Errorf("backoff %+v after a partial update", b)
+	}
+
+	// the polling locks use the new backoff, and log their sleeps
+	lock, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	waiter, _ := locking.NewDirLock(dir)
+	if err = waiter.Lock(); err != locking.ErrTimeout {
+		t.Errorf("got %v, wanted ErrTimeout of the MaxWait", err)
+	}
+	lock.Unlock()
+	mu.Lock()
+	if len(logs) == 0 || !strings.Contains(logs[0], "sleeping") {
+		t.Errorf("logs %q", logs)
+	}
+	logs = nil
+	mu.Unlock()
+
+	if err = ioutil.WriteFile(cfg, []byte(`{"verbosity": 0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = locking.LoadRuntimeConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	waiter.Lock()
+	lock.Unlock()
+	mu.Lock()
+	if len(logs) != 0 {
+		t.Errorf("logged with verbosity 0: %q", logs)
+	}
+	mu.Unlock()
+	if err = ioutil.WriteFile(cfg, []byte(`{"verbosity": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = locking.LoadRuntimeConfig(cfg); err == nil {
+		t.Error("broken config accepted")
+	}
+}
+
+func TestLeaseLockSetTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock := locking.NewLeaseLock(filepath.Join(dir, "lease"), 300*time.Millisecond)
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.SetTTL(0); err != locking.ErrBadTTL {
+		t.Errorf("SetTTL(0): got %v, wanted ErrBadTTL", err)
+	}
+	if err = lock.SetTTL(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond) // a renewal
+	info, err := lock.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(info.Expires) < 30*time.Minute {
+		t.Errorf("expires %s, wanted the new TTL", info.Expires)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
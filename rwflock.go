@@ -0,0 +1,197 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RWFLock is a readers-writer lock across processes and goroutines, on shared
+// and exclusive flocks. Each holder flocks its own descriptor, so the
+// readers of this process and of others share the lock, and a writer excludes
+// all of them - as with sync.RWMutex, but across processes.
+//
+// Upgrade deadlock: calling Lock while holding RLock waits for the own read
+// lock forever, and two readers doing so wait for each other. Use Upgrade,
+// which gives up the read lock first: it is not atomic, another writer
+// may get in between, so re-check what was read.
+type RWFLock struct {
+	path string
+
+	mu      sync.Mutex
+	readers []*os.File
+	writer  *os.File
+}
+
+// NewRWFLock returns a readers-writer lock on the file at path (created if
+// missing, unlocked first)
+func NewRWFLock(path string) *RWFLock {
+	return &RWFLock{path: path}
+}
+
+// RLock acquires the lock shared, blocking
+func (l *RWFLock) RLock() error {
+	fh, err := l.acquire(nil, syscall.LOCK_SH)
+	if err == nil {
+		l.addReader(fh)
+	}
+	return err
+}
+
+// RLockContext acquires the lock shared, until ctx is done
+func (l *RWFLock) RLockContext(ctx context.Context) error {
+	fh, err := l.acquire(ctx, syscall.LOCK_SH)
+	if err == nil {
+		l.addReader(fh)
+	}
+	return err
+}
+
+// TryRLock acquires the lock shared, non-blocking
+func (l *RWFLock) TryRLock() (bool, error) {
+	fh, err := l.acquire(nil, syscall.LOCK_SH|syscall.LOCK_NB)
+	if fh == nil {
+		return false, err
+	}
+	l.addReader(fh)
+	return true, nil
+}
+
+// RUnlock releases one read lock
+func (l *RWFLock) RUnlock() error {
+	l.mu.Lock()
+	if len(l.readers) == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	fh := l.readers[len(l.readers)-1]
+	l.readers = l.readers[:len(l.readers)-1]
+	l.mu.Unlock()
+	return unflock(fh)
+}
+
+// Lock acquires the lock exclusively, blocking
+func (l *RWFLock) Lock() error {
+	fh, err := l.acquire(nil, syscall.LOCK_EX)
+	if err == nil {
+		l.setWriter(fh)
+	}
+	return err
+}
+
+// LockContext acquires the lock exclusively, until ctx is done
+func (l *RWFLock) LockContext(ctx context.Context) error {
+	fh, err := l.acquire(ctx, syscall.LOCK_EX)
+	if err == nil {
+		l.setWriter(fh)
+	}
+	return err
+}
+
+// TryLock acquires the lock exclusively, non-blocking
+func (l *RWFLock) TryLock() (bool, error) {
+	fh, err := l.acquire(nil, syscall.LOCK_EX|syscall.LOCK_NB)
+	if fh == nil {
+		return false, err
+	}
+	l.setWriter(fh)
+	return true, nil
+}
+
+// Unlock releases the write lock
+func (l *RWFLock) Unlock() error {
+	l.mu.Lock()
+	fh := l.writer
+	l.writer = nil
+	l.mu.Unlock()
+	if fh == nil {
+		return nil
+	}
+	return unflock(fh)
+}
+
+// Upgrade converts a held read lock to the write lock, blocking.
+// The read lock is released first: see the upgrade deadlock at RWFLock.
+func (l *RWFLock) Upgrade() error {
+	l.mu.Lock()
+	if len(l.readers) == 0 {
+		l.mu.Unlock()
+		return ErrNotAcquired
+	}
+	fh := l.readers[len(l.readers)-1]
+	l.readers = l.readers[:len(l.readers)-1]
+	l.mu.Unlock()
+	// flock converts on the same descriptor: the shared lock is dropped
+	// before waiting, so two upgrading readers do not deadlock
+	err := retryBlocking(func() error { return syscall.Flock(int(fh.Fd()), syscall.LOCK_EX) })
+	if err != nil {
+		fh.Close()
+		return err
+	}
+	l.setWriter(fh)
+	return nil
+}
+
+// acquire flocks a new descriptor with how; polling until ctx is done if ctx
+// is not nil. It returns nil, nil if the lock is held (LOCK_NB).
+func (l *RWFLock) acquire(ctx context.Context, how int) (*os.File, error) {
+	fh, err := os.OpenFile(l.path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer beginWait(l.path)()
+	if ctx != nil {
+		how |= syscall.LOCK_NB
+	}
+	delay := time.Millisecond
+	for {
+		err = retryBlocking(func() error { return syscall.Flock(int(fh.Fd()), how) })
+		if err == nil {
+			return fh, nil
+		}
+		if err == syscall.EWOULDBLOCK && ctx != nil {
+			select {
+			case <-ctx.Done():
+				fh.Close()
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+			if delay < 100*time.Millisecond {
+				delay *= 2
+			}
+			continue
+		}
+		fh.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, err
+	}
+}
+
+func (l *RWFLock) addReader(fh *os.File) {
+	l.mu.Lock()
+	l.readers = append(l.readers, fh)
+	l.mu.Unlock()
+}
+
+func (l *RWFLock) setWriter(fh *os.File) {
+	l.mu.Lock()
+	l.writer = fh
+	l.mu.Unlock()
+}
+
+// unflock releases the flock of fh and closes it
+func unflock(fh *os.File) error {
+	err := syscall.Flock(int(fh.Fd()), syscall.LOCK_UN)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
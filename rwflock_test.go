@@ -0,0 +1,68 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestRWFLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+
+	// a and b stand for two processes
+	a, b := locking.NewRWFLock(path), locking.NewRWFLock(path)
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.RLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.RLock(); err != nil {
+		t.Fatal("second reader of the same process:", err)
+	}
+	if ok, err := b.TryRLock(); !ok || err != nil {
+		t.Fatalf("reader of another process: %t, %v", ok, err)
+	}
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("writer admitted with readers: %t, %v", ok, err)
+	}
+	b.RUnlock()
+	a.RUnlock()
+
+	// the last reader upgrades
+	if err = a.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryRLock(); ok || err != nil {
+		t.Fatalf("reader admitted with a writer: %t, %v", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = b.RLockContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted DeadlineExceeded", err)
+	}
+	if err = a.Upgrade(); err != locking.ErrNotAcquired {
+		t.Errorf("Upgrade without a read lock: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- b.RLock() }()
+	time.Sleep(10 * time.Millisecond)
+	if err = a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	b.RUnlock()
+}
@@ -0,0 +1,86 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrBadName is returned for lock names which are not plain file names
+var ErrBadName = errors.New("lock name must be a plain file name")
+
+// UserLockDir returns the directory for the locks of the current user:
+// $XDG_RUNTIME_DIR if set, else a 0700 per-user directory in os.TempDir.
+func UserLockDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir := filepath.Join(os.TempDir(), "golocking-"+strconv.Itoa(os.Getuid()))
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	// a directory planted by somebody else would share our locks
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || fi.Mode().Perm() != 0700 || !ok || int(st.Uid) != os.Getuid() {
+		return "", &os.PathError{Op: "lockdir", Path: dir, Err: os.ErrPermission}
+	}
+	return dir, nil
+}
+
+// SystemLockDir returns the directory for the locks shared by all users
+// of the machine: /run/lock, or /var/lock on older systems.
+func SystemLockDir() (string, error) {
+	var err error
+	for _, dir := range []string{"/run/lock", "/var/lock"} {
+		var fi os.FileInfo
+		if fi, err = os.Stat(dir); err == nil && fi.IsDir() {
+			return dir, nil
+		}
+	}
+	if err == nil {
+		err = os.ErrNotExist
+	}
+	return "", err
+}
+
+// NewUserFLock returns the FLock named name, private to the current user
+func NewUserFLock(name string) (*FLock, error) {
+	dir, err := UserLockDir()
+	if err != nil {
+		return nil, err
+	}
+	return newScopedFLock(dir, name, 0600)
+}
+
+// NewSystemFLock returns the FLock named name, shared by all users:
+// the lock file is readable by everybody, which is enough to flock it.
+func NewSystemFLock(name string) (*FLock, error) {
+	dir, err := SystemLockDir()
+	if err != nil {
+		return nil, err
+	}
+	return newScopedFLock(dir, name, 0644)
+}
+
+func newScopedFLock(dir, name string, perm os.FileMode) (*FLock, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, os.PathSeparator) {
+		return nil, ErrBadName
+	}
+	path := filepath.Join(dir, name)
+	fh, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &FLock{path: path, fh: fh, sem: make(chan struct{}, 1)}, nil
+}
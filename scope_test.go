@@ -0,0 +1,40 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestUserFLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+
+	lock, err := locking.NewUserFLock("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("user lock file mode %v", fi.Mode())
+	}
+	for _, name := range []string{"", "..", "a/b"} {
+		if _, err := locking.NewUserFLock(name); err != locking.ErrBadName {
+			t.Errorf("%q: got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ErrNotAcquired is returned by Release without a held slot
+var ErrNotAcquired = errors.New("semaphore slot not acquired")
+
+// SemaphoreLock is a counting lock, admitting up to capacity holders at once,
+// across processes: each holder flocks one of the slot files path.0 ...
+// path.<capacity-1>, so the slots of a dead process are freed by the OS.
+//
+// One SemaphoreLock can hold more slots (for more goroutines); Release frees
+// the last acquired one. All users must agree on the capacity.
+//
+// Weighted holders take n slots at once with AcquireN and free them with
+// ReleaseN(n): the capacity is a budget shared by jobs of different costs,
+// and the slot files are its persistent accounting, reclaimed on crash.
+type SemaphoreLock struct {
+	path     string
+	capacity int
+
+	mu   sync.Mutex
+	held []*os.File
+}
+
+// NewSemaphoreLock returns a semaphore of capacity slots at path (none held first)
+func NewSemaphoreLock(path string, capacity int) (*SemaphoreLock, error) {
+	if capacity < 1 {
+		return nil, errors.New("semaphore capacity must be positive")
+	}
+	return &SemaphoreLock{path: path, capacity: capacity}, nil
+}
+
+// Capacity returns the number of slots
+func (s *SemaphoreLock) Capacity() int { return s.capacity }
+
+// Acquire acquires a slot, blocking
+func (s *SemaphoreLock) Acquire() error {
+	return s.AcquireNContext(context.Background(), 1)
+}
+
+// AcquireContext acquires a slot, until ctx is done
+func (s *SemaphoreLock) AcquireContext(ctx context.Context) error {
+	return s.AcquireNContext(ctx, 1)
+}
+
+// TryAcquire acquires a slot, non-blocking
+func (s *SemaphoreLock) TryAcquire() (bool, error) { return s.TryAcquireN(1) }
+
+// Release frees the last acquired slot
+func (s *SemaphoreLock) Release() error { return s.ReleaseN(1) }
+
+// AcquireN acquires n slots at once, blocking, for holders of weight n
+func (s *SemaphoreLock) AcquireN(n int) error {
+	return s.AcquireNContext(context.Background(), n)
+}
+
+// AcquireNContext acquires n slots at once, until ctx is done
+func (s *SemaphoreLock) AcquireNContext(ctx context.Context, n int) error {
+	eb := newExpBackoff(s.path)
+	defer beginWait(s.path)()
+	for {
+		ok, err := s.TryAcquireN(n)
+		if ok {
+			eb.Done()
+			return err
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// TryAcquireN acquires n slots, or none, non-blocking
+func (s *SemaphoreLock) TryAcquireN(n int) (bool, error) {
+	if err := s.checkWeight(n); err != nil {
+		return false, err
+	}
+	if n > 1 {
+		// serialize the weighted ones, not to grab parts of the slots each
+		guard, err := os.OpenFile(s.path+".guard", os.O_RDONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return false, err
+		}
+		defer guard.Close()
+		if err = syscall.Flock(int(guard.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			if err == syscall.EWOULDBLOCK {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	got := make([]*os.File, 0, n)
+	release := func() {
+		for _, fh := range got {
+			unflock(fh)
+		}
+	}
+	// start at a random slot, not to contend on the first ones
+	first := rand.Intn(s.capacity)
+	for i := 0; i < s.capacity && len(got) < n; i++ {
+		path := s.path + "." + strconv.Itoa((first+i)%s.capacity)
+		fh, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+		if err != nil {
+			release()
+			return false, err
+		}
+		err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			got = append(got, fh)
+			continue
+		}
+		fh.Close()
+		if err != syscall.EWOULDBLOCK {
+			release()
+			return false, err
+		}
+	}
+	if len(got) < n {
+		release()
+		return false, nil
+	}
+	s.mu.Lock()
+	s.held = append(s.held, got...)
+	s.mu.Unlock()
+	return true, nil
+}
+
+// checkWeight returns an error if n is out of 1..capacity
+func (s *SemaphoreLock) checkWeight(n int) error {
+	if n < 1 || n > s.capacity {
+		return errors.New("semaphore weight " + strconv.Itoa(n) +
+			" out of 1.." + strconv.Itoa(s.capacity))
+	}
+	return nil
+}
+
+// ReleaseN frees the last n acquired slots
+func (s *SemaphoreLock) ReleaseN(n int) error {
+	if n < 1 {
+		return s.checkWeight(n)
+	}
+	s.mu.Lock()
+	if len(s.held) < n {
+		s.mu.Unlock()
+		return ErrNotAcquired
+	}
+	fhs := append([]*os.File(nil), s.held[len(s.held)-n:]...)
+	s.held = s.held[:len(s.held)-n]
+	s.mu.Unlock()
+	var firstErr error
+	for _, fh := range fhs {
+		if err := unflock(fh); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Lock is Acquire, for using the semaphore as a Locker
+func (s *SemaphoreLock) Lock() error { return s.Acquire() }
+
+// LockContext is AcquireContext
+func (s *SemaphoreLock) LockContext(ctx context.Context) error { return s.AcquireContext(ctx) }
+
+// TryLock is TryAcquire
+func (s *SemaphoreLock) TryLock() (bool, error) { return s.TryAcquire() }
+
+// Unlock is Release
+func (s *SemaphoreLock) Unlock() error { return s.Release() }
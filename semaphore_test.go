@@ -0,0 +1,129 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestSemaphoreLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sem")
+
+	if _, err = locking.NewSemaphoreLock(path, 0); err == nil {
+		t.Error("zero capacity accepted")
+	}
+	a, err := locking.NewSemaphoreLock(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := locking.NewSemaphoreLock(path, 3)
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Release(); err != locking.ErrNotAcquired {
+		t.Errorf("Release without a slot: %v", err)
+	}
+
+	// the slots are shared with other users of the path
+	for i := 0; i < 2; i++ {
+		if err = a.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := b.TryAcquire(); !ok || err != nil {
+		t.Fatalf("third slot: %t, %v", ok, err)
+	}
+	if ok, err := b.TryAcquire(); ok || err != nil {
+		t.Fatalf("fourth slot: %t, %v", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = b.AcquireContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted DeadlineExceeded", err)
+	}
+	a.Release()
+	a.Release()
+	b.Release()
+
+	var cur, max int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.Acquire(); err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&cur, 1)
+			for m := atomic.LoadInt32(&max); n > m && !atomic.CompareAndSwapInt32(&max, m, n); m = atomic.LoadInt32(&max) {
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&cur, -1)
+			a.Release()
+		}()
+	}
+	wg.Wait()
+	if max > 3 {
+		t.Errorf("%d concurrent holders, capacity is 3", max)
+	}
+}
+
+func TestSemaphoreLockWeighted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sem")
+	a, _ := locking.NewSemaphoreLock(path, 4)
+	b, _ := locking.NewSemaphoreLock(path, 4)
+
+	if _, err = a.TryAcquireN(5); err == nil {
+		t.Error("weight above the capacity accepted")
+	}
+	if err = a.AcquireN(3); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryAcquireN(2); ok || err != nil {
+		t.Fatalf("2 of 1 free slots: %t, %v", ok, err)
+	}
+	// a failed attempt holds nothing
+	if ok, err := b.TryAcquire(); !ok || err != nil {
+		t.Fatalf("the last slot: %t, %v", ok, err)
+	}
+	if err = b.ReleaseN(2); err != locking.ErrNotAcquired {
+		t.Errorf("ReleaseN(2) of 1 held: %v", err)
+	}
+	for _, n := range []int{0, -1} {
+		if err = b.ReleaseN(n); err == nil || err == locking.ErrNotAcquired {
+			t.Errorf("ReleaseN(%d): %v", n, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = b.AcquireNContext(ctx, 2); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted DeadlineExceeded", err)
+	}
+	if err = a.ReleaseN(3); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryAcquireN(3); !ok || err != nil {
+		t.Fatalf("3 of 3 free slots: %t, %v", ok, err)
+	}
+	if err = b.ReleaseN(4); err != nil {
+		t.Fatal(err)
+	}
+}
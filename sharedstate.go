@@ -0,0 +1,139 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const sharedStateHeader = 12 // magic, length, crc32
+
+var sharedStateMagic = [4]byte{'G', 'L', 'S', 'S'}
+
+// ErrCorrupt is returned when the shared state fails validation
+var ErrCorrupt = errors.New("shared state is corrupt")
+
+// SharedState is a small blob shared between processes through an mmap'd
+// file. Get and Update run under a shared resp. exclusive flock of the
+// file, and the content is validated by a CRC. It is safe for concurrent use.
+type SharedState struct {
+	// mu excludes the goroutines, which share the flock of fh. Get takes it
+	// too: a reader's LOCK_UN would drop the shared flock of the others.
+	mu  sync.Mutex
+	fh  *os.File
+	mem []byte
+}
+
+// OpenSharedState opens (or creates) the state file at path, with room for size bytes
+func OpenSharedState(path string, size int) (*SharedState, error) {
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	total := int64(sharedStateHeader + size)
+	if err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	fi, err := fh.Stat()
+	if err == nil && fi.Size() < total {
+		err = fh.Truncate(total)
+	} else if err == nil {
+		total = fi.Size()
+	}
+	syscall.Flock(int(fh.Fd()), syscall.LOCK_UN)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	mem, err := syscall.Mmap(int(fh.Fd()), 0, int(total), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return &SharedState{fh: fh, mem: mem}, nil
+}
+
+// Size returns the capacity of the state
+func (s *SharedState) Size() int {
+	return len(s.mem) - sharedStateHeader
+}
+
+// Get calls fn with a copy of the current state, under a shared lock.
+// A never written state is empty.
+func (s *SharedState) Get(fn func(data []byte) error) error {
+	s.mu.Lock()
+	if err := syscall.Flock(int(s.fh.Fd()), syscall.LOCK_SH); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	data, err := s.read()
+	syscall.Flock(int(s.fh.Fd()), syscall.LOCK_UN)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return fn(data)
+}
+
+// Update replaces the state with the result of fn, under an exclusive lock.
+// fn gets a copy of the current state.
+func (s *SharedState) Update(fn func(data []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := syscall.Flock(int(s.fh.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(s.fh.Fd()), syscall.LOCK_UN)
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+	if data, err = fn(data); err != nil {
+		return err
+	}
+	if len(data) > s.Size() {
+		return errors.New("shared state too big")
+	}
+	copy(s.mem[sharedStateHeader:], data)
+	binary.BigEndian.PutUint32(s.mem[4:8], uint32(len(data)))
+	binary.BigEndian.PutUint32(s.mem[8:12], crc32.ChecksumIEEE(data))
+	copy(s.mem[:4], sharedStateMagic[:])
+	return nil
+}
+
+func (s *SharedState) read() ([]byte, error) {
+	var magic [4]byte
+	copy(magic[:], s.mem[:4])
+	if magic == [4]byte{} {
+		return nil, nil
+	}
+	if magic != sharedStateMagic {
+		return nil, ErrCorrupt
+	}
+	n := binary.BigEndian.Uint32(s.mem[4:8])
+	if int(n) > s.Size() {
+		return nil, ErrCorrupt
+	}
+	data := append([]byte(nil), s.mem[sharedStateHeader:sharedStateHeader+int(n)]...)
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(s.mem[8:12]) {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
+
+// Close unmaps and closes the state file
+func (s *SharedState) Close() error {
+	err := syscall.Munmap(s.mem)
+	if cerr := s.fh.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestSharedState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	a, err := locking.OpenSharedState(path, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := locking.OpenSharedState(path, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err = a.Update(func(old []byte) ([]byte, error) {
+		if len(old) != 0 {
+			t.Errorf("new state is %q", old)
+		}
+		return []byte("hello"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Get(func(data []byte) error {
+		if string(data) != "hello" {
+			t.Errorf("got %q", data)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the payload behind the back of the state
+	fh, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.WriteAt([]byte("j"), 12)
+	fh.Close()
+	if err = b.Get(func([]byte) error { return nil }); err != locking.ErrCorrupt {
+		t.Errorf("wanted ErrCorrupt, got %v", err)
+	}
+}
+
+func TestSharedStateConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state, err := locking.OpenSharedState(filepath.Join(dir, "state"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	const goroutines, updates = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				if err := state.Update(func(old []byte) ([]byte, error) {
+					n, _ := strconv.Atoi(string(old))
+					return []byte(strconv.Itoa(n + 1)), nil
+				}); err != nil {
+					t.Error(err)
+					return
+				}
+				state.Get(func([]byte) error { return nil })
+			}
+		}()
+	}
+	wg.Wait()
+	state.Get(func(data []byte) error {
+		if string(data) != strconv.Itoa(goroutines*updates) {
+			t.Errorf("got %s updates, wanted %d", data, goroutines*updates)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// size of the shared lock file: a cache line
+const shmSize = 64
+
+// ShmLock is an inter-process mutex in a shared memory mapped file: the
+// owner's PID in a word, changed with atomic instructions, and waited on with
+// futex(2) on Linux. An uncontended TryLock and Unlock needs no syscall.
+//
+// A lock held by a dead process (its PID not running) is taken over by the
+// next locker. As PIDs are reused, this is a best effort fallback, not a
+// guarantee as with flock. The word holds the PID only, so the ownership is
+// tracked per handle too: the goroutines sharing a ShmLock share the
+// ownership, but another handle of the process cannot Unlock it.
+//
+// After Close the methods return os.ErrClosed; Close must not be called
+// concurrently with them.
+type ShmLock struct {
+	path string
+	data []byte
+	word *uint32 // owner PID, 0 if free
+	wait *uint32 // number of waiters
+	pid  uint32
+	held uint32 // 1 if held by this handle
+}
+
+// NewShmLock maps (creating if needed) the lock file at path
+func NewShmLock(path string) (*ShmLock, error) {
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	if fi, err := fh.Stat(); err != nil {
+		return nil, err
+	} else if fi.Size() < shmSize {
+		if err = fh.Truncate(shmSize); err != nil {
+			return nil, err
+		}
+	}
+	data, err := syscall.Mmap(int(fh.Fd()), 0, shmSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: path, Err: err}
+	}
+	return &ShmLock{path: path, data: data,
+		word: (*uint32)(unsafe.Pointer(&data[0])),
+		wait: (*uint32)(unsafe.Pointer(&data[4])),
+		pid:  uint32(os.Getpid()),
+	}, nil
+}
+
+// TryLock acquires the lock, non-blocking
+func (lock *ShmLock) TryLock() (bool, error) {
+	if lock.word == nil {
+		return false, os.ErrClosed
+	}
+	if atomic.CompareAndSwapUint32(lock.word, 0, lock.pid) || lock.takeOver(atomic.LoadUint32(lock.word)) {
+		atomic.StoreUint32(&lock.held, 1)
+		return true, nil
+	}
+	return false, nil
+}
+
+// takeOver acquires the lock from a dead owner
+func (lock *ShmLock) takeOver(owner uint32) bool {
+	return owner != 0 && owner != lock.pid &&
+		syscall.Kill(int(owner), 0) == syscall.ESRCH &&
+		atomic.CompareAndSwapUint32(lock.word, owner, lock.pid)
+}
+
+// Lock acquires the lock, blocking
+func (lock *ShmLock) Lock() error {
+	return lock.LockContext(context.Background())
+}
+
+// LockContext acquires the lock, until ctx is done
+func (lock *ShmLock) LockContext(ctx context.Context) error {
+	if lock.word == nil {
+		return os.ErrClosed
+	}
+	if atomic.CompareAndSwapUint32(lock.word, 0, lock.pid) {
+		atomic.StoreUint32(&lock.held, 1)
+		return nil
+	}
+	start := time.Now()
+	defer beginWait(lock.path)()
+	atomic.AddUint32(lock.wait, 1)
+	defer atomic.AddUint32(lock.wait, ^uint32(0))
+	for attempts := 2; ; attempts++ {
+		owner := atomic.LoadUint32(lock.word)
+		if (owner == 0 && atomic.CompareAndSwapUint32(lock.word, 0, lock.pid)) || lock.takeOver(owner) {
+			recordAcquisition(lock.path, attempts, 0, time.Since(start))
+			atomic.StoreUint32(&lock.held, 1)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if owner != 0 {
+			// wake up at times to check ctx and the owner
+			futexWait(lock.word, owner, 10*time.Millisecond)
+		}
+	}
+}
+
+// Unlock releases the lock held by this handle; ErrNotOwner if not held by it
+func (lock *ShmLock) Unlock() error {
+	if lock.word == nil {
+		return os.ErrClosed
+	}
+	if !atomic.CompareAndSwapUint32(&lock.held, 1, 0) || !atomic.CompareAndSwapUint32(lock.word, lock.pid, 0) {
+		return ErrNotOwner
+	}
+	if atomic.LoadUint32(lock.wait) != 0 {
+		futexWake(lock.word, 1)
+	}
+	return nil
+}
+
+// Close unmaps the lock file; the lock is not released
+func (lock *ShmLock) Close() error {
+	if lock.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(lock.data)
+	lock.data, lock.word, lock.wait = nil, nil, nil
+	return err
+}
+
+func (lock *ShmLock) String() string { return "shm:" + lock.path }
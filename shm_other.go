@@ -0,0 +1,21 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package locking
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// futex is available only on Linux: poll
+func futexWait(addr *uint32, val uint32, timeout time.Duration) {
+	for deadline := time.Now().Add(timeout); atomic.LoadUint32(addr) == val && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+func futexWake(addr *uint32, n int) {}
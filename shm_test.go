@@ -0,0 +1,110 @@
+package locking_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestShmLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shm")
+
+	a, err := locking.NewShmLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Unlock(); err != locking.ErrNotOwner {
+		t.Errorf("Unlock of a free lock: %v", err)
+	}
+
+	b, err := locking.NewShmLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("TryLock of a held lock: %t, %v", ok, err)
+	}
+	if err = b.Unlock(); err != locking.ErrNotOwner {
+		t.Fatalf("Unlock of another handle's hold: %v", err)
+	}
+	locked := make(chan error, 1)
+	go func() { locked <- b.Lock() }()
+	time.Sleep(20 * time.Millisecond)
+	released := time.Now()
+	a.Unlock()
+	select {
+	case err = <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("woke up in %s", time.Since(released))
+	case <-time.After(time.Second):
+		t.Fatal("Lock did not wake up")
+	}
+	b.Unlock()
+
+	// a lock held by a dead process is taken over
+	cmd := exec.Command("true")
+	if err = cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	word := make([]byte, 64)
+	binary.LittleEndian.PutUint32(word, uint32(cmd.Process.Pid))
+	if err = ioutil.WriteFile(path, word, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.TryLock(); !ok || err != nil {
+		t.Fatalf("TryLock of a dead owner's lock: %t, %v", ok, err)
+	}
+	a.Unlock()
+
+	c, err := locking.NewShmLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if ok, err := c.TryLock(); ok || err != os.ErrClosed {
+		t.Errorf("TryLock after Close: %t, %v", ok, err)
+	}
+	if err = c.Unlock(); err != os.ErrClosed {
+		t.Errorf("Unlock after Close: %v", err)
+	}
+}
+
+func BenchmarkShmLock(b *testing.B) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewShmLock(filepath.Join(dir, "shm"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer lock.Close()
+	for i := 0; i < b.N; i++ {
+		if ok, err := lock.TryLock(); !ok || err != nil {
+			b.Fatal(ok, err)
+		}
+		lock.Unlock()
+	}
+}
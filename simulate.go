@@ -0,0 +1,152 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Simulation models Processes contending for one polling lock with Backoff,
+// in simulated time: each process thinks for a while, then polls the lock
+// (TryLock is instant) until acquired or MaxWait is spent, and holds it.
+// The think and hold times are exponentially distributed, and at least
+// minSimStep, so the simulated time advances even with zero means.
+// Without Processes the result is empty.
+// The same Seed gives the same result, so backoff parameters can be compared.
+type Simulation struct {
+	Processes int
+	Backoff   Backoff
+	Hold      time.Duration // mean time the lock is held by an acquisition
+	Think     time.Duration // mean time between a release and the next want
+	Duration  time.Duration // simulated time
+	Seed      int64
+}
+
+// SimResult is the outcome of a Simulation
+type SimResult struct {
+	Acquisitions []int           // per process
+	Timeouts     int             // waits given up after MaxWait
+	Waits        []time.Duration // of the acquisitions, ascending
+	Attempts     int             // TryLock calls
+	Utilization  float64         // fraction of the time the lock was held
+	// Fairness is Jain's index of the acquisitions per process:
+	// 1 if all got the same number, 1/Processes if one got all.
+	Fairness float64
+}
+
+// Percentile returns the p-th (0-100) percentile of the waits
+func (r SimResult) Percentile(p int) time.Duration {
+	if len(r.Waits) == 0 {
+		return 0
+	}
+	i := len(r.Waits) * p / 100
+	if i >= len(r.Waits) {
+		i = len(r.Waits) - 1
+	}
+	return r.Waits[i]
+}
+
+// minSimStep is the shortest simulated think, hold and sleep time
+const minSimStep = time.Microsecond
+
+type simProcess struct {
+	next      time.Duration // time of the next event
+	waiting   bool
+	waitStart time.Duration
+	sleep     time.Duration // the next backoff sleep
+}
+
+// Run runs the simulation
+func (s Simulation) Run() SimResult {
+	if s.Processes <= 0 {
+		return SimResult{}
+	}
+	rnd := rand.New(rand.NewSource(s.Seed))
+	res := SimResult{Acquisitions: make([]int, s.Processes)}
+	exp := func(mean time.Duration) time.Duration {
+		if d := time.Duration(rnd.ExpFloat64() * float64(mean)); d > minSimStep {
+			return d
+		}
+		return minSimStep
+	}
+	procs := make([]simProcess, s.Processes)
+	for i := range procs {
+		procs[i].next = exp(s.Think)
+	}
+	initial := s.Backoff.Initial
+	if initial <= 0 {
+		initial = time.Second
+	}
+	holder, releaseAt := -1, time.Duration(0)
+	var held time.Duration
+	for {
+		// the next event: the release, or the earliest process (lowest index on ties)
+		now, who := time.Duration(-1), -1
+		if holder >= 0 {
+			now = releaseAt
+		}
+		for i, p := range procs {
+			if i != holder && (now < 0 || p.next < now) {
+				now, who = p.next, i
+			}
+		}
+		if now < 0 || now > s.Duration {
+			break
+		}
+		if who < 0 {
+			procs[holder].next = now + exp(s.Think)
+			holder = -1
+			continue
+		}
+		p := &procs[who]
+		if !p.waiting {
+			p.waiting, p.waitStart, p.sleep = true, now, initial
+		}
+		res.Attempts++
+		if holder < 0 {
+			holder, releaseAt = who, now+exp(s.Hold)
+			if releaseAt <= s.Duration {
+				held += releaseAt - now
+			} else {
+				held += s.Duration - now
+			}
+			res.Acquisitions[who]++
+			res.Waits = append(res.Waits, now-p.waitStart)
+			p.waiting = false
+			p.next = releaseAt // not scheduled while holding
+			continue
+		}
+		d := p.sleep
+		if mw := s.Backoff.MaxWait; mw > 0 {
+			if left := p.waitStart + mw - now; left <= 0 {
+				res.Timeouts++
+				p.waiting, p.next = false, now+exp(s.Think)
+				continue
+			} else if d > left {
+				d = left
+			}
+		}
+		if d < minSimStep {
+			d = minSimStep
+		}
+		p.next = now + d
+		p.sleep = s.Backoff.next(p.sleep, rnd.Float64())
+	}
+	if s.Duration > 0 {
+		res.Utilization = float64(held) / float64(s.Duration)
+	}
+	sort.Slice(res.Waits, func(i, j int) bool { return res.Waits[i] < res.Waits[j] })
+	var sum, sumSq float64
+	for _, n := range res.Acquisitions {
+		sum += float64(n)
+		sumSq += float64(n) * float64(n)
+	}
+	if sumSq > 0 {
+		res.Fairness = sum * sum / (float64(s.Processes) * sumSq)
+	}
+	return res
+}
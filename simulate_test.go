@@ -0,0 +1,65 @@
+package locking_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestSimulation(t *testing.T) {
+	sim := locking.Simulation{
+		Processes: 8,
+		Backoff:   locking.Backoff{Initial: time.Millisecond, Multiplier: 2, Max: 50 * time.Millisecond},
+		Hold:      5 * time.Millisecond,
+		Think:     20 * time.Millisecond,
+		Duration:  time.Minute,
+		Seed:      1,
+	}
+	res := sim.Run()
+	if again := sim.Run(); !reflect.DeepEqual(res, again) {
+		t.Fatal("the same seed gave different results")
+	}
+	var n int
+	for _, a := range res.Acquisitions {
+		n += a
+	}
+	if n == 0 || n != len(res.Waits) || res.Attempts < n {
+		t.Fatalf("%d acquisitions, %d waits, %d attempts", n, len(res.Waits), res.Attempts)
+	}
+	if res.Fairness < 0.9 || res.Fairness > 1 || res.Utilization <= 0 || res.Utilization > 1 {
+		t.Errorf("fairness %.3f, utilization %.3f", res.Fairness, res.Utilization)
+	}
+	t.Logf("fairness %.3f utilization %.3f p50 %s p95 %s", res.Fairness, res.Utilization, res.Percentile(50), res.Percentile(95))
+
+	// an uncapped backoff starves some waiters
+	uncapped := sim
+	uncapped.Backoff.Max = 0
+	if u := uncapped.Run(); u.Percentile(100) < res.Percentile(100) || u.Fairness > res.Fairness {
+		t.Errorf("uncapped: max wait %s, fairness %.3f; capped: %s, %.3f",
+			u.Percentile(100), u.Fairness, res.Percentile(100), res.Fairness)
+	}
+
+	// MaxWait gives up
+	sim.Backoff.MaxWait = 5 * time.Millisecond
+	if res = sim.Run(); res.Timeouts == 0 {
+		t.Error("no timeouts with a MaxWait of the Hold")
+	}
+
+	// alone, no waits
+	sim.Processes = 1
+	if res = sim.Run(); res.Percentile(100) != 0 || res.Fairness != 1 {
+		t.Errorf("single process: max wait %s, fairness %.3f", res.Percentile(100), res.Fairness)
+	}
+
+	// zero times terminate too
+	zero := locking.Simulation{Processes: 2, Duration: 10 * time.Millisecond}
+	if res = zero.Run(); res.Attempts == 0 {
+		t.Error("no attempts with zero times")
+	}
+	zero.Processes = 0
+	if res = zero.Run(); res.Attempts != 0 || len(res.Acquisitions) != 0 {
+		t.Errorf("without processes: %+v", res)
+	}
+}
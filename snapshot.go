@@ -0,0 +1,56 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Snapshot publishes a point-in-time copy of path at dst.
+//
+// The copy is taken under a shared flock of path, so it is consistent
+// with respect to writers holding the exclusive FLock of path; readers can
+// then work on dst while the writers go on.
+// Where the filesystem supports it, the copy is a copy-on-write clone (reflink),
+// otherwise a full copy. It is written to a temporary file, and renamed
+// to dst, so dst is never seen half-written.
+func Snapshot(path, dst string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err = syscall.Flock(int(src.Fd()), syscall.LOCK_SH); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(src.Fd()), syscall.LOCK_UN)
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails after the rename
+	if err = reflink(tmp, src); err != nil {
+		if _, err = io.Copy(tmp, src); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Chmod(fi.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,48 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, dst := filepath.Join(dir, "data"), filepath.Join(dir, "snap")
+	if err = ioutil.WriteFile(path, []byte("v1"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := locking.NewFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- locking.Snapshot(path, dst) }()
+	select {
+	case err = <-done:
+		t.Fatalf("Snapshot did not wait for the writer: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	ioutil.WriteFile(path, []byte("v2"), 0640)
+	writer.Unlock()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(path, []byte("v3"), 0640)
+	if b, err := ioutil.ReadFile(dst); err != nil || string(b) != "v2" {
+		t.Errorf("snapshot is %q, %v; wanted v2", b, err)
+	}
+}
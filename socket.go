@@ -0,0 +1,116 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// SocketLock is a locker which binds a Unix domain socket: a lighter
+// alternative to PortLock, namespaced by the filesystem permissions of its
+// path - or, with a name starting with "@", an abstract socket (Linux only)
+// which leaves no file behind.
+//
+// The socket file left by a crashed holder is detected (connection refused)
+// and removed under a guard flock, so it cannot block later holders.
+type SocketLock struct {
+	addr string
+	ln   net.Listener
+}
+
+// NewSocketLock returns a lock for the socket at path, or the abstract socket @name
+func NewSocketLock(addr string) *SocketLock {
+	return &SocketLock{addr: addr}
+}
+
+// Lock locks the socket, blocking
+func (s *SocketLock) Lock() error {
+	return s.LockContext(context.Background())
+}
+
+// LockContext locks the socket, until ctx is done
+func (s *SocketLock) LockContext(ctx context.Context) error {
+	eb := newExpBackoff(s.addr)
+	defer beginWait(s.addr)()
+	for {
+		ok, err := s.TryLock()
+		if ok {
+			eb.Done()
+			return err
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// TryLock acquires the lock, non-blocking
+func (s *SocketLock) TryLock() (bool, error) {
+	ok, err := s.listen()
+	if ok || err != nil || s.abstract() {
+		return ok, err
+	}
+	// the socket file exists: is anybody listening?
+	guard, err := os.OpenFile(s.addr+".guard", os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer guard.Close()
+	if err = syscall.Flock(int(guard.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK { // another locker is checking it
+			return false, nil
+		}
+		return false, err
+	}
+	defer syscall.Flock(int(guard.Fd()), syscall.LOCK_UN)
+	conn, err := net.Dial("unix", s.addr)
+	switch {
+	case err == nil:
+		conn.Close()
+		return false, nil
+	case errors.Is(err, syscall.EAGAIN): // listening, with a full backlog
+		return false, nil
+	case errors.Is(err, syscall.ENOENT): // removed meanwhile
+	case errors.Is(err, syscall.ECONNREFUSED):
+		if err = os.Remove(s.addr); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	default:
+		return false, err
+	}
+	return s.listen()
+}
+
+func (s *SocketLock) listen() (bool, error) {
+	ln, err := net.Listen("unix", s.addr)
+	if err == nil {
+		s.ln = ln
+		return true, nil
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *SocketLock) abstract() bool { return strings.HasPrefix(s.addr, "@") }
+
+// Unlock unlocks the socket lock (and removes its file)
+func (s *SocketLock) Unlock() error {
+	if s.ln == nil {
+		return nil
+	}
+	err := s.ln.Close()
+	s.ln = nil
+	return err
+}
@@ -0,0 +1,68 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestSocketLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sock")
+
+	a, b := locking.NewSocketLock(path), locking.NewSocketLock(path)
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("b: %t, %v", ok, err)
+	}
+	a.Unlock()
+
+	// a crashed holder leaves the socket file behind
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if _, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	// not waiting for another locker checking it
+	guard, err := locking.NewFLock(path + ".guard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = guard.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("stale socket with the guard held: %t, %v", ok, err)
+	}
+	guard.Close()
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("stale socket: %t, %v", ok, err)
+	}
+	b.Unlock()
+
+	if runtime.GOOS == "linux" {
+		abstract := locking.NewSocketLock("@golocking-test-" + strconv.Itoa(os.Getpid()))
+		if err = testLock(abstract); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SoftLock records who claims to be working on what, without enforcing
+// anything: for warnings like "alice is editing this config" where hard
+// mutual exclusion is undesirable.
+//
+// Claims are files under a directory, one per resource and owner; expired
+// claims are ignored and removed when listed.
+type SoftLock struct {
+	dir string
+}
+
+// Claim is one advisory claim on a resource
+type Claim struct {
+	Resource string    `json:"resource"`
+	Owner    string    `json:"owner"`
+	Note     string    `json:"note,omitempty"`
+	Since    time.Time `json:"since"`
+	Expires  time.Time `json:"expires"`
+}
+
+// NewSoftLock returns a SoftLock storing its claims under dir (created if needed)
+func NewSoftLock(dir string) (*SoftLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &SoftLock{dir: dir}, nil
+}
+
+func (s *SoftLock) path(resource, owner string) string {
+	return filepath.Join(s.dir, url.PathEscape(resource), url.PathEscape(owner)+".json")
+}
+
+// Claim records (or renews) owner's claim on resource for ttl, and returns
+// the live claims of others on the same resource.
+func (s *SoftLock) Claim(resource, owner, note string, ttl time.Duration) ([]Claim, error) {
+	path := s.path(resource, owner)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c := Claim{Resource: resource, Owner: owner, Note: note, Since: now, Expires: now.Add(ttl)}
+	if old, err := readClaim(path); err == nil && old.Expires.After(now) {
+		c.Since = old.Since
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	claims, err := s.List(resource)
+	others := claims[:0]
+	for _, c := range claims {
+		if c.Owner != owner {
+			others = append(others, c)
+		}
+	}
+	return others, err
+}
+
+// Release drops owner's claim on resource
+func (s *SoftLock) Release(resource, owner string) error {
+	err := os.Remove(s.path(resource, owner))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns the live claims on resource, or on all resources if it is empty,
+// ordered by resource and start time.
+func (s *SoftLock) List(resource string) ([]Claim, error) {
+	pattern := filepath.Join(s.dir, "*", "*.json")
+	if resource != "" {
+		pattern = filepath.Join(s.dir, url.PathEscape(resource), "*.json")
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var claims []Claim
+	for _, path := range paths {
+		c, err := readClaim(path)
+		if err != nil {
+			continue // being replaced, or garbage
+		}
+		if !c.Expires.After(now) {
+			os.Remove(path)
+			continue
+		}
+		claims = append(claims, c)
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		if claims[i].Resource != claims[j].Resource {
+			return claims[i].Resource < claims[j].Resource
+		}
+		return claims[i].Since.Before(claims[j].Since)
+	})
+	return claims, nil
+}
+
+func readClaim(path string) (Claim, error) {
+	var c Claim
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
@@ -0,0 +1,49 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestSoftLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sl, err := locking.NewSoftLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if others, err := sl.Claim("etc/app.conf", "alice", "editing", time.Minute); err != nil || len(others) != 0 {
+		t.Fatalf("alice: %v, %v", others, err)
+	}
+	others, err := sl.Claim("etc/app.conf", "bob", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(others) != 1 || others[0].Owner != "alice" || others[0].Note != "editing" {
+		t.Errorf("bob should see alice's claim, got %v", others)
+	}
+
+	if _, err = sl.Claim("other", "carol", "", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	all, err := sl.List("")
+	if err != nil || len(all) != 2 {
+		t.Errorf("expired claim listed: %v, %v", all, err)
+	}
+
+	if err = sl.Release("etc/app.conf", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if claims, _ := sl.List("etc/app.conf"); len(claims) != 1 || claims[0].Owner != "bob" {
+		t.Errorf("after release: %v", claims)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LockSpec describes a lock in a configuration file (JSON or YAML):
+//
+//	{"backend": "dir", "target": "/var/lock/batch", "strict": true,
+//	 "window": {"start": "02:00", "end": "05:00"},
+//	 "breaker": {"threshold": 3, "cooldown": "1m"}}
+type LockSpec struct {
+	// Backend is a scheme of Open (file, dir, port, unix, pidfile, fcntl, ofd, mem
+	// or a registered one), or "lease" for a LeaseLock
+	Backend string `json:"backend" yaml:"backend"`
+	// Target is the path, port, address or name of the lock
+	Target string `json:"target" yaml:"target"`
+	// TTL is the lease TTL of the "lease" backend, the hold limit of "mem"
+	TTL Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	// Strict makes Build fail if Strict finds the lock unsafe
+	Strict bool `json:"strict,omitempty" yaml:"strict,omitempty"`
+
+	Window  *WindowSpec  `json:"window,omitempty" yaml:"window,omitempty"`
+	Breaker *BreakerSpec `json:"breaker,omitempty" yaml:"breaker,omitempty"`
+	Backoff *BackoffSpec `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+}
+
+// WindowSpec configures a Window; Start and End are "15:04" times
+type WindowSpec struct {
+	Start    string `json:"start" yaml:"start"`
+	End      string `json:"end" yaml:"end"`
+	Location string `json:"location,omitempty" yaml:"location,omitempty"` // IANA zone, local time if empty
+}
+
+// BreakerSpec configures a Breaker
+type BreakerSpec struct {
+	Threshold int      `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+	Cooldown  Duration `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+}
+
+// BackoffSpec configures a Backoff
+type BackoffSpec struct {
+	Initial    Duration `json:"initial,omitempty" yaml:"initial,omitempty"`
+	Multiplier float64  `json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	Max        Duration `json:"max,omitempty" yaml:"max,omitempty"`
+	MaxWait    Duration `json:"max_wait,omitempty" yaml:"max_wait,omitempty"`
+}
+
+func (b BackoffSpec) backoff() Backoff {
+	return Backoff{Initial: time.Duration(b.Initial), Multiplier: b.Multiplier,
+		Max: time.Duration(b.Max), MaxWait: time.Duration(b.MaxWait)}
+}
+
+// merge returns into with the parts set in b
+func (b BackoffSpec) merge(into Backoff) Backoff {
+	if b.Initial != 0 {
+		into.Initial = time.Duration(b.Initial)
+	}
+	if b.Multiplier != 0 {
+		into.Multiplier = b.Multiplier
+	}
+	if b.Max != 0 {
+		into.Max = time.Duration(b.Max)
+	}
+	if b.MaxWait != 0 {
+		into.MaxWait = time.Duration(b.MaxWait)
+	}
+	return into
+}
+
+// Duration is a time.Duration written as "1m30s" in configuration files
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	*d = Duration(v)
+	return err
+}
+
+// Build validates the spec, and returns its lock, wrapped with the policies:
+// the Backoff polls the lock through the Window, then the Breaker.
+func (s LockSpec) Build() (Locker, error) {
+	if s.Backend == "" || s.Target == "" {
+		return nil, errors.New("lock spec needs backend and target")
+	}
+	var lock Locker
+	var err error
+	if s.Backend == "lease" {
+		if s.TTL <= 0 {
+			return nil, errors.New("lease lock spec needs a ttl")
+		}
+		lock = NewLeaseLock(s.Target, time.Duration(s.TTL))
+	} else if s.Backend == "mem" {
+		lock = NewMemLock(s.Target, time.Duration(s.TTL))
+	} else if lock, err = Open(s.Backend + ":" + s.Target); err != nil {
+		return nil, err
+	}
+	if s.Strict {
+		if err = Strict(lock); err != nil {
+			return nil, err
+		}
+	}
+	if s.Breaker != nil {
+		lock = (&Breaker{Threshold: s.Breaker.Threshold, Cooldown: time.Duration(s.Breaker.Cooldown)}).Wrap(lock)
+	}
+	if s.Window != nil {
+		w := &Window{}
+		if w.Start, err = clockTime(s.Window.Start); err != nil {
+			return nil, err
+		}
+		if w.End, err = clockTime(s.Window.End); err != nil {
+			return nil, err
+		}
+		if s.Window.Location != "" {
+			if w.Location, err = time.LoadLocation(s.Window.Location); err != nil {
+				return nil, err
+			}
+		}
+		lock = w.Wrap(lock)
+	}
+	if s.Backoff != nil {
+		lock = s.Backoff.backoff().Wrap(lock)
+	}
+	return lock, nil
+}
+
+// clockTime returns the offset of the "15:04" time from midnight
+func clockTime(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("window time %q: %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
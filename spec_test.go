@@ -0,0 +1,54 @@
+package locking_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestLockSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var spec locking.LockSpec
+	if err = json.Unmarshal([]byte(`{"backend": "dir", "target": "`+dir+`",
+		"window": {"start": "00:00", "end": "00:00"},
+		"breaker": {"threshold": 3, "cooldown": "1m30s"},
+		"backoff": {"initial": "10ms", "max_wait": "50ms"}}`), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(spec.Breaker.Cooldown) != 90*time.Second {
+		t.Errorf("cooldown=%s", time.Duration(spec.Breaker.Cooldown))
+	}
+	lock, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := spec.Build(); other.Lock() != locking.ErrTimeout {
+		t.Error("backoff max_wait not applied")
+	}
+	lock.Unlock()
+
+	for _, bad := range []locking.LockSpec{
+		{Backend: "dir"},
+		{Backend: "lease", Target: dir},
+		{Backend: "dir", Target: dir, Window: &locking.WindowSpec{Start: "2am"}},
+	} {
+		if _, err := bad.Build(); err == nil {
+			t.Errorf("%+v: no error", bad)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package sqllock implements locks on database advisory locks
+// (PostgreSQL pg_advisory_lock, MySQL GET_LOCK, MSSQL sp_getapplock).
+//
+// These locks belong to the database session, so each Lock pins a
+// dedicated connection from the *sql.DB pool for as long as it is held,
+// health-checks it, and reports (or repairs) its loss.
+//
+// Importing the package registers the pg scheme of locking.Open:
+// pg://user:password@host:port/db?sslmode=disable#key, with an int64 key,
+// on a pool of the PostgreSQL driver registered as "postgres" (lib/pq) or
+// "pgx" - the application imports the driver.
+package sqllock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+// Dialect holds the statements of a database's advisory locks.
+// Each statement gets the key as its only argument, and returns one boolean row.
+type Dialect struct {
+	Lock, TryLock, Unlock string
+	// ReadOnly has no arguments, and returns true if the connection is to a
+	// read-only replica, where the advisory locks would not exclude the
+	// sessions of the primary. Empty skips the check.
+	ReadOnly string
+	// Probe gets the key, and returns true if the lock is held by any
+	// session, without acquiring it. Empty disables Lock.Probe.
+	Probe string
+}
+
+var (
+	// Postgres uses session-level advisory locks, with an int64 key
+	Postgres = Dialect{
+		Lock:     "SELECT true FROM pg_advisory_lock($1)",
+		TryLock:  "SELECT pg_try_advisory_lock($1)",
+		Unlock:   "SELECT pg_advisory_unlock($1)",
+		ReadOnly: "SELECT pg_is_in_recovery()",
+		// the int64 key is split into classid and objid
+		Probe: "SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory' AND granted" +
+			" AND objsubid = 1 AND (classid::bigint << 32 | objid::bigint) = $1)",
+	}
+	// MySQL uses named locks, with a string key
+	MySQL = Dialect{
+		Lock:     "SELECT GET_LOCK(?, -1)",
+		TryLock:  "SELECT GET_LOCK(?, 0)",
+		Unlock:   "SELECT RELEASE_LOCK(?)",
+		ReadOnly: "SELECT @@global.read_only",
+		Probe:    "SELECT IS_USED_LOCK(?) IS NOT NULL",
+	}
+	// MSSQL uses session-owned application locks, with a string key
+	MSSQL = Dialect{
+		Lock:    mssqlGetAppLock("Session", -1),
+		TryLock: mssqlGetAppLock("Session", 0),
+		Unlock: "DECLARE @r int; EXEC @r = sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'; " +
+			"SELECT CASE WHEN @r >= 0 THEN 1 ELSE 0 END",
+		ReadOnly: "SELECT CASE WHEN DATABASEPROPERTYEX(DB_NAME(), 'Updateability') = 'READ_ONLY' THEN 1 ELSE 0 END",
+		Probe:    "SELECT CASE WHEN APPLOCK_TEST('public', @p1, 'Exclusive', 'Session') = 0 THEN 1 ELSE 0 END",
+	}
+)
+
+func mssqlGetAppLock(owner string, timeout int) string {
+	return "DECLARE @r int; EXEC @r = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', " +
+		"@LockOwner = '" + owner + "', @LockTimeout = " + strconv.Itoa(timeout) + "; " +
+		"SELECT CASE WHEN @r >= 0 THEN 1 ELSE 0 END"
+}
+
+var (
+	// ErrNotHeld is returned by Unlock when the database says the lock was not held
+	ErrNotHeld = errors.New("lock not held")
+	// ErrLost is returned by Unlock when the connection holding the lock was lost
+	ErrLost = errors.New("connection holding the lock was lost")
+	// ErrReadOnly is returned when the connection landed on a read-only replica
+	ErrReadOnly = errors.New("connected to a read-only replica")
+	// ErrNoProbe is returned by Probe when the Dialect has no Probe statement
+	ErrNoProbe = errors.New("dialect cannot probe")
+)
+
+// Policy tells what to do when the connection holding the lock is lost
+type Policy int
+
+const (
+	// NotifyLoss closes the Lost channel
+	NotifyLoss = Policy(iota)
+	// Reacquire tries to get the lock again on a new connection,
+	// and closes the Lost channel only if that fails
+	Reacquire
+)
+
+var _ locking.DistLocker = (*Lock)(nil)
+
+// Lock is a database advisory lock
+type Lock struct {
+	// HealthInterval is the period of the connection health check while
+	// held, defaults to 10s
+	HealthInterval time.Duration
+	// Policy on connection loss
+	Policy Policy
+	// ProbeDB is the pool of the Probe connections, the pool of the lock if
+	// nil: a small separate pool keeps the health checks from waiting for
+	// (or using up) the connections of the holders.
+	ProbeDB *sql.DB
+
+	db      *sql.DB
+	dialect Dialect
+	key     interface{}
+
+	mu   sync.Mutex
+	conn *sql.Conn
+	lost chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns an (unlocked) advisory lock for key
+func New(db *sql.DB, dialect Dialect, key interface{}) *Lock {
+	return &Lock{db: db, dialect: dialect, key: key}
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[string]*sql.DB) // of the pg URIs, by DSN
+)
+
+func init() { locking.RegisterScheme("pg", openPostgres) }
+
+// openPostgres returns the Postgres lock of a pg URI. The locks of the same
+// DSN share a pool.
+func openPostgres(u *url.URL) (locking.Locker, error) {
+	key, err := strconv.ParseInt(u.Fragment, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("pg lock URI needs an int64 key as fragment: %w", err)
+	}
+	dsn := *u
+	dsn.Scheme, dsn.Fragment, dsn.RawFragment = "postgres", "", ""
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	db := pools[dsn.String()]
+	if db == nil {
+		driver := ""
+		for _, d := range sql.Drivers() {
+			if d == "postgres" || d == "pgx" && driver == "" {
+				driver = d
+			}
+		}
+		if driver == "" {
+			return nil, errors.New("no PostgreSQL driver registered (postgres or pgx)")
+		}
+		if db, err = sql.Open(driver, dsn.String()); err != nil {
+			return nil, err
+		}
+		pools[dsn.String()] = db
+	}
+	return New(db, Postgres, key), nil
+}
+
+// Lock acquires the lock, blocking
+func (l *Lock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockContext acquires the lock, blocking until ctx is done
+func (l *Lock) LockContext(ctx context.Context) error {
+	ok, err := l.acquire(ctx, l.dialect.Lock)
+	if err == nil && !ok {
+		err = errors.New("lock was not granted")
+	}
+	return err
+}
+
+// TryLock acquires the lock, non-blocking
+func (l *Lock) TryLock() (bool, error) {
+	return l.acquire(context.Background(), l.dialect.TryLock)
+}
+
+func (l *Lock) acquire(ctx context.Context, qry string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		return false, errors.New("lock already held by this Lock")
+	}
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	ok, err := l.grab(ctx, conn, qry)
+	if err != nil {
+		discard(conn)
+		return false, err
+	}
+	if !ok {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	l.lost = make(chan struct{})
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.watch(conn, l.lost, l.stop, l.done)
+	return true, nil
+}
+
+// Lost returns a channel which is closed when the lock is lost
+// because its connection is broken. It is nil when not held.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Probe reports whether the lock is held, by any session (this Lock's too),
+// for health checks: it queries on a connection of its own (from ProbeDB),
+// never on the pinned connection of the holder, so it cannot disturb the
+// session-scoped lock.
+func (l *Lock) Probe() (bool, error) {
+	return l.ProbeContext(context.Background())
+}
+
+// ProbeContext is Probe, until ctx is done
+func (l *Lock) ProbeContext(ctx context.Context) (bool, error) {
+	if l.dialect.Probe == "" {
+		return false, ErrNoProbe
+	}
+	db := l.ProbeDB
+	if db == nil {
+		db = l.db
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	// a replica does not see the locks of the primary
+	if l.dialect.ReadOnly != "" {
+		if ro, err := query(ctx, conn, l.dialect.ReadOnly); err != nil {
+			return false, err
+		} else if ro {
+			return false, ErrReadOnly
+		}
+	}
+	return query(ctx, conn, l.dialect.Probe, l.key)
+}
+
+// Unlock releases the lock and returns its connection to the pool - or
+// discards it, if the lock may still be held by its session.
+// It returns ErrLost if the lock has been lost meanwhile.
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	if l.stop == nil {
+		l.mu.Unlock()
+		return nil
+	}
+	close(l.stop)
+	done := l.done
+	l.mu.Unlock()
+	<-done // the watcher may be replacing the connection
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	conn, lost := l.conn, l.lost
+	l.conn, l.lost, l.stop, l.done = nil, nil, nil, nil
+	if conn == nil {
+		return ErrLost
+	}
+	select {
+	case <-lost:
+		discard(conn)
+		return ErrLost
+	default:
+	}
+	ok, err := query(context.Background(), conn, l.dialect.Unlock, l.key)
+	if err != nil || !ok {
+		discard(conn)
+	} else {
+		conn.Close()
+	}
+	if err == nil && !ok {
+		err = ErrNotHeld
+	}
+	return err
+}
+
+// watch health-checks conn until stop is closed
+func (l *Lock) watch(conn *sql.Conn, lost, stop, done chan struct{}) {
+	defer close(done)
+	interval := l.HealthInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := conn.PingContext(ctx)
+		cancel()
+		if err == nil {
+			continue
+		}
+		discard(conn)
+		if l.Policy == Reacquire {
+			if c, err := l.db.Conn(context.Background()); err == nil {
+				if ok, _ := l.grab(context.Background(), c, l.dialect.TryLock); ok {
+					l.mu.Lock()
+					l.conn, conn = c, c
+					l.mu.Unlock()
+					continue
+				}
+				discard(c)
+			}
+		}
+		l.mu.Lock()
+		l.conn = nil
+		l.mu.Unlock()
+		close(lost)
+		return
+	}
+}
+
+// grab runs the locking qry on conn, if it is not connected to a replica
+func (l *Lock) grab(ctx context.Context, conn *sql.Conn, qry string) (bool, error) {
+	if l.dialect.ReadOnly != "" {
+		if ro, err := query(ctx, conn, l.dialect.ReadOnly); err != nil {
+			return false, err
+		} else if ro {
+			return false, ErrReadOnly
+		}
+	}
+	return query(ctx, conn, qry, l.key)
+}
+
+// discard closes conn without returning it to the pool: its session may
+// still hold the lock, which would pass to the next user of the connection
+func discard(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+func query(ctx context.Context, conn *sql.Conn, qry string, args ...interface{}) (bool, error) {
+	var ok sql.NullBool
+	if err := conn.QueryRowContext(ctx, qry, args...).Scan(&ok); err != nil {
+		return false, err
+	}
+	return ok.Valid && ok.Bool, nil
+}
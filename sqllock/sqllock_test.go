@@ -0,0 +1,331 @@
+package sqllock_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+	"github.com/tgulacsi/go-locking/sqllock"
+)
+
+var fakeDialect = sqllock.Dialect{Lock: "lock", TryLock: "trylock", Unlock: "unlock", Probe: "probe"}
+
+func TestTryLock(t *testing.T) {
+	db := openFake(t)
+	a := sqllock.New(db, fakeDialect, 1)
+	b := sqllock.New(db, fakeDialect, 1)
+	if ok, err := a.TryLock(); err != nil || !ok {
+		t.Fatalf("a: %t, %v", ok, err)
+	}
+	if ok, err := b.TryLock(); err != nil || ok {
+		t.Fatalf("b: %t, %v", ok, err)
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLost(t *testing.T) {
+	db := openFake(t)
+	a := sqllock.New(db, fakeDialect, 2)
+	a.HealthInterval = 10 * time.Millisecond
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	breakHolder(2)
+	select {
+	case <-a.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("loss not notified")
+	}
+	if err := a.Unlock(); err != sqllock.ErrLost {
+		t.Errorf("wanted ErrLost, got %v", err)
+	}
+}
+
+func TestLostDiscarded(t *testing.T) {
+	db := openFake(t)
+	a := sqllock.New(db, fakeDialect, 7)
+	a.HealthInterval = 10 * time.Millisecond
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	stallHolder(7)
+	select {
+	case <-a.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("loss not notified")
+	}
+	// the session of the lost lock is closed, not pooled with the lock held
+	if ok, err := sqllock.New(db, fakeDialect, 7).Probe(); ok || err != nil {
+		t.Errorf("the lost lock is still held: %t, %v", ok, err)
+	}
+	a.Unlock()
+}
+
+func TestReacquire(t *testing.T) {
+	db := openFake(t)
+	a := sqllock.New(db, fakeDialect, 3)
+	a.HealthInterval = 10 * time.Millisecond
+	a.Policy = sqllock.Reacquire
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	lost := a.Lost()
+	breakHolder(3)
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-lost:
+		t.Fatal("lock lost")
+	default:
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	db := openFake(t)
+	replica := fakeDialect
+	replica.ReadOnly = "replica"
+	if ok, err := sqllock.New(db, replica, 5).TryLock(); ok || err != sqllock.ErrReadOnly {
+		t.Errorf("got %t, %v; wanted ErrReadOnly", ok, err)
+	}
+	primary := fakeDialect
+	primary.ReadOnly = "primary"
+	lock := sqllock.New(db, primary, 5)
+	if err := lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	db := openFake(t)
+	db.SetMaxOpenConns(1) // pinned by the holder
+	lock := sqllock.New(db, fakeDialect, 6)
+	lock.ProbeDB = openFake(t)
+	if held, err := lock.Probe(); held || err != nil {
+		t.Fatalf("free lock: %t, %v", held, err)
+	}
+	if err := lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if held, err := lock.Probe(); !held || err != nil {
+			t.Fatalf("held lock: %t, %v", held, err)
+		}
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := lock.Probe(); held || err != nil {
+		t.Errorf("released lock: %t, %v", held, err)
+	}
+	if _, err := sqllock.New(db, sqllock.Dialect{}, 6).Probe(); err != sqllock.ErrNoProbe {
+		t.Errorf("got %v, wanted ErrNoProbe", err)
+	}
+}
+
+func TestLockTx(t *testing.T) {
+	db := openFake(t)
+	txDialect := sqllock.TxDialect{Lock: "xlock", TryLock: "xtrylock"}
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sqllock.LockTx(ctx, tx, txDialect, 4); err != nil {
+		t.Fatal(err)
+	}
+	other := sqllock.New(db, fakeDialect, 4)
+	if ok, _ := other.TryLock(); ok {
+		t.Fatal("lock is not held by the transaction")
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := other.TryLock(); err != nil || !ok {
+		t.Fatalf("lock not released by commit: %t, %v", ok, err)
+	}
+	other.Unlock()
+}
+
+// fake driver: in-memory advisory locks owned by connections
+
+var (
+	fakeMu   sync.Mutex
+	fakeHeld = make(map[int64]*fakeConn)
+	once     sync.Once
+	pgxOnce  sync.Once
+)
+
+func TestOpen(t *testing.T) {
+	pgxOnce.Do(func() {
+		if _, err := locking.Open("pg://localhost/db#1"); err == nil {
+			t.Fatal("opened without a driver")
+		}
+		sql.Register("pgx", fakeDriver{})
+	})
+	if _, err := locking.Open("pg://localhost/db#key"); err == nil {
+		t.Error("opened with a bad key")
+	}
+	lock, err := locking.Open("pg://localhost/db?sslmode=disable#1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lock.(*sqllock.Lock); !ok {
+		t.Errorf("got %T", lock)
+	}
+}
+
+func openFake(t *testing.T) *sql.DB {
+	once.Do(func() { sql.Register("fakelock", fakeDriver{}) })
+	db, err := sql.Open("fakelock", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func breakHolder(key int64) {
+	fakeMu.Lock()
+	fakeHeld[key].broken = true
+	fakeMu.Unlock()
+}
+
+// stallHolder makes the health checks of the holder of key fail, without
+// marking its connection bad
+func stallHolder(key int64) {
+	fakeMu.Lock()
+	fakeHeld[key].stalled = true
+	fakeMu.Unlock()
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{}, nil }
+
+type fakeConn struct {
+	broken  bool
+	stalled bool
+	xact    []int64
+}
+
+type fakeTx struct{ conn *fakeConn }
+
+func (tx fakeTx) Commit() error { return tx.Rollback() }
+func (tx fakeTx) Rollback() error {
+	fakeMu.Lock()
+	for _, k := range tx.conn.xact {
+		delete(fakeHeld, k)
+	}
+	tx.conn.xact = nil
+	fakeMu.Unlock()
+	return nil
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{c}, nil }
+func (c *fakeConn) Close() error {
+	fakeMu.Lock()
+	for k, h := range fakeHeld {
+		if h == c {
+			delete(fakeHeld, k)
+		}
+	}
+	fakeMu.Unlock()
+	return nil
+}
+func (c *fakeConn) Ping(ctx context.Context) error {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	if c.broken {
+		return driver.ErrBadConn
+	}
+	if c.stalled {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s fakeStmt) Close() error { return nil }
+func (s fakeStmt) NumInput() int {
+	if s.query == "replica" || s.query == "primary" {
+		return 0
+	}
+	return 1
+}
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return nil, errors.New("no exec") }
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if len(args) == 0 { // read-only check
+		return &fakeRows{value: s.query == "replica"}, nil
+	}
+	key := args[0].(int64)
+	for {
+		fakeMu.Lock()
+		if s.conn.broken {
+			fakeMu.Unlock()
+			return nil, driver.ErrBadConn
+		}
+		h := fakeHeld[key]
+		var ok bool
+		switch s.query {
+		case "probe":
+			ok = h != nil
+		case "unlock":
+			if ok = h == s.conn; ok {
+				delete(fakeHeld, key)
+			}
+		default:
+			if ok = h == nil || h == s.conn; ok {
+				fakeHeld[key] = s.conn
+				if s.query[0] == 'x' {
+					s.conn.xact = append(s.conn.xact, key)
+				}
+			}
+		}
+		fakeMu.Unlock()
+		if ok || s.query != "lock" && s.query != "xlock" {
+			return &fakeRows{value: ok}, nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type fakeRows struct {
+	value bool
+	done  bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"ok"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sqllock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// TxDialect holds the statements of transaction-owned advisory locks.
+// Such locks have no unlock statement: commit or rollback releases them.
+type TxDialect struct {
+	Lock, TryLock string
+}
+
+var (
+	// PostgresTx uses pg_advisory_xact_lock, with an int64 key
+	PostgresTx = TxDialect{
+		Lock:    "SELECT true FROM pg_advisory_xact_lock($1)",
+		TryLock: "SELECT pg_try_advisory_xact_lock($1)",
+	}
+	// MSSQLTx uses sp_getapplock with @LockOwner = 'Transaction', with a string key
+	MSSQLTx = TxDialect{
+		Lock:    mssqlGetAppLock("Transaction", -1),
+		TryLock: mssqlGetAppLock("Transaction", 0),
+	}
+)
+
+// LockTx acquires the lock for key in tx, blocking.
+// The lock is released when tx is committed or rolled back.
+func LockTx(ctx context.Context, tx *sql.Tx, dialect TxDialect, key interface{}) error {
+	ok, err := queryTx(ctx, tx, dialect.Lock, key)
+	if err == nil && !ok {
+		err = errors.New("lock was not granted")
+	}
+	return err
+}
+
+// TryLockTx acquires the lock for key in tx, non-blocking.
+// The lock is released when tx is committed or rolled back.
+func TryLockTx(ctx context.Context, tx *sql.Tx, dialect TxDialect, key interface{}) (bool, error) {
+	return queryTx(ctx, tx, dialect.TryLock, key)
+}
+
+func queryTx(ctx context.Context, tx *sql.Tx, qry string, key interface{}) (bool, error) {
+	var ok sql.NullBool
+	if err := tx.QueryRowContext(ctx, qry, key).Scan(&ok); err != nil {
+		return false, err
+	}
+	return ok.Valid && ok.Bool, nil
+}
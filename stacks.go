@@ -0,0 +1,68 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+var captureStacks int32
+
+// SetCaptureStacks turns the contention debugging on or off. When on, the
+// holders record the stack of their acquisition (FLocks in path+".stack",
+// for the waiters on the same host; LockRegistry names in memory), and the
+// waits which fail (at the end of the context) return a *ContentionError
+// with the stack of the holder - compare their errors with errors.Is.
+//
+// It costs a stack capture per acquisition, so it is off by default.
+func SetCaptureStacks(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&captureStacks, v)
+}
+
+func capturingStacks() bool { return atomic.LoadInt32(&captureStacks) != 0 }
+
+// ContentionError is the failure of a wait for a lock, with the holder's
+// acquisition stack: who is blocking.
+type ContentionError struct {
+	Lock  string
+	Stack string // of the holder's acquisition, empty if it did not record it
+	Err   error
+}
+
+func (e *ContentionError) Error() string {
+	if e.Stack == "" {
+		return e.Err.Error() + " waiting for " + e.Lock + " (holder's stack not recorded)"
+	}
+	return e.Err.Error() + " waiting for " + e.Lock + ", held since:\n" + e.Stack
+}
+
+func (e *ContentionError) Unwrap() error { return e.Err }
+
+// contentionError returns err with the holder's stack, when capturing the stacks
+func contentionError(name string, stack func() string, err error) error {
+	if err == nil || !capturingStacks() {
+		return err
+	}
+	return &ContentionError{Lock: name, Stack: stack(), Err: err}
+}
+
+// writeHolderStack records the stack of the acquisition of the FLock at path
+func writeHolderStack(path string) bool {
+	return ioutil.WriteFile(path+".stack", debug.Stack(), 0644) == nil
+}
+
+func readHolderStack(path string) string {
+	b, _ := ioutil.ReadFile(path + ".stack")
+	return string(b)
+}
+
+func removeHolderStack(path string) { os.Remove(path + ".stack") }
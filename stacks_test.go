@@ -0,0 +1,69 @@
+package locking_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestCaptureStacks(t *testing.T) {
+	locking.SetCaptureStacks(true)
+	defer locking.SetCaptureStacks(false)
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+	newLock := func() *locking.FLock {
+		lock, err := locking.NewFLockCreate(path, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lock
+	}
+	holder, waiter := newLock(), newLock()
+	if err = acquireTheBlockingLock(holder); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = waiter.LockContext(ctx)
+	var ce *locking.ContentionError
+	if !errors.As(err, &ce) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, wanted a ContentionError", err)
+	}
+	if !strings.Contains(ce.Stack, "acquireTheBlockingLock") {
+		t.Errorf("the holder's stack is missing from %v", err)
+	}
+	holder.Unlock()
+	if _, err = os.Stat(path + ".stack"); !os.IsNotExist(err) {
+		t.Errorf("stack left behind after release: %v", err)
+	}
+
+	var r locking.LockRegistry
+	h, err := acquireTheBlockingName(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = r.AcquireContext(ctx, "name")
+	if !errors.As(err, &ce) || !strings.Contains(ce.Stack, "acquireTheBlockingName") {
+		t.Errorf("got %v, wanted the holder's stack", err)
+	}
+	h.Release()
+}
+
+func acquireTheBlockingLock(lock *locking.FLock) error { return lock.Lock() }
+
+func acquireTheBlockingName(r *locking.LockRegistry) (*locking.Held, error) {
+	return r.Acquire("name")
+}
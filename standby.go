@@ -0,0 +1,73 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"time"
+)
+
+// StandbyAcquire keeps a hot-spare campaign on lock in the background, and
+// calls onAcquire(nil) the moment it gets the lock — when the primary holder
+// releases it or dies —, or onAcquire(err) if the campaign fails.
+//
+// Locks which wake on release (see Capabilities) wait in LockContext (see
+// there), the others are probed with TryLock every interval - much shorter
+// than the Lock backoff.
+//
+// stop ends the campaign, interrupting the wait; a lock acquired after stop
+// is released at once. It does not release a lock already passed to
+// onAcquire.
+func StandbyAcquire(lock Locker, interval time.Duration, onAcquire func(error)) (stop func()) {
+	ctx, stop := context.WithCancel(context.Background())
+	done := ctx.Done()
+	stopped := func() bool { return ctx.Err() != nil }
+
+	tl, canTry := lock.(TryLocker)
+	if CapabilitiesOf(lock).WakeOnRelease || !canTry {
+		go func() {
+			err := LockContext(ctx, lock)
+			if stopped() {
+				if err == nil {
+					lock.Unlock()
+				}
+				return
+			}
+			onAcquire(err)
+		}()
+		return stop
+	}
+
+	if interval <= 0 {
+		interval = 10 * time.Millisecond
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ok, err := tl.TryLock()
+			if ok && err == nil {
+				if stopped() {
+					lock.Unlock()
+					return
+				}
+				onAcquire(nil)
+				return
+			}
+			if err != nil && !Retryable(err) {
+				if !stopped() {
+					onAcquire(err)
+				}
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,79 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestStandbyAcquire(t *testing.T) {
+	port := freePort(t)
+	primary := locking.NewPortLock(port)
+	if err := primary.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan error, 1)
+	standby := locking.NewPortLock(port)
+	stop := locking.StandbyAcquire(standby, 5*time.Millisecond, func(err error) { acquired <- err })
+	defer stop()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("acquired while the primary holds the lock: %v", err)
+	case <-time.After(30 * time.Millisecond):
+	}
+	released := time.Now()
+	primary.Unlock()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("failover took %s", time.Since(released))
+	case <-time.After(time.Second):
+		t.Fatal("standby did not take over")
+	}
+	standby.Unlock()
+}
+
+func TestStandbyAcquireStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+	primary, err := locking.NewFLockCreate(path, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	if err = primary.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	standby, err := locking.NewFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Close()
+	acquired := make(chan error, 1)
+	stop := locking.StandbyAcquire(standby, 0, func(err error) { acquired <- err })
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	time.Sleep(20 * time.Millisecond)
+	primary.Unlock()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("acquired after stop: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if ok, err := primary.TryLock(); !ok || err != nil {
+		t.Fatalf("the stopped standby holds the lock: %t, %v", ok, err)
+	}
+	primary.Unlock()
+}
@@ -0,0 +1,97 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	waitersMu sync.Mutex
+	waiterSeq uint64
+	waiters   = make(map[string]map[uint64]time.Time) // lock name -> waiter -> start
+)
+
+// beginWait registers a blocked Lock call of name, until the returned func is called
+func beginWait(name string) (end func()) {
+	waitersMu.Lock()
+	waiterSeq++
+	id := waiterSeq
+	if waiters[name] == nil {
+		waiters[name] = make(map[uint64]time.Time)
+	}
+	waiters[name][id] = time.Now()
+	waitersMu.Unlock()
+	return func() {
+		waitersMu.Lock()
+		delete(waiters[name], id)
+		if len(waiters[name]) == 0 {
+			delete(waiters, name)
+		}
+		waitersMu.Unlock()
+	}
+}
+
+// OldestWaiter returns how long the longest waiting Lock call of this process
+// has been blocked on the named lock (path or host:port); zero if none.
+func OldestWaiter(name string) time.Duration {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+	var oldest time.Duration
+	now := time.Now()
+	for _, start := range waiters[name] {
+		if d := now.Sub(start); d > oldest {
+			oldest = d
+		}
+	}
+	return oldest
+}
+
+// WatchStarvation checks the waiters of all locks in every interval, and calls
+// alert once for each Lock call blocked for more than threshold - be it
+// writer starvation or a stuck holder. The alerts of a check are ordered by name.
+func WatchStarvation(threshold, interval time.Duration, alert func(name string, waited time.Duration)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		alerted := make(map[uint64]bool)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			type starving struct {
+				name   string
+				waited time.Duration
+			}
+			var found []starving
+			now := time.Now()
+			seen := make(map[uint64]bool, len(alerted))
+			waitersMu.Lock()
+			for name, ws := range waiters {
+				for id, start := range ws {
+					if d := now.Sub(start); d > threshold {
+						seen[id] = true
+						if !alerted[id] {
+							found = append(found, starving{name: name, waited: d})
+						}
+					}
+				}
+			}
+			waitersMu.Unlock()
+			alerted = seen
+			sort.Slice(found, func(i, j int) bool { return found[i].name < found[j].name })
+			for _, s := range found {
+				alert(s.name, s.waited)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
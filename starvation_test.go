@@ -0,0 +1,60 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestWatchStarvation(t *testing.T) {
+	fh, err := ioutil.TempFile("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	defer os.Remove(fh.Name())
+	holder, err := locking.NewFLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var alerts []string
+	stop := locking.WatchStarvation(20*time.Millisecond, 5*time.Millisecond, func(name string, waited time.Duration) {
+		mu.Lock()
+		alerts = append(alerts, name)
+		mu.Unlock()
+	})
+	defer stop()
+
+	waiter, err := locking.NewFLock(fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- waiter.Lock() }()
+	time.Sleep(100 * time.Millisecond)
+	if d := locking.OldestWaiter(fh.Name()); d < 20*time.Millisecond {
+		t.Errorf("oldest waiter %s", d)
+	}
+	holder.Unlock()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	waiter.Unlock()
+	if d := locking.OldestWaiter(fh.Name()); d != 0 {
+		t.Errorf("oldest waiter %s after acquisition", d)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(alerts) != 1 || alerts[0] != fh.Name() {
+		t.Errorf("alerts=%q, wanted one", alerts)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// number of acquisitions kept per lock for the percentiles
+	statSamples = 256
+	// number of locks kept: the least recently acquired one is dropped
+	// for a new one, so per-request names do not grow the stats unbounded
+	statLocks = 1024
+)
+
+// LockStats are the blocking acquisition statistics of a lock
+type LockStats struct {
+	Name         string        // path or host:port
+	Acquisitions int64         // completed blocking acquisitions
+	Contended    int64         // acquisitions which needed more than one attempt
+	Attempts     int64         // total number of attempts
+	MaxAttempts  int           // most attempts needed by one acquisition
+	Waited       time.Duration // total time spent in Lock
+	MaxWait      time.Duration
+	Slept        time.Duration // total time spent sleeping in backoff
+
+	waits []time.Duration // latest waits of contended acquisitions
+	seq   uint64          // of the latest acquisition, for the eviction
+}
+
+// Tuning is a backoff parameter suggestion computed from LockStats
+type Tuning struct {
+	Initial time.Duration // suggested first sleep
+	Max     time.Duration // suggested sleep cap
+}
+
+// Percentile returns the p-th (0-100) percentile of the recent contended waits
+func (s LockStats) Percentile(p int) time.Duration {
+	if len(s.waits) == 0 {
+		return 0
+	}
+	w := append([]time.Duration(nil), s.waits...)
+	sort.Slice(w, func(i, j int) bool { return w[i] < w[j] })
+	i := len(w) * p / 100
+	if i >= len(w) {
+		i = len(w) - 1
+	}
+	return w[i]
+}
+
+// Tuning suggests backoff parameters: the first sleep should be around
+// the quarter of a typical contended wait, the cap around the 95th percentile.
+// Both are zero when there were no contended acquisitions.
+func (s LockStats) Tuning() Tuning {
+	return Tuning{Initial: s.Percentile(50) / 4, Max: s.Percentile(95)}
+}
+
+var (
+	statsMu  sync.Mutex
+	stats    = make(map[string]*LockStats)
+	statsSeq uint64
+)
+
+func recordAcquisition(name string, attempts int, slept, waited time.Duration) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := stats[name]
+	if s == nil {
+		if len(stats) >= statLocks {
+			var oldest *LockStats
+			for _, o := range stats {
+				if oldest == nil || o.seq < oldest.seq {
+					oldest = o
+				}
+			}
+			delete(stats, oldest.Name)
+		}
+		s = &LockStats{Name: name}
+		stats[name] = s
+	}
+	statsSeq++
+	s.seq = statsSeq
+	s.Acquisitions++
+	s.Attempts += int64(attempts)
+	if attempts > s.MaxAttempts {
+		s.MaxAttempts = attempts
+	}
+	s.Waited += waited
+	if waited > s.MaxWait {
+		s.MaxWait = waited
+	}
+	s.Slept += slept
+	if attempts > 1 {
+		s.Contended++
+		if len(s.waits) == statSamples {
+			copy(s.waits, s.waits[1:])
+			s.waits = s.waits[:statSamples-1]
+		}
+		s.waits = append(s.waits, waited)
+	}
+}
+
+// AllStats are the statistics of all locks
+type AllStats []LockStats
+
+// Stats returns the acquisition statistics of each lock used by this process
+// (the statLocks most recently acquired ones), ordered by name
+func Stats() AllStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	all := make([]LockStats, 0, len(stats))
+	for _, s := range stats {
+		c := *s
+		c.waits = append([]time.Duration(nil), s.waits...)
+		all = append(all, c)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// ResetStats drops all collected statistics
+func ResetStats() {
+	statsMu.Lock()
+	stats = make(map[string]*LockStats)
+	statsMu.Unlock()
+}
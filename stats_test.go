@@ -0,0 +1,131 @@
+package locking_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestStats(t *testing.T) {
+	locking.ResetStats()
+	port := freePort(t)
+	holder := locking.NewPortLock(port)
+	if err := holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		holder.Unlock()
+	}()
+	waiter := locking.NewPortLock(port)
+	if err := waiter.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	waiter.Unlock()
+
+	stats := locking.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("got %d stats, wanted 1: %+v", len(stats), stats)
+	}
+	s := stats[0]
+	if s.Acquisitions != 2 || s.Contended != 1 || s.MaxAttempts < 2 {
+		t.Errorf("bad stats: %+v", s)
+	}
+	tun := s.Tuning()
+	if tun.Max < 100*time.Millisecond || tun.Initial == 0 {
+		t.Errorf("bad tuning %+v", tun)
+	}
+	t.Logf("%+v %+v", s, tun)
+}
+
+func TestStatsFLock(t *testing.T) {
+	locking.ResetStats()
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+	holder, err := locking.NewFLockCreate(path, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	if err = holder.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		holder.Unlock()
+	}()
+	waiter, err := locking.NewFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiter.Close()
+	if err = waiter.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	waiter.Unlock()
+	if stats := locking.Stats(); len(stats) != 1 || stats[0].Acquisitions != 2 || stats[0].Contended != 1 {
+		t.Errorf("blocked Lock not counted as contended: %+v", stats)
+	}
+
+	// bounded
+	for i := 0; i < 2000; i++ {
+		l := locking.NewMemLock("stats-"+strconv.Itoa(i), 0)
+		l.Lock()
+		l.Unlock()
+	}
+	stats := locking.Stats()
+	if len(stats) > 1024 {
+		t.Errorf("%d stats kept", len(stats))
+	}
+	var latest bool
+	for _, s := range stats {
+		latest = latest || s.Name == "stats-1999"
+	}
+	if !latest {
+		t.Error("the latest lock is evicted")
+	}
+	locking.ResetStats()
+}
+
+func TestStatsExport(t *testing.T) {
+	all := locking.AllStats{{Name: "a", Acquisitions: 3, Waited: 1500 * time.Millisecond}}
+	var buf bytes.Buffer
+	if err := all.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], ",a,3,0,0,0,1.5,") {
+		t.Errorf("bad CSV: %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := all.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Name         string
+		Acquisitions int64
+		Waited       time.Duration
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "a" || got.Acquisitions != 3 || got.Waited != 1500*time.Millisecond {
+		t.Errorf("bad JSON: %s", buf.String())
+	}
+	if _, err := locking.ExportStats(&buf, "xml", time.Second); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
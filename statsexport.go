@@ -0,0 +1,97 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+var statsCSVHeader = []string{
+	"time", "name", "acquisitions", "contended", "attempts", "max_attempts",
+	"waited_s", "max_wait_s", "slept_s", "p50_wait_s", "p95_wait_s",
+}
+
+// WriteCSV writes the statistics as CSV, one line per lock, with a header line
+func (all AllStats) WriteCSV(w io.Writer) error {
+	return all.writeCSV(w, true)
+}
+
+func (all AllStats) writeCSV(w io.Writer, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write(statsCSVHeader)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	secs := func(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) }
+	for _, s := range all {
+		cw.Write([]string{
+			now, s.Name,
+			strconv.FormatInt(s.Acquisitions, 10), strconv.FormatInt(s.Contended, 10),
+			strconv.FormatInt(s.Attempts, 10), strconv.Itoa(s.MaxAttempts),
+			secs(s.Waited), secs(s.MaxWait), secs(s.Slept),
+			secs(s.Percentile(50)), secs(s.Percentile(95)),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type statsLine struct {
+	Time time.Time `json:"time"`
+	LockStats
+	P50Wait time.Duration `json:"P50Wait"`
+	P95Wait time.Duration `json:"P95Wait"`
+}
+
+// WriteJSON writes the statistics as JSON lines, one object per lock.
+// Durations are in nanoseconds.
+func (all AllStats) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	now := time.Now().UTC()
+	for _, s := range all {
+		if err := enc.Encode(statsLine{Time: now, LockStats: s,
+			P50Wait: s.Percentile(50), P95Wait: s.Percentile(95)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportStats writes Stats() to w every period, in format "csv" or "json"
+// (JSON lines), until stop is called. The CSV header is written only once.
+func ExportStats(w io.Writer, format string, every time.Duration) (stop func(), err error) {
+	var write func(AllStats, bool) error
+	switch format {
+	case "csv":
+		write = func(all AllStats, first bool) error { return all.writeCSV(w, first) }
+	case "json":
+		write = func(all AllStats, _ bool) error { return all.WriteJSON(w) }
+	default:
+		return nil, errors.New("unknown stats format " + strconv.Quote(format))
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		first := true
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if write(Stats(), first) != nil {
+				return
+			}
+			first = false
+		}
+	}()
+	return func() { close(done) }, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sticky makes a lock reacquirable preferentially by its crashed holder:
+// while held, the Identity of the holder is recorded in the file at Path, and
+// removed at Unlock. If others find the lock free but the record of another
+// identity still there (the holder crashed), they leave it for Grace from
+// then, so a holder restarting quickly (with the same Identity) gets its
+// lock back instead of ping-ponging it in a crash loop.
+//
+// The lock must be released by the OS at exit (FLock ...), and Identity be
+// stable across restarts (not the PID).
+type Sticky struct {
+	Identity string
+	Grace    time.Duration
+	Path     string // the holder record, e.g. the lock path + ".sticky"
+}
+
+// Wrap returns lock with the stickiness of s
+func (s Sticky) Wrap(lock Locker) TryLocker {
+	return stickyLock{Locker: lock, sticky: s}
+}
+
+// read returns the recorded identity, and the time it was found orphaned
+func (s Sticky) read() (identity string, orphaned time.Time, err error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return "", orphaned, err
+	}
+	lines := strings.SplitN(string(b), "\n", 3)
+	if len(lines) >= 2 {
+		if ns, _ := strconv.ParseInt(lines[1], 10, 64); ns != 0 {
+			orphaned = time.Unix(0, ns)
+		}
+	}
+	return lines[0], orphaned, nil
+}
+
+func (s Sticky) write(identity string, orphaned time.Time) error {
+	var ns int64
+	if !orphaned.IsZero() {
+		ns = orphaned.UnixNano()
+	}
+	return writeDurable(s.Path, identity+"\n"+strconv.FormatInt(ns, 10)+"\n")
+}
+
+type stickyLock struct {
+	Locker
+	sticky Sticky
+}
+
+func (l stickyLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+func (l stickyLock) LockContext(ctx context.Context) error {
+	if _, ok := l.Locker.(TryLocker); !ok {
+		return ErrNoTryLock
+	}
+	eb := newExpBackoff(l.sticky.Path)
+	defer beginWait(l.sticky.Path)()
+	for {
+		ok, err := l.TryLock()
+		if ok && err == nil {
+			eb.Done()
+			return nil
+		}
+		if err != nil && !Retryable(err) {
+			return err
+		}
+		if err = eb.Sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// TryLock acquires the lock, then checks the record - serialized by the lock
+func (l stickyLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	if ok, err := tl.TryLock(); !ok || err != nil {
+		return ok, err
+	}
+	s := l.sticky
+	identity, orphaned, err := s.read()
+	if err == nil && identity != "" && identity != s.Identity {
+		// the holder crashed: leave it for the grace period
+		if orphaned.IsZero() {
+			orphaned = time.Now()
+			err = s.write(identity, orphaned)
+		}
+		if err == nil && time.Since(orphaned) < s.Grace {
+			return false, tl.Unlock()
+		}
+		debugf(1, "lock %s: grace of %s is over", s.Path, identity)
+	}
+	if err == nil {
+		err = s.write(s.Identity, time.Time{})
+	}
+	if err != nil {
+		tl.Unlock()
+		return false, err
+	}
+	return true, nil
+}
+
+// Unlock removes the record, then releases the lock
+func (l stickyLock) Unlock() error {
+	if err := os.Remove(l.sticky.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.Locker.Unlock()
+}
@@ -0,0 +1,63 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestSticky(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+	newLock := func(identity string) (*locking.FLock, locking.TryLocker) {
+		fl, err := locking.NewFLockCreate(path, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fl, locking.Sticky{Identity: identity, Grace: 100 * time.Millisecond, Path: path + ".sticky"}.Wrap(fl)
+	}
+	_, a := newLock("a")
+	if err = testLock(a); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crashes: its flock is gone, its record stays
+	fa, a := newLock("a")
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	fa.Close()
+	fb, b := newLock("b")
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Fatalf("b got the lock of the crashed a in the grace period: %t, %v", ok, err)
+	}
+	// the restarted a gets it back
+	_, a = newLock("a")
+	if ok, err := a.TryLock(); !ok || err != nil {
+		t.Fatalf("restarted a: %t, %v", ok, err)
+	}
+	a.Unlock()
+	// after a clean release, b gets it at once
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("b after a's release: %t, %v", ok, err)
+	}
+
+	// b crashes, and does not come back: a gets it after the grace
+	fb.Close()
+	start := time.Now()
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("acquired after %s, in the grace period", d)
+	}
+	a.Unlock()
+}
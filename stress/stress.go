@@ -0,0 +1,359 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package stress hammers a locking.Locker from many goroutines and processes,
+// and checks the invariants a lock must keep on the given storage:
+// never two holders, bounded unfairness and release when the holder is killed.
+//
+// The processes are re-executions of the current binary, so programs
+// (or TestMain) must call Child first thing:
+//
+//	func TestMain(m *testing.M) {
+//		stress.Child(newLocker)
+//		os.Exit(m.Run())
+//	}
+package stress
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+const envKey = "GOLOCKING_STRESS"
+
+var (
+	// ErrNotExclusive is returned when two holders were seen at once
+	ErrNotExclusive = errors.New("lock held by two holders at once")
+	// ErrUnfair is returned when the fairness is below Config.MinFairness
+	ErrUnfair = errors.New("lock acquisition is unfair")
+	// ErrNotReleased is returned by KillRelease when the lock of a killed holder stays locked
+	ErrNotReleased = errors.New("lock not released after holder was killed")
+)
+
+// NewLocker returns a new Locker for the resource under test.
+// It is called once for each goroutine, in each process.
+type NewLocker func() (locking.Locker, error)
+
+// Config of a stress run
+type Config struct {
+	Processes   int           // child processes to start, 0 runs in this process only
+	Goroutines  int           // goroutines per process, at least 1
+	Duration    time.Duration // length of the run
+	Hold        time.Duration // time to hold the lock on each acquisition
+	MinFairness float64       // minimal acceptable Result.Fairness, 0 to not check
+	Dir         string        // directory for the holder marker, defaults to a temp dir
+}
+
+// Result of a stress run
+type Result struct {
+	Acquisitions []int         // per goroutine
+	MaxWait      time.Duration // longest blocking Lock
+	Violations   int           // times the lock was held twice at once
+}
+
+// Total number of acquisitions
+func (r Result) Total() int {
+	var n int
+	for _, a := range r.Acquisitions {
+		n += a
+	}
+	return n
+}
+
+// Fairness is the ratio of the least and most successful goroutine's acquisitions
+func (r Result) Fairness() float64 {
+	if len(r.Acquisitions) == 0 {
+		return 1
+	}
+	min, max := r.Acquisitions[0], r.Acquisitions[0]
+	for _, a := range r.Acquisitions[1:] {
+		if a < min {
+			min = a
+		}
+		if a > max {
+			max = a
+		}
+	}
+	if max == 0 {
+		return 1
+	}
+	return float64(min) / float64(max)
+}
+
+type childSpec struct {
+	Mode   string // "run" or "hold"
+	Config Config
+}
+
+type childResult struct {
+	Result Result
+	Err    string
+}
+
+// Child runs the workload and exits, if this process was started by Run or KillRelease.
+// Otherwise it returns immediately.
+func Child(newLocker NewLocker) {
+	spec := os.Getenv(envKey)
+	if spec == "" {
+		return
+	}
+	var c childSpec
+	if err := json.Unmarshal([]byte(spec), &c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	switch c.Mode {
+	case "hold":
+		lock, err := newLocker()
+		if err == nil {
+			err = lock.Lock()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("locked")
+		for { // until killed
+			time.Sleep(time.Hour)
+		}
+	default:
+		var cr childResult
+		res, err := work(c.Config, newLocker)
+		cr.Result = res
+		if err != nil {
+			cr.Err = err.Error()
+		}
+		json.NewEncoder(os.Stdout).Encode(cr)
+		os.Exit(0)
+	}
+}
+
+// Run the stress test described by cfg.
+//
+// The returned error is ErrNotExclusive or ErrUnfair when an invariant is violated.
+func Run(cfg Config, newLocker NewLocker) (Result, error) {
+	if cfg.Goroutines < 1 {
+		cfg.Goroutines = 1
+	}
+	if cfg.Dir == "" {
+		dir, err := ioutil.TempDir("", "locking-stress.")
+		if err != nil {
+			return Result{}, err
+		}
+		defer os.RemoveAll(dir)
+		cfg.Dir = dir
+	}
+	var (
+		res Result
+		err error
+	)
+	if cfg.Processes == 0 {
+		res, err = work(cfg, newLocker)
+	} else {
+		res, err = runChildren(cfg)
+	}
+	if err != nil {
+		return res, err
+	}
+	if res.Violations > 0 {
+		return res, ErrNotExclusive
+	}
+	if cfg.MinFairness > 0 && res.Fairness() < cfg.MinFairness {
+		return res, ErrUnfair
+	}
+	return res, nil
+}
+
+func runChildren(cfg Config) (Result, error) {
+	spec, err := json.Marshal(childSpec{Mode: "run", Config: cfg})
+	if err != nil {
+		return Result{}, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return Result{}, err
+	}
+	results := make([]childResult, cfg.Processes)
+	errs := make([]error, cfg.Processes)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Processes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cmd := exec.Command(exe)
+			cmd.Env = append(os.Environ(), envKey+"="+string(spec))
+			cmd.Stderr = os.Stderr
+			out, err := cmd.Output()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = json.Unmarshal(out, &results[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var res Result
+	for i, cr := range results {
+		if errs[i] != nil {
+			return res, errs[i]
+		}
+		if cr.Err != "" {
+			return res, errors.New(cr.Err)
+		}
+		res.Acquisitions = append(res.Acquisitions, cr.Result.Acquisitions...)
+		res.Violations += cr.Result.Violations
+		if cr.Result.MaxWait > res.MaxWait {
+			res.MaxWait = cr.Result.MaxWait
+		}
+	}
+	return res, nil
+}
+
+// work hammers the lock from cfg.Goroutines goroutines in this process
+func work(cfg Config, newLocker NewLocker) (Result, error) {
+	marker := filepath.Join(cfg.Dir, "holder")
+	res := Result{Acquisitions: make([]int, cfg.Goroutines)}
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	deadline := time.Now().Add(cfg.Duration)
+	for i := 0; i < cfg.Goroutines; i++ {
+		lock, err := newLocker()
+		if err != nil {
+			return res, err
+		}
+		wg.Add(1)
+		go func(i int, lock locking.Locker) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				start := time.Now()
+				if err := lock.Lock(); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+				wait := time.Since(start)
+				fh, err := os.OpenFile(marker, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+				if fh != nil {
+					fh.Close()
+				}
+				if cfg.Hold > 0 {
+					time.Sleep(cfg.Hold)
+				}
+				if err == nil {
+					os.Remove(marker)
+				}
+				lock.Unlock()
+
+				mu.Lock()
+				res.Acquisitions[i]++
+				if err != nil {
+					res.Violations++
+				}
+				if wait > res.MaxWait {
+					res.MaxWait = wait
+				}
+				mu.Unlock()
+			}
+		}(i, lock)
+	}
+	wg.Wait()
+	return res, firstErr
+}
+
+// CheckAutoRelease verifies the AutoRelease claim of locking.CapabilitiesOf
+// for the lock returned by newLocker, with KillRelease.
+func CheckAutoRelease(newLocker NewLocker, timeout time.Duration) error {
+	lock, err := newLocker()
+	if err != nil {
+		return err
+	}
+	claim := locking.CapabilitiesOf(lock).AutoRelease
+	err = KillRelease(newLocker, timeout)
+	switch {
+	case err == nil && !claim:
+		return fmt.Errorf("%T is released on kill, but AutoRelease is not claimed", lock)
+	case err == ErrNotReleased && claim:
+		return fmt.Errorf("%T claims AutoRelease, but is not released on kill", lock)
+	case err == ErrNotReleased:
+		return nil
+	}
+	return err
+}
+
+// KillRelease starts a child process which acquires the lock, kills it
+// with SIGKILL while holding, then checks whether the lock can be acquired
+// within timeout. It returns ErrNotReleased if it cannot.
+func KillRelease(newLocker NewLocker, timeout time.Duration) error {
+	spec, err := json.Marshal(childSpec{Mode: "hold"})
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), envKey+"="+string(spec))
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("child could not lock: %v", err)
+	}
+	if line != "locked\n" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("unexpected child output %q", line)
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	lock, err := newLocker()
+	if err != nil {
+		return err
+	}
+	tryLock, ok := lock.(locking.TryLocker)
+	if !ok {
+		return locking.ErrNoTryLock
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock.TryLock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return lock.Unlock()
+		}
+		if time.Now().After(deadline) {
+			return ErrNotReleased
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,96 @@
+package stress_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+	"github.com/tgulacsi/go-locking/stress"
+)
+
+var lockPath = os.Getenv("STRESS_TEST_LOCK")
+
+func newFLock() (locking.Locker, error) {
+	return locking.NewFLock(lockPath)
+}
+
+// newLocker returns the lock selected by $STRESS_TEST_BACKEND
+func newLocker() (locking.Locker, error) {
+	switch os.Getenv("STRESS_TEST_BACKEND") {
+	case "dir":
+		return locking.NewDirLock(lockPath)
+	case "port":
+		port, err := strconv.Atoi(os.Getenv("STRESS_TEST_PORT"))
+		if err != nil {
+			return nil, err
+		}
+		return locking.NewPortLock(port), nil
+	}
+	return newFLock()
+}
+
+func TestMain(m *testing.M) {
+	stress.Child(newLocker)
+	if lockPath == "" {
+		fh, err := ioutil.TempFile("", "stress-test.")
+		if err != nil {
+			panic(err)
+		}
+		fh.Close()
+		lockPath = fh.Name()
+		os.Setenv("STRESS_TEST_LOCK", lockPath)
+	}
+	code := m.Run()
+	os.Remove(lockPath)
+	os.Exit(code)
+}
+
+func TestRunGoroutines(t *testing.T) {
+	res, err := stress.Run(stress.Config{Goroutines: 4, Duration: 100 * time.Millisecond}, newFLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d acquisitions, fairness=%.2f, max wait=%s", res.Total(), res.Fairness(), res.MaxWait)
+	if res.Total() == 0 {
+		t.Error("no acquisitions")
+	}
+}
+
+func TestRunProcesses(t *testing.T) {
+	res, err := stress.Run(stress.Config{Processes: 3, Goroutines: 2, Duration: 200 * time.Millisecond}, newFLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Acquisitions) != 6 {
+		t.Errorf("got %d workers, wanted 6", len(res.Acquisitions))
+	}
+}
+
+func TestKillRelease(t *testing.T) {
+	if err := stress.KillRelease(newFLock, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	port := 1337
+	for ; port < 65535; port++ {
+		lock := locking.NewPortLock(port)
+		if ok, _ := lock.TryLock(); ok {
+			lock.Unlock()
+			break
+		}
+	}
+	os.Setenv("STRESS_TEST_PORT", strconv.Itoa(port))
+	defer os.Unsetenv("STRESS_TEST_BACKEND")
+	for _, backend := range []string{"flock", "dir", "port"} {
+		os.Setenv("STRESS_TEST_BACKEND", backend)
+		if err := stress.CheckAutoRelease(newLocker, 200*time.Millisecond); err != nil {
+			t.Errorf("%s: %v", backend, err)
+		}
+	}
+	os.RemoveAll(lockPath + ".lock") // the stale DirLock, with its owner file
+}
@@ -0,0 +1,75 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnknownLockType is returned by Strict for a lock it cannot check
+var ErrUnknownLockType = errors.New("unknown lock type")
+
+// UnsafeError is returned by Strict when the lock cannot guarantee
+// mutual exclusion in the detected environment
+type UnsafeError struct {
+	Kind   string // "flock", "fcntl", "dir", "port", "socket", "shm" or "mem"
+	Target string // path or host:port
+	Reason string
+}
+
+func (e *UnsafeError) Error() string {
+	return "unsafe " + e.Kind + " lock on " + e.Target + ": " + e.Reason
+}
+
+// Strict checks whether lock really excludes other processes in the
+// current environment, and returns an *UnsafeError if it does not
+// (flock on an NFS mount with local locking, DirLock on FAT,
+// PortLock in a private network namespace, ShmLock on NFS, MemLock
+// anywhere). The distributed locks (DistLocker) are arbitrated by their
+// server, and pass. For other lock types it returns ErrUnknownLockType.
+//
+// Call it right after construction to fail fast instead of silently
+// relying on a false lock.
+func Strict(lock Locker) error {
+	switch l := lock.(type) {
+	case *FLock:
+		return checkFLock(l.path)
+	// these create their files at the acquisition: check the directory
+	case *RWFLock:
+		return checkFLock(filepath.Dir(l.path))
+	case *PooledFLock:
+		return checkFLock(filepath.Dir(l.path))
+	case *SemaphoreLock:
+		return checkFLock(filepath.Dir(l.path))
+	case *PIDFileLock:
+		return checkFLock(filepath.Dir(l.path))
+	case DirLock:
+		return checkDirLock(filepath.Dir(string(l)))
+	case *FcntlLock:
+		return checkFcntlLock(l.path)
+	case BootDirLock:
+		return checkDirLock(filepath.Dir(string(l.DirLock)))
+	case *PortLock:
+		return checkPortLock(l.hostport)
+	case *SocketLock:
+		if strings.HasPrefix(l.addr, "@") { // abstract sockets are per network namespace
+			return checkSocketLock(l.addr)
+		}
+		return nil
+	case *ShmLock:
+		return checkShmLock(l.path)
+	case *FairLock:
+		return Strict(l.lock)
+	case *MemLock:
+		return &UnsafeError{Kind: "mem", Target: l.name,
+			Reason: "in-process lock, other processes do not see it"}
+	case *LeaseLock, *NFSLeaseLock, DistLocker:
+		return nil
+	}
+	return fmt.Errorf("%T: %w", lock, ErrUnknownLockType)
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	nfsSuperMagic   = 0x6969
+	msdosSuperMagic = 0x4d44
+	exfatSuperMagic = 0x2011bab0
+)
+
+// the environment seen by the checks, replaced by the tests
+var (
+	mountsFile = "/proc/self/mounts"
+	fsType     = func(path string) (int64, error) {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(path, &st); err != nil {
+			return 0, err
+		}
+		return int64(st.Type), nil
+	}
+)
+
+func checkFLock(path string) error {
+	if typ, err := fsType(path); err != nil || typ != nfsSuperMagic {
+		return err
+	}
+	opts, err := mountOptions(path)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		switch opt {
+		case "nolock", "local_lock=flock", "local_lock=all":
+			return &UnsafeError{Kind: "flock", Target: path,
+				Reason: "NFS mounted with " + opt + ", flock is local to this host"}
+		}
+	}
+	return nil
+}
+
+func checkFcntlLock(path string) error {
+	if typ, err := fsType(path); err != nil || typ != nfsSuperMagic {
+		return err
+	}
+	opts, err := mountOptions(path)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		switch opt {
+		case "nolock", "local_lock=posix", "local_lock=all":
+			return &UnsafeError{Kind: "fcntl", Target: path,
+				Reason: "NFS mounted with " + opt + ", record locks are local to this host"}
+		}
+	}
+	return nil
+}
+
+func checkDirLock(dir string) error {
+	typ, err := fsType(dir)
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case msdosSuperMagic, exfatSuperMagic:
+		return &UnsafeError{Kind: "dir", Target: dir,
+			Reason: "FAT filesystems do not guarantee atomic mkdir"}
+	}
+	return nil
+}
+
+func checkPortLock(hostport string) error {
+	if privateNetNamespace() {
+		return &UnsafeError{Kind: "port", Target: hostport,
+			Reason: "private network namespace, processes outside it won't see the port"}
+	}
+	return nil
+}
+
+func checkSocketLock(addr string) error {
+	if privateNetNamespace() {
+		return &UnsafeError{Kind: "socket", Target: addr,
+			Reason: "private network namespace, processes outside it won't see the abstract socket"}
+	}
+	return nil
+}
+
+func checkShmLock(path string) error {
+	typ, err := fsType(path)
+	if err != nil {
+		return err
+	}
+	if typ == nfsSuperMagic {
+		return &UnsafeError{Kind: "shm", Target: path,
+			Reason: "NFS does not share the mapped memory with the other hosts"}
+	}
+	return nil
+}
+
+// privateNetNamespace reports whether this process is in another network
+// namespace than PID 1 - false if it can't tell
+func privateNetNamespace() bool {
+	self, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		return false
+	}
+	pid1, err := os.Readlink("/proc/1/ns/net")
+	if err != nil { // not allowed to look, can't tell
+		return false
+	}
+	return self != pid1
+}
+
+// mountOptions returns the mount options of the filesystem containing path
+func mountOptions(path string) ([]string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	fh, err := os.Open(mountsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var best, opts string
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		mnt := strings.Replace(fields[1], `\040`, " ", -1)
+		if !(path == mnt || mnt == "/" || strings.HasPrefix(path, mnt+"/")) {
+			continue
+		}
+		if len(mnt) >= len(best) {
+			best, opts = mnt, fields[3]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return strings.Split(opts, ","), nil
+}
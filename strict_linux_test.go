@@ -0,0 +1,83 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestStrictDetect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	path := filepath.Join(dir, "lock")
+	if err = ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fl, err := locking.NewFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+	fcl, err := locking.NewFcntlLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fcl.Close()
+	dl, err := locking.NewDirLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shm, err := locking.NewShmLock(filepath.Join(dir, "shm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := locking.NewRWFLock(filepath.Join(dir, "rw"))
+
+	const (
+		nfs   = 0x6969
+		msdos = 0x4d44
+		ext4  = 0xef53
+	)
+	for _, tc := range []struct {
+		typ    int64
+		opts   string
+		lock   locking.Locker
+		unsafe string // the Kind of the UnsafeError, or ""
+	}{
+		{nfs, "rw,vers=3,nolock", fl, "flock"},
+		{nfs, "rw,vers=4,local_lock=flock", fl, "flock"},
+		{nfs, "rw,vers=4,local_lock=flock", fcl, ""},
+		{nfs, "rw,vers=4,local_lock=posix", fcl, "fcntl"},
+		{nfs, "rw,vers=4", fl, ""},
+		{ext4, "rw,nolock", fl, ""},
+		{msdos, "rw", dl, "dir"},
+		{ext4, "rw", dl, ""},
+		{nfs, "rw,vers=4,local_lock=flock", rw, "flock"},
+		{nfs, "rw,vers=4", shm, "shm"},
+		{ext4, "rw", shm, ""},
+	} {
+		mounts := filepath.Join(dir, "mounts")
+		table := "/dev/sda1 / ext4 rw 0 0\nserver:/export " + dir + " nfs " + tc.opts + " 0 0\n"
+		if err := ioutil.WriteFile(mounts, []byte(table), 0644); err != nil {
+			t.Fatal(err)
+		}
+		restore := locking.SetStrictEnv(mounts, tc.typ)
+		err := locking.Strict(tc.lock)
+		restore()
+		ue, ok := err.(*locking.UnsafeError)
+		switch {
+		case tc.unsafe == "" && err != nil:
+			t.Errorf("%x %s %T: %v", tc.typ, tc.opts, tc.lock, err)
+		case tc.unsafe != "" && (!ok || ue.Kind != tc.unsafe):
+			t.Errorf("%x %s %T: got %v, wanted an unsafe %s lock", tc.typ, tc.opts, tc.lock, err, tc.unsafe)
+		}
+	}
+}
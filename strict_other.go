@@ -0,0 +1,16 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package locking
+
+// Environment detection is implemented only on Linux.
+
+func checkFLock(path string) error        { return nil }
+func checkFcntlLock(path string) error    { return nil }
+func checkDirLock(dir string) error       { return nil }
+func checkPortLock(hostport string) error { return nil }
+func checkSocketLock(addr string) error   { return nil }
+func checkShmLock(path string) error      { return nil }
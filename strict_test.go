@@ -0,0 +1,45 @@
+package locking_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestStrict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dl, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl, err := locking.NewFLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Unlock()
+	for _, lock := range []locking.Locker{dl, fl} {
+		if err := locking.Strict(lock); err != nil {
+			if _, ok := err.(*locking.UnsafeError); !ok {
+				t.Errorf("%v: %v", lock, err)
+			} else {
+				t.Logf("%v: %v", lock, err)
+			}
+		}
+	}
+	if err := locking.Strict(unknownLock{}); !errors.Is(err, locking.ErrUnknownLockType) {
+		t.Errorf("unknown lock type: %v", err)
+	}
+}
+
+type unknownLock struct{}
+
+func (unknownLock) Lock() error   { return nil }
+func (unknownLock) Unlock() error { return nil }
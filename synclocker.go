@@ -0,0 +1,40 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MustLocker adapts lock to sync.Locker (for sync.Cond, or libraries taking
+// a sync.Locker): the errors of Lock and Unlock are passed to onError, with
+// the name of the operation ("lock" or "unlock"). A nil onError panics.
+//
+// After a failed Lock the lock is not held - onError should log and exit or
+// panic, unless the caller can live with that.
+func MustLocker(lock Locker, onError func(op string, err error)) sync.Locker {
+	if onError == nil {
+		onError = func(op string, err error) { panic(fmt.Sprintf("%s %v: %v", op, lock, err)) }
+	}
+	return mustLocker{lock: lock, onError: onError}
+}
+
+type mustLocker struct {
+	lock    Locker
+	onError func(string, error)
+}
+
+func (l mustLocker) Lock() {
+	if err := l.lock.Lock(); err != nil {
+		l.onError("lock", err)
+	}
+}
+
+func (l mustLocker) Unlock() {
+	if err := l.lock.Unlock(); err != nil {
+		l.onError("unlock", err)
+	}
+}
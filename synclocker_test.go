@@ -0,0 +1,49 @@
+package locking_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestMustLocker(t *testing.T) {
+	// usable with sync.Cond
+	cond := sync.NewCond(locking.MustLocker(make(chanLock, 1), nil))
+	ready := false
+	go func() {
+		cond.L.Lock()
+		ready = true
+		cond.L.Unlock()
+		cond.Broadcast()
+	}()
+	cond.L.Lock()
+	for !ready {
+		cond.Wait()
+	}
+	cond.L.Unlock()
+
+	var failed []string
+	ml := locking.MustLocker(failingLock{}, func(op string, err error) { failed = append(failed, op) })
+	ml.Lock()
+	ml.Unlock()
+	if len(failed) != 2 || failed[0] != "lock" || failed[1] != "unlock" {
+		t.Errorf("onError got %q", failed)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("no panic without onError")
+			}
+		}()
+		locking.MustLocker(failingLock{}, nil).Lock()
+	}()
+}
+
+type failingLock struct{}
+
+var errFailing = errors.New("failing")
+
+func (failingLock) Lock() error   { return errFailing }
+func (failingLock) Unlock() error { return errFailing }
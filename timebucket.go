@@ -0,0 +1,94 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const bucketLayout = "20060102T150405Z"
+
+// TimeBucketLock claims time buckets: in each bucket (the 14:00-15:00 hour,
+// say) only the first Claim succeeds, so "one process handles the 14:00
+// batch" needs no coordination beyond a shared directory.
+//
+// A claim is a file named name.<bucket start, UTC> holding its Owner; the
+// claims of buckets older than Keep are removed at each successful Claim.
+type TimeBucketLock struct {
+	Keep int              // number of past buckets whose claims are kept, 24 if zero
+	Now  func() time.Time // time.Now if nil
+
+	dir, name string
+	bucket    time.Duration
+}
+
+// NewTimeBucketLock returns the claims of name in dir, per bucket
+func NewTimeBucketLock(dir, name string, bucket time.Duration) *TimeBucketLock {
+	return &TimeBucketLock{dir: dir, name: name, bucket: bucket}
+}
+
+// Bucket returns the start of the bucket of t
+func (l *TimeBucketLock) Bucket(t time.Time) time.Time {
+	return t.UTC().Truncate(l.bucket)
+}
+
+// Claim claims the current bucket; ok is false if it has been claimed already
+func (l *TimeBucketLock) Claim() (bucket time.Time, ok bool, err error) {
+	now := time.Now
+	if l.Now != nil {
+		now = l.Now
+	}
+	bucket = l.Bucket(now())
+	fh, err := os.OpenFile(l.claimPath(bucket), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return bucket, false, nil
+		}
+		return bucket, false, err
+	}
+	_, err = fh.WriteString(currentOwner().String())
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return bucket, false, err
+	}
+	return bucket, true, l.collect(bucket)
+}
+
+// Claimer returns the owner of the claim of the bucket of t
+func (l *TimeBucketLock) Claimer(t time.Time) (Owner, error) {
+	return readOwnerFile(l.claimPath(l.Bucket(t)))
+}
+
+func (l *TimeBucketLock) claimPath(bucket time.Time) string {
+	return filepath.Join(l.dir, l.name+"."+bucket.Format(bucketLayout))
+}
+
+// collect removes the claims older than Keep buckets before current
+func (l *TimeBucketLock) collect(current time.Time) error {
+	keep := l.Keep
+	if keep <= 0 {
+		keep = 24
+	}
+	oldest := current.Add(-time.Duration(keep) * l.bucket)
+	paths, err := filepath.Glob(filepath.Join(l.dir, l.name+".*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		t, err := time.Parse(bucketLayout, strings.TrimPrefix(filepath.Base(path), l.name+"."))
+		if err != nil || !t.Before(oldest) {
+			continue
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
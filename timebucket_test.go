@@ -0,0 +1,48 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestTimeBucketLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clock := locking.NewFakeClock(time.Date(2020, 3, 1, 14, 5, 0, 0, time.UTC))
+	newLock := func() *locking.TimeBucketLock {
+		l := locking.NewTimeBucketLock(dir, "batch", time.Hour)
+		l.Keep, l.Now = 2, clock.Now
+		return l
+	}
+	a, b := newLock(), newLock()
+	bucket, ok, err := a.Claim()
+	if !ok || err != nil {
+		t.Fatalf("a: %t, %v", ok, err)
+	}
+	if want := time.Date(2020, 3, 1, 14, 0, 0, 0, time.UTC); !bucket.Equal(want) {
+		t.Errorf("bucket=%s, wanted %s", bucket, want)
+	}
+	if _, ok, err = b.Claim(); ok || err != nil {
+		t.Fatalf("b claimed the same bucket: %t, %v", ok, err)
+	}
+	if o, err := b.Claimer(clock.Now()); err != nil || o.PID != os.Getpid() {
+		t.Errorf("claimer=%+v, %v", o, err)
+	}
+
+	clock.Advance(3 * time.Hour)
+	if _, ok, err = b.Claim(); !ok || err != nil {
+		t.Fatalf("b: %t, %v", ok, err)
+	}
+	claims, _ := filepath.Glob(filepath.Join(dir, "batch.*"))
+	if len(claims) != 1 {
+		t.Errorf("old claims not collected: %q", claims)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"time"
+)
+
+// TimeSlice shares a lock between long-running jobs in turns: a job holds
+// the lock for at most Slice, then yields it and requeues, so competing jobs
+// cannot monopolize the resource.
+type TimeSlice struct {
+	Slice time.Duration // maximal hold, 1 minute if zero
+	// Pause after yielding, before requeueing, so the waiters get their
+	// turn - Slice/10 if zero.
+	Pause time.Duration
+}
+
+// Run runs the job in steps: each is called with the lock held and a
+// context which is done at the end of the slice. When that happens, the lock
+// is released, and reacquired for the next step after Pause.
+// Run returns when a step reports done or fails, or ctx is done.
+func (ts TimeSlice) Run(ctx context.Context, lock Locker, step func(ctx context.Context) (done bool, err error)) error {
+	slice, pause := ts.Slice, ts.Pause
+	if slice <= 0 {
+		slice = time.Minute
+	}
+	if pause <= 0 {
+		pause = slice / 10
+	}
+	for {
+		if err := LockContext(ctx, lock); err != nil {
+			return err
+		}
+		done, err := ts.turn(ctx, slice, step)
+		if uerr := lock.Unlock(); err == nil {
+			err = uerr
+		}
+		if done || err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+}
+
+// turn runs the steps until the end of the slice
+func (ts TimeSlice) turn(ctx context.Context, slice time.Duration, step func(context.Context) (bool, error)) (bool, error) {
+	sliceCtx, cancel := context.WithTimeout(ctx, slice)
+	defer cancel()
+	for sliceCtx.Err() == nil {
+		if done, err := step(sliceCtx); done || err != nil {
+			if err != nil && sliceCtx.Err() != nil && ctx.Err() == nil {
+				return false, nil // interrupted by the end of the slice
+			}
+			return done, err
+		}
+	}
+	return false, ctx.Err()
+}
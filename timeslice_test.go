@@ -0,0 +1,51 @@
+package locking_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestTimeSlice(t *testing.T) {
+	lock := make(chanLock, 1)
+	ts := locking.TimeSlice{Slice: 20 * time.Millisecond, Pause: 10 * time.Millisecond}
+	var mu sync.Mutex
+	var turns []string
+	job := func(name string) error {
+		steps := 0
+		return ts.Run(context.Background(), lock, func(ctx context.Context) (bool, error) {
+			mu.Lock()
+			if len(turns) == 0 || turns[len(turns)-1] != name {
+				turns = append(turns, name)
+			}
+			mu.Unlock()
+			steps++
+			time.Sleep(5 * time.Millisecond)
+			return steps == 20, nil
+		})
+	}
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := job(name); err != nil {
+				t.Error(err)
+			}
+		}(name)
+	}
+	wg.Wait()
+	// 100ms of work each, in 20ms slices: they must take turns
+	if len(turns) < 4 {
+		t.Errorf("turns=%q", turns)
+	}
+}
+
+// chanLock is an in-process Locker, which wakes a waiter at once
+type chanLock chan struct{}
+
+func (l chanLock) Lock() error   { l <- struct{}{}; return nil }
+func (l chanLock) Unlock() error { <-l; return nil }
@@ -0,0 +1,161 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrOutsideTree is returned for paths not under the root of a TreeLock
+var ErrOutsideTree = errors.New("path is outside the lock tree")
+
+// treeLockFile is the lock file of each directory of a TreeLock
+const treeLockFile = ".treelock"
+
+// TreeLock locks directories of a tree hierarchically: locking a directory
+// excludes the locks of its ancestors and descendants, while disjoint
+// subtrees can be locked at the same time.
+//
+// A node is locked by an exclusive flock on the .treelock of its directory,
+// after intent (shared) flocks on the .treelock of each ancestor up to the
+// root - so locking /repo waits for the intents of /repo/pkg/a, and the other
+// way round. The flocks are taken from the root down: no deadlock.
+type TreeLock struct {
+	root string
+}
+
+// NewTreeLock returns the lock tree rooted at the directory root
+func NewTreeLock(root string) (*TreeLock, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "treelock", Path: root, Err: syscall.ENOTDIR}
+	}
+	return &TreeLock{root: root}, nil
+}
+
+// Node returns the lock of the directory at path (unlocked first)
+func (t *TreeLock) Node(path string) (*TreeNode, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(t.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, ErrOutsideTree
+	}
+	dirs := []string{t.root}
+	if rel != "." {
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			dirs = append(dirs, filepath.Join(dirs[len(dirs)-1], part))
+		}
+	}
+	return &TreeNode{dirs: dirs}, nil
+}
+
+// TreeNode is the lock of a directory in a TreeLock
+type TreeNode struct {
+	dirs []string // from the root down to the node
+	held []*os.File
+}
+
+// Path returns the directory of the node
+func (n *TreeNode) Path() string { return n.dirs[len(n.dirs)-1] }
+
+// Lock locks the node, blocking
+func (n *TreeNode) Lock() error {
+	return n.acquire(nil, false)
+}
+
+// LockContext locks the node, polling until ctx is done
+func (n *TreeNode) LockContext(ctx context.Context) error {
+	return n.acquire(ctx, false)
+}
+
+// TryLock locks the node if neither it, nor an ancestor or descendant is locked
+func (n *TreeNode) TryLock() (bool, error) {
+	err := n.acquire(nil, true)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock releases the node and the intents on its ancestors
+func (n *TreeNode) Unlock() error {
+	var err error
+	for i := len(n.held) - 1; i >= 0; i-- {
+		if uerr := unflock(n.held[i]); err == nil {
+			err = uerr
+		}
+	}
+	n.held = nil
+	return err
+}
+
+func (n *TreeNode) acquire(ctx context.Context, try bool) error {
+	if len(n.held) != 0 {
+		return nil
+	}
+	defer beginWait(n.Path())()
+	for i, dir := range n.dirs {
+		how := syscall.LOCK_SH
+		if i == len(n.dirs)-1 {
+			how = syscall.LOCK_EX
+		}
+		fh, err := os.OpenFile(filepath.Join(dir, treeLockFile), os.O_RDONLY|os.O_CREATE, 0644)
+		if err == nil {
+			err = flockWait(ctx, fh, how, try)
+			if err != nil {
+				fh.Close()
+			}
+		}
+		if err != nil {
+			n.Unlock()
+			return err
+		}
+		n.held = append(n.held, fh)
+	}
+	return nil
+}
+
+// flockWait flocks fh with how: non-blocking if try, polling until ctx is
+// done if ctx is not nil, else blocking
+func flockWait(ctx context.Context, fh *os.File, how int, try bool) error {
+	if try || ctx != nil {
+		how |= syscall.LOCK_NB
+	}
+	delay := time.Millisecond
+	for {
+		err := retryBlocking(func() error { return syscall.Flock(int(fh.Fd()), how) })
+		switch {
+		case err == nil:
+			return nil
+		case err == syscall.EWOULDBLOCK && ctx != nil && !try:
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			if delay < 100*time.Millisecond {
+				delay *= 2
+			}
+		default:
+			return err
+		}
+	}
+}
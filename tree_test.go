@@ -0,0 +1,80 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestTreeLock(t *testing.T) {
+	root, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"pkg/a", "pkg/b"} {
+		if err = os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := locking.NewTreeLock(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := func(rel string) *locking.TreeNode {
+		n, err := tree.Node(filepath.Join(root, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if _, err = tree.Node(filepath.Dir(root)); err != locking.ErrOutsideTree {
+		t.Errorf("got %v, wanted ErrOutsideTree", err)
+	}
+	if err = testLock(node("pkg")); err != nil {
+		t.Fatal(err)
+	}
+
+	a := node("pkg/a")
+	if err = a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	// a disjoint subtree
+	b := node("pkg/b")
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Fatalf("disjoint subtree: %t, %v", ok, err)
+	}
+	b.Unlock()
+	// ancestors and the node itself conflict
+	for _, rel := range []string{".", "pkg", "pkg/a"} {
+		if ok, err := node(rel).TryLock(); ok || err != nil {
+			t.Errorf("%s: %t, %v", rel, ok, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = node(".").LockContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted DeadlineExceeded", err)
+	}
+	a.Unlock()
+
+	// a locked parent excludes the children
+	p := node("pkg")
+	if err = p.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := node("pkg/b").TryLock(); ok || err != nil {
+		t.Errorf("child of a locked parent: %t, %v", ok, err)
+	}
+	p.Unlock()
+	b = node("pkg/b")
+	if ok, err := b.TryLock(); !ok || err != nil {
+		t.Errorf("after the release of the parent: %t, %v", ok, err)
+	}
+	b.Unlock()
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownScheme is returned by Open for an unregistered scheme
+var ErrUnknownScheme = errors.New("unknown lock URI scheme")
+
+// Opener opens the lock described by the URI
+type Opener func(u *url.URL) (Locker, error)
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = map[string]Opener{
+		"file": func(u *url.URL) (Locker, error) { return NewFLock(uriTarget(u)) },
+		"dir": func(u *url.URL) (Locker, error) {
+			lock, err := NewDirLock(uriTarget(u))
+			return lock, err
+		},
+		"port": func(u *url.URL) (Locker, error) {
+			if i := strings.IndexByte(uriTarget(u), '-'); i >= 0 {
+				lo, err := strconv.Atoi(uriTarget(u)[:i])
+				if err != nil {
+					return nil, err
+				}
+				hi, err := strconv.Atoi(uriTarget(u)[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				return NewPortLockRange(lo, hi, u.Query().Get("shuffle") != "")
+			}
+			port, err := strconv.Atoi(uriTarget(u))
+			if err != nil {
+				return nil, err
+			}
+			return NewPortLock(port), nil
+		},
+		"unix":    func(u *url.URL) (Locker, error) { return NewSocketLock(uriTarget(u)), nil },
+		"pidfile": func(u *url.URL) (Locker, error) { return NewPIDFileLock(uriTarget(u)), nil },
+		"fcntl":   func(u *url.URL) (Locker, error) { return NewFcntlLock(uriTarget(u)) },
+		"ofd":     func(u *url.URL) (Locker, error) { return NewOFDLock(uriTarget(u)) },
+		"mem": func(u *url.URL) (Locker, error) {
+			var ttl time.Duration
+			if s := u.Query().Get("ttl"); s != "" {
+				var err error
+				if ttl, err = time.ParseDuration(s); err != nil {
+					return nil, err
+				}
+			}
+			return NewMemLock(uriTarget(u), ttl), nil
+		},
+	}
+)
+
+// RegisterScheme makes Open use open for the URIs of scheme.
+// Backends in subpackages (and applications) can register their own.
+func RegisterScheme(scheme string, open Opener) {
+	schemesMu.Lock()
+	schemes[scheme] = open
+	schemesMu.Unlock()
+}
+
+// Open returns the lock described by uri, so the backend can be a
+// configuration value: file:/path (FLock), dir:/path (DirLock),
+// port:12345 (PortLock), port:8000-8099[?shuffle=1] (NewPortLockRange), unix:/path or unix:@name (SocketLock),
+// pidfile:/path (PIDFileLock), fcntl:/path (FcntlLock), ofd:/path,
+// mem:name[?ttl=30s] (MemLock), or any registered scheme - like redis://
+// of redislock and pg:// of sqllock, registered by importing them.
+// The PathPolicy (SetPathPolicy) overrides the backend of the file,
+// dir, fcntl and ofd URIs.
+func Open(uri string) (Locker, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	schemesMu.RLock()
+	open := schemes[u.Scheme]
+	schemesMu.RUnlock()
+	if open == nil {
+		return nil, &url.Error{Op: "open", URL: uri, Err: ErrUnknownScheme}
+	}
+	switch u.Scheme {
+	case "file", "dir", "fcntl", "ofd":
+		if r, ok := currentPathRule(uriTarget(u)); ok && r.Backend != u.Scheme {
+			return r.lock(uriTarget(u))
+		}
+	}
+	return open(u)
+}
+
+// uriTarget returns the path (or opaque part) of scheme:target and scheme:///target
+func uriTarget(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Path
+}
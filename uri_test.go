@@ -0,0 +1,46 @@
+package locking_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fh, err := ioutil.TempFile(dir, "flock.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+
+	for uri, want := range map[string]string{
+		"file:" + fh.Name():                 "*locking.FLock",
+		"dir://" + dir:                      "locking.DirLock",
+		"port:" + strconv.Itoa(freePort(t)): "*locking.PortLock",
+		"port:" + strconv.Itoa(freePort(t)) + "-65535?shuffle=1": "*locking.PortLock",
+	} {
+		lock, err := locking.Open(uri)
+		if err != nil {
+			t.Fatalf("%s: %v", uri, err)
+		}
+		if got := fmt.Sprintf("%T", lock); got != want {
+			t.Errorf("%s: got %s, wanted %s", uri, got, want)
+		}
+		if err = testLock(lock); err != nil {
+			t.Errorf("%s: %v", uri, err)
+		}
+	}
+	if _, err := locking.Open("nosuch:x"); !errors.Is(err, locking.ErrUnknownScheme) {
+		t.Errorf("got %v, wanted ErrUnknownScheme", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"os"
+	"time"
+)
+
+// optimistic read attempts before Read falls back to locking
+const optimisticRetries = 8
+
+// Versioned is a resource whose writers maintain a generation stamp (in
+// the path+".gen" file), so readers can read it consistently without
+// taking the lock: read the stamp, read the resource, and retry if the stamp changed.
+//
+// The stamp is a sequence counter: odd while a write is in progress.
+//
+// The writers lock path itself (through an FLock, excluding the goroutines
+// too), as the writers using NewGenerationFLock do: so the two kinds of
+// writers exclude each other. The resource must be modified in place, not
+// replaced by a rename, for the flock to stay on it.
+type Versioned struct {
+	fh   *os.File // the stamp
+	lock *FLock   // of path
+}
+
+// OpenVersioned opens (or creates) the generation stamp file of path, and
+// path itself (created empty if it does not exist)
+func OpenVersioned(path string) (*Versioned, error) {
+	fh, err := os.OpenFile(path+".gen", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := NewFLockCreate(path, 0644)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return &Versioned{fh: fh, lock: lock}, nil
+}
+
+// Close the generation stamp file, and the lock of path
+func (v *Versioned) Close() error {
+	err := v.fh.Close()
+	if cerr := v.lock.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (v *Versioned) seq() (uint64, error) { return readSeq(v.fh) }
+
+func (v *Versioned) setSeq(n uint64) error { return writeSeq(v.fh, n) }
+
+// Generation returns the number of completed writes
+func (v *Versioned) Generation() (uint64, error) {
+	n, err := v.seq()
+	return n / 2, err
+}
+
+// Write runs fn — which should modify the resource — under the exclusive lock,
+// marking the stamp as in progress before and bumping the generation after.
+func (v *Versioned) Write(fn func() error) error {
+	if err := v.lock.Lock(); err != nil {
+		return err
+	}
+	defer v.lock.Unlock()
+	n, err := v.seq()
+	if err != nil {
+		return err
+	}
+	if n%2 == 1 { // a writer crashed in the middle
+		n++
+	}
+	if err = v.setSeq(n + 1); err != nil {
+		return err
+	}
+	err = fn()
+	if serr := v.setSeq(n + 2); serr != nil && err == nil {
+		err = serr
+	}
+	return err
+}
+
+// Read runs fn — which should read the resource — without locking, until it
+// runs with no write in between. After a few conflicting attempts it falls
+// back to running fn under the lock.
+//
+// fn must cope with seeing a half-written resource: its result is thrown
+// away in that case.
+func (v *Versioned) Read(fn func() error) error {
+	for i := 0; i < optimisticRetries; i++ {
+		before, err := v.seq()
+		if err != nil {
+			return err
+		}
+		if before%2 == 1 {
+			time.Sleep(time.Millisecond << uint(i))
+			continue
+		}
+		err = fn()
+		after, serr := v.seq()
+		if serr != nil {
+			return serr
+		}
+		if after == before {
+			return err
+		}
+	}
+	if err := v.lock.RLock(); err != nil {
+		return err
+	}
+	defer v.lock.Unlock()
+	return fn()
+}
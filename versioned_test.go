@@ -0,0 +1,168 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestVersioned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+
+	w, err := locking.OpenVersioned(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := locking.OpenVersioned(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	const writes = 50
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			if err := w.Write(func() error {
+				// readers must never see the intermediate state
+				if err := ioutil.WriteFile(path, []byte("half"), 0644); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(path, []byte("full"), 0644)
+			}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < writes; i++ {
+		var data []byte
+		if err := r.Read(func() error {
+			var err error
+			data, err = ioutil.ReadFile(path)
+			return err
+		}); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if len(data) != 0 && string(data) != "full" {
+			t.Fatalf("inconsistent read %q", data)
+		}
+	}
+	wg.Wait()
+	if g, err := r.Generation(); err != nil || g != writes {
+		t.Errorf("generation=%d, %v; wanted %d", g, err, writes)
+	}
+}
+
+func TestGenerationFLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	if err = ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := locking.NewGenerationFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	// testLock takes the lock twice: with TryLock and Lock
+	if g, err := lock.Generation(); err != nil || g != 2 {
+		t.Errorf("generation=%d, %v; wanted 2", g, err)
+	}
+	v, err := locking.OpenVersioned(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if g, _ := v.Generation(); g != 2 {
+		t.Errorf("Versioned sees generation %d, wanted 2", g)
+	}
+}
+
+func TestVersionedWritersExclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	v, err := locking.OpenVersioned(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	lock, err := locking.NewGenerationFLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		inside   int
+		overlaps int
+	)
+	enter := func() {
+		mu.Lock()
+		if inside++; inside > 1 {
+			overlaps++
+		}
+		mu.Unlock()
+		time.Sleep(100 * time.Microsecond)
+		mu.Lock()
+		inside--
+		mu.Unlock()
+	}
+	const writes = 50
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() { // Versioned writers, sharing v
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if err := v.Write(func() error { enter(); return nil }); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() { // generation lock writers, sharing lock
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if err := lock.Lock(); err != nil {
+					t.Error(err)
+					return
+				}
+				enter()
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if overlaps != 0 {
+		t.Errorf("%d overlapping writes", overlaps)
+	}
+	if g, err := v.Generation(); err != nil || g != 8*writes {
+		t.Errorf("generation=%d, %v; wanted %d", g, err, 8*writes)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"context"
+	"time"
+)
+
+// watchInterval is the polling period of Watch
+var watchInterval = 100 * time.Millisecond
+
+// Watch returns a channel closed when lock appears to be free, without
+// acquiring it - for supervisors showing who waits for what. The state is
+// polled with Info; the first error is returned at once, the later ones
+// are retried. ctx stops the watching (the channel is not closed then).
+func Watch(ctx context.Context, lock Locker) (<-chan struct{}, error) {
+	i, ok := lock.(Inspector)
+	if !ok {
+		return nil, ErrNotInspectable
+	}
+	info, err := i.Info()
+	if err != nil {
+		return nil, err
+	}
+	free := make(chan struct{})
+	if !info.Locked {
+		close(free)
+		return free, nil
+	}
+	go func() {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if info, err := i.Info(); err == nil && !info.Locked {
+				close(free)
+				return
+			}
+		}
+	}()
+	return free, nil
+}
+
+// Watch returns a channel closed when the lock appears to be free (see Watch)
+func (lock *FLock) Watch(ctx context.Context) (<-chan struct{}, error) { return Watch(ctx, lock) }
+
+// Watch returns a channel closed when the lock appears to be free (see Watch)
+func (lock DirLock) Watch(ctx context.Context) (<-chan struct{}, error) { return Watch(ctx, lock) }
+
+// Watch returns a channel closed when the lock appears to be free (see Watch)
+func (p *PortLock) Watch(ctx context.Context) (<-chan struct{}, error) { return Watch(ctx, p) }
@@ -0,0 +1,77 @@
+package locking_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lock, err := locking.NewDirLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	free, err := lock.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-free:
+	default:
+		t.Error("free lock not reported at once")
+	}
+
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if free, err = lock.Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	select {
+	case <-free:
+		t.Fatal("held lock reported free")
+	default:
+	}
+	lock.Unlock()
+	select {
+	case <-free:
+	case <-time.After(5 * time.Second):
+		t.Fatal("release not noticed")
+	}
+	// the watcher did not take it
+	if ok, _ := lock.TryLock(); !ok {
+		t.Error("watched lock is held")
+	}
+	lock.Unlock()
+
+	port := locking.NewPortLock(freePort(t))
+	if err = port.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if free, err = port.Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	port.Unlock()
+	select {
+	case <-free:
+	case <-time.After(5 * time.Second):
+		t.Fatal("port release not noticed")
+	}
+
+	if _, err = locking.Watch(ctx, make(chanLock, 1)); err != locking.ErrNotInspectable {
+		t.Errorf("got %v, wanted ErrNotInspectable", err)
+	}
+}
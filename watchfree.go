@@ -0,0 +1,73 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// WatchFree watches many lock files for availability with one goroutine:
+// every interval it reads the kernel lock table (Linux only) once for all
+// paths, instead of a blocked waiter per lock. A path is sent on free when it
+// is seen unlocked at the first scan, and again each time it is seen
+// unlocked after being locked. Missing files are skipped.
+//
+// Being free is a hint: it still has to be acquired, maybe racing others.
+// stop closes free.
+func WatchFree(paths []string, interval time.Duration) (free <-chan string, stop func(), err error) {
+	if _, err = lockedInodes(); err != nil {
+		return nil, nil, err
+	}
+	ch := make(chan string, len(paths))
+	done := make(chan struct{})
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		wasFree := make(map[string]bool, len(paths))
+		for {
+			locked, err := lockedInodes()
+			if err == nil {
+				for _, path := range paths {
+					isFree, ok := fileFree(path, locked)
+					if !ok || isFree == wasFree[path] {
+						continue
+					}
+					if wasFree[path] = isFree; !isFree {
+						continue
+					}
+					select {
+					case ch <- path:
+					case <-done:
+						return
+					}
+				}
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch, func() { close(done) }, nil
+}
+
+// fileFree reports whether path has no lock in locked; ok is false if it
+// cannot be stat'ed.
+func fileFree(path string, locked map[string]struct{}) (free, ok bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, false
+	}
+	_, held := locked[inodeKey(uint64(st.Dev), uint64(st.Ino))]
+	return !held, true
+}
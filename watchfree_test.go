@@ -0,0 +1,65 @@
+package locking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestWatchFree(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the lock table is read on Linux only")
+	}
+	dir, err := ioutil.TempDir("", "lock-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	var locks []*locking.FLock
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(dir, strconv.Itoa(i))
+		lock, err := locking.NewFLockCreate(path, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			if err = lock.Lock(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		paths, locks = append(paths, path), append(locks, lock)
+	}
+	free, stop, err := locking.WatchFree(paths, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	next := func() string {
+		select {
+		case path := <-free:
+			return path
+		case <-time.After(5 * time.Second):
+			t.Fatal("no path became free")
+		}
+		return ""
+	}
+	if path := next(); path != paths[0] {
+		t.Errorf("first free %q, wanted %q", path, paths[0])
+	}
+	locks[2].Unlock()
+	if path := next(); path != paths[2] {
+		t.Errorf("got %q, wanted %q", path, paths[2])
+	}
+	locks[1].Unlock()
+	if path := next(); path != paths[1] {
+		t.Errorf("got %q, wanted %q", path, paths[1])
+	}
+}
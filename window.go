@@ -0,0 +1,100 @@
+// Copyright 2013 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package locking
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrOutsideWindow is returned when acquiring outside of Window's daily hours
+	ErrOutsideWindow = errors.New("outside of the acquisition window")
+	// ErrBlackout is returned when acquiring during one of Window.Blackouts
+	ErrBlackout = errors.New("acquisition denied during blackout")
+)
+
+// Blackout is a period when no acquisition is allowed: [From, To)
+type Blackout struct {
+	From, To time.Time
+}
+
+// Window is an acquisition policy: locks Wrapped with it can be acquired
+// only between the daily Start and End, and never in a Blackout.
+// The policy is checked before each attempt; a held lock is not released
+// when the window closes.
+type Window struct {
+	// Start and End are the offsets of the daily window from midnight, in
+	// Location (time.Local if nil). If End is before Start, the window spans
+	// midnight (22:00-02:00); if both are zero, every hour is allowed.
+	Start, End time.Duration
+	Location   *time.Location
+	Blackouts  []Blackout
+	Now        func() time.Time // time.Now if nil
+}
+
+// Wrap returns lock, with its acquisitions restricted by the window
+func (w *Window) Wrap(lock Locker) TryLocker {
+	return windowLock{Locker: lock, window: w}
+}
+
+// Allowed returns nil if acquisition is allowed at t;
+// ErrBlackout or ErrOutsideWindow if not.
+func (w *Window) Allowed(t time.Time) error {
+	for _, b := range w.Blackouts {
+		if !t.Before(b.From) && t.Before(b.To) {
+			return ErrBlackout
+		}
+	}
+	if w.Start == 0 && w.End == 0 {
+		return nil
+	}
+	loc := w.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	y, m, d := t.Date()
+	since := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, loc))
+	if w.Start <= w.End {
+		if since >= w.Start && since < w.End {
+			return nil
+		}
+	} else if since >= w.Start || since < w.End {
+		return nil
+	}
+	return ErrOutsideWindow
+}
+
+func (w *Window) check() error {
+	now := time.Now
+	if w.Now != nil {
+		now = w.Now
+	}
+	return w.Allowed(now())
+}
+
+type windowLock struct {
+	Locker
+	window *Window
+}
+
+func (l windowLock) Lock() error {
+	if err := l.window.check(); err != nil {
+		return err
+	}
+	return l.Locker.Lock()
+}
+
+func (l windowLock) TryLock() (bool, error) {
+	tl, ok := l.Locker.(TryLocker)
+	if !ok {
+		return false, ErrNoTryLock
+	}
+	if err := l.window.check(); err != nil {
+		return false, err
+	}
+	return tl.TryLock()
+}
@@ -0,0 +1,37 @@
+package locking_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+func TestWindow(t *testing.T) {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	clock := locking.NewFakeClock(day.Add(3 * time.Hour))
+	w := &locking.Window{
+		Start: 2 * time.Hour, End: 5 * time.Hour, Location: time.UTC,
+		Blackouts: []locking.Blackout{{From: day.Add(4 * time.Hour), To: day.Add(5 * time.Hour)}},
+		Now:       clock.Now,
+	}
+	lock := w.Wrap(locking.NewPortLock(freePort(t)))
+	if err := testLock(lock); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(day.Add(4 * time.Hour))
+	if err := lock.Lock(); err != locking.ErrBlackout {
+		t.Errorf("got %v, wanted ErrBlackout", err)
+	}
+	clock.Set(day.Add(6 * time.Hour))
+	if _, err := lock.TryLock(); err != locking.ErrOutsideWindow {
+		t.Errorf("got %v, wanted ErrOutsideWindow", err)
+	}
+
+	overnight := locking.Window{Start: 22 * time.Hour, End: 2 * time.Hour, Location: time.UTC}
+	for h, want := range map[int]error{23: nil, 1: nil, 12: locking.ErrOutsideWindow} {
+		if err := overnight.Allowed(day.Add(time.Duration(h) * time.Hour)); err != want {
+			t.Errorf("%02d:00: got %v, wanted %v", h, err, want)
+		}
+	}
+}